@@ -43,11 +43,11 @@ func calcCollWeight(cand *engine.Candidate, fx int64) *float64 {
 	return collW
 }
 
-func normalizeCoOccScore(v float64) *float64 {
-	if v < invalidCoOccScoreThreshold {
+func normalizeCoOccScore(v *float64) *float64 {
+	if v == nil || *v < invalidCoOccScoreThreshold {
 		return nil
 	}
-	return &v
+	return v
 }
 
 func mkCmp(result engine.FreqDistribItemList) func(i, j int) bool {
@@ -62,12 +62,96 @@ func mkCmp(result engine.FreqDistribItemList) func(i, j int) bool {
 	}
 }
 
-type Actions struct {
-	corpora *engine.CorporaConf
-	db      *sql.DB
+// getCorpusSize returns the corpus size recorded during import or,
+// in case the data have been imported by an older version, the configured one
+func getCorpusSize(cdb *engine.CollDatabase, corpusConf *engine.CorpusProps) (int64, error) {
+	size, err := cdb.GetCorpusSize()
+	if err == engine.ErrNoImportMetadata {
+		return corpusConf.Size, nil
+
+	} else if err != nil {
+		return 0, err
+	}
+	return size.NumTokens, nil
 }
 
-func (a *Actions) NounsModifiedBy(ctx *gin.Context) {
+// polarityFreqs returns a breakdown of a candidate's frequency
+// by polarity of the verb (nil if there is no polarity)
+func polarityFreqs(cand *engine.Candidate) *engine.PolarityFreqs {
+	if cand.FreqNeg == 0 && cand.FreqPos == 0 {
+		return nil
+	}
+	return &engine.PolarityFreqs{Neg: cand.FreqNeg, Pos: cand.FreqPos}
+}
+
+// getCollFilter reads additional constraints of collocations from
+// URL arguments (`childFeat`, `parentFeat`, `prep`, `subtype` and
+// `polarity`). In case the arguments are invalid, an error response
+// is written and false is returned.
+func getCollFilter(ctx *gin.Context, conf *engine.SyntaxProps) (engine.CollFilter, bool) {
+	var ans engine.CollFilter
+	for _, item := range []struct {
+		arg    string
+		attr   engine.PosAttrProps
+		target *[]string
+	}{
+		{arg: "childFeat", attr: conf.FeatsAttr, target: &ans.ChildFeats},
+		{arg: "parentFeat", attr: conf.ParFeatsAttr, target: &ans.ParentFeats},
+	} {
+		for _, value := range ctx.QueryArray(item.arg) {
+			feat, err := engine.ParseFeatFilter(value)
+			if err != nil {
+				uniresp.RespondWithErrorJSON(
+					ctx, uniresp.NewActionErrorFrom(err), http.StatusUnprocessableEntity)
+				return ans, false
+			}
+			if item.attr.Name == "" || !conf.HasFeature(feat) {
+				uniresp.RespondWithErrorJSON(
+					ctx,
+					uniresp.NewActionError("feature %s not available for `%s`", feat, item.arg),
+					http.StatusUnprocessableEntity,
+				)
+				return ans, false
+			}
+			*item.target = append(*item.target, value)
+		}
+	}
+	ans.Marker = conf.Normalization.NewNormalizer().NormalizeMarker(ctx.Query("prep"))
+	if subtype := ctx.Query("subtype"); subtype != "" {
+		if err := engine.ValidateSubtype(subtype, conf); err != nil {
+			uniresp.RespondWithErrorJSON(
+				ctx, uniresp.NewActionErrorFrom(err), http.StatusUnprocessableEntity)
+			return ans, false
+		}
+		ans.Subtype = subtype
+	}
+	polarity, err := engine.ParsePolarityFilter(ctx.Query("polarity"), conf)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(
+			ctx, uniresp.NewActionErrorFrom(err), http.StatusUnprocessableEntity)
+		return ans, false
+	}
+	ans.Polarity = polarity
+	return ans, true
+}
+
+// collRequest contains arguments shared by all the collocation
+// queries of a word
+type collRequest struct {
+	word       engine.Word
+	maxItems   int
+	corpusConf *engine.CorpusProps
+	cdb        *engine.CollDatabase
+	corpusSize int64
+	filter     engine.CollFilter
+}
+
+// getCollRequest reads the word (normalized, see
+// SyntaxProps.Normalization), the corpus, the `maxItems` argument
+// and the collocation filter (see getCollFilter). In case the arguments
+// are invalid (or the corpus size cannot be obtained), an error
+// response is written and false is returned.
+func (a *Actions) getCollRequest(ctx *gin.Context) (*collRequest, bool) {
 	w := engine.Word{V: ctx.Request.URL.Query().Get("w"), PoS: ctx.Request.URL.Query().Get("pos")}
 	if !w.IsValid() {
 		uniresp.RespondWithErrorJSON(
@@ -75,111 +159,224 @@ func (a *Actions) NounsModifiedBy(ctx *gin.Context) {
 			uniresp.NewActionError("invalid word value"),
 			http.StatusUnprocessableEntity,
 		)
-		return
+		return nil, false
 	}
 	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", 10)
 	if !ok {
-		return
+		return nil, false
 	}
 	corpusID := ctx.Param("corpusId")
 	corpusConf := a.corpora.GetCorpusProps(corpusID)
 	if corpusConf == nil {
 		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("corpus not found"), http.StatusInternalServerError)
-		return
+		return nil, false
 	}
-	// [lemma="team" & deprel="nmod" & p_upos="NOUN"]
-	cdb := engine.NewCollDatabase(a.db, corpusID)
-
-	fx, err := cdb.GetFreq(w.V, w.PoS, "", "NOUN", "nmod")
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
-		return
+	w.V = corpusConf.Syntax.Normalization.NewNormalizer().Normalize(w.V)
+	filter, ok := getCollFilter(ctx, &corpusConf.Syntax)
+	if !ok {
+		return nil, false
 	}
-
-	candidates, err := cdb.GetCollCandidatesOfChild(w.V, w.PoS, "nmod", engine.CandidatesFreqLimit)
+	cdb := engine.NewCollDatabase(a.db, corpusID)
+	corpusSize, err := getCorpusSize(cdb, corpusConf)
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
-		return
-	}
+		return nil, false
+	}
+	return &collRequest{
+		word:       w,
+		maxItems:   maxItems,
+		corpusConf: corpusConf,
+		cdb:        cdb,
+		corpusSize: corpusSize,
+		filter:     filter,
+	}, true
+}
 
+// freqDistribItems creates frequency distribution items
+// of collocation candidates sorted by their weight. The query
+// creates examples queries of candidates a query template
+// cannot be used for (see cql.NeedsOwnQuery).
+func freqDistribItems(
+	conf *engine.SyntaxProps,
+	candidates []*engine.Candidate,
+	fx, corpusSize int64,
+	maxItems int,
+	query func(collCandidate string) string,
+) engine.FreqDistribItemList {
 	result := make(engine.FreqDistribItemList, len(candidates))
 	for i, cand := range candidates {
-		item := &engine.FreqDistribItem{
+		result[i] = &engine.FreqDistribItem{
 			Word:       cand.Lemma,
 			Freq:       cand.FreqXY,
-			IPM:        float32(cand.FreqXY) / float32(corpusConf.Size) * 1e6,
+			IPM:        float32(cand.FreqXY) / float32(corpusSize) * 1e6,
 			CollWeight: calcCollWeight(cand, fx),
 			CoOccScore: normalizeCoOccScore(cand.CoOccScore),
+			Polarity:   polarityFreqs(cand),
 		}
-		result[i] = item
 	}
 	sort.SliceStable(result, mkCmp(result))
 	result = result.Cut(maxItems)
-	resp := engine.FreqDistrib{
-		Freqs:            result,
-		CorpusSize:       corpusConf.Size,
-		ExamplesQueryTpl: cql.NounsModifiedBy(&corpusConf.Syntax, w, "%s"),
+	for _, item := range result {
+		if cql.NeedsOwnQuery(conf, item.Word) {
+			item.ExamplesQuery = query(item.Word)
+		}
 	}
-	uniresp.WriteJSONResponse(
-		ctx.Writer,
-		resp,
+	return result
+}
+
+type Actions struct {
+	corpora *engine.CorporaConf
+	db      *sql.DB
+}
+
+// NounsModifiedBy provides nouns modified by the word
+func (a *Actions) NounsModifiedBy(ctx *gin.Context) {
+	a.collocations(
+		ctx,
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			// [lemma="team" & deprel="nmod" & p_upos="NOUN"]
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq(w.V, w.PoS, "", "NOUN", "nmod", filter)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfChild(
+						w.V, w.PoS, "nmod", filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.NounsModifiedBy(conf, w, collCandidate, filter)
+				},
+			}
+		},
 	)
 }
 
+// ModifiersOf provides modifiers of the word
 func (a *Actions) ModifiersOf(ctx *gin.Context) {
-	w := engine.Word{V: ctx.Request.URL.Query().Get("w"), PoS: ctx.Request.URL.Query().Get("pos")}
-	if !w.IsValid() {
-		uniresp.RespondWithErrorJSON(
-			ctx,
-			uniresp.NewActionError("invalid word value"),
-			http.StatusUnprocessableEntity,
-		)
+	a.collocations(
+		ctx,
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			// [p_lemma="team" & deprel="nmod" & upos="NOUN"]
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq("", "NOUN", w.V, w.PoS, "nmod", filter)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfParent(
+						w.V, w.PoS, "nmod", filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.ModifiersOf(conf, w, collCandidate, filter)
+				},
+			}
+		},
+	)
+}
+
+// markerGroups describes how collocations of a word are obtained
+// and listed for separate markers (see viaMarkers and collocations)
+type markerGroups struct {
+	freq       func(filter engine.CollFilter) (int64, error)
+	markers    func(filter engine.CollFilter, maxItems int) ([]engine.MarkerFreq, error)
+	candidates func(filter engine.CollFilter) ([]*engine.Candidate, error)
+	query      func(filter engine.CollFilter, collCandidate string) string
+}
+
+// viaMarkers provides collocations of a word grouped by the most
+// frequent markers. The maxMarkersArg is a name of the URL argument
+// limiting the number of markers.
+func (a *Actions) viaMarkers(
+	ctx *gin.Context,
+	maxMarkersArg string,
+	mkGroups func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups,
+) {
+	maxMarkers, ok := unireq.GetURLIntArgOrFail(ctx, maxMarkersArg, 10)
+	if !ok {
 		return
 	}
-	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", 10)
+	req, ok := a.getCollRequest(ctx)
 	if !ok {
 		return
 	}
-	corpusID := ctx.Param("corpusId")
-	corpusConf := a.corpora.GetCorpusProps(corpusID)
-	if corpusConf == nil {
-		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("corpus not found"), http.StatusInternalServerError)
+	groups := mkGroups(req.cdb, &req.corpusConf.Syntax, req.word)
+	markers, err := groups.markers(req.filter, maxMarkers)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
-	// [p_lemma="team" & deprel="nmod" & upos="NOUN"]
-	cdb := engine.NewCollDatabase(a.db, corpusID)
 
-	fx, err := cdb.GetFreq("", "NOUN", w.V, w.PoS, "nmod")
+	resp := engine.MarkerFreqDistribs{
+		CorpusSize:   req.corpusSize,
+		MatchedLemma: req.word.V,
+		Markers:      make([]*engine.MarkerFreqDistrib, len(markers)),
+	}
+	for i, marker := range markers {
+		markerFilter := req.filter
+		markerFilter.Marker = marker.Marker
+		fx, err := groups.freq(markerFilter)
+		if err != nil {
+			uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		candidates, err := groups.candidates(markerFilter)
+		if err != nil {
+			uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		resp.Markers[i] = &engine.MarkerFreqDistrib{
+			Marker: marker.Marker,
+			Freq:   marker.Freq,
+			Freqs: freqDistribItems(
+				&req.corpusConf.Syntax, candidates, fx, req.corpusSize, req.maxItems,
+				func(collCandidate string) string {
+					return groups.query(markerFilter, collCandidate)
+				},
+			),
+			ExamplesQueryTpl: groups.query(markerFilter, "%s"),
+		}
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		resp,
+	)
+}
 
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+// collocations provides collocations of a word as a single frequency
+// distribution (the `markers` function of the groups is not used)
+func (a *Actions) collocations(
+	ctx *gin.Context,
+	mkGroups func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups,
+) {
+	req, ok := a.getCollRequest(ctx)
+	if !ok {
 		return
 	}
+	a.writeFreqDistrib(ctx, req, mkGroups(req.cdb, &req.corpusConf.Syntax, req.word))
+}
 
-	candidates, err := cdb.GetCollCandidatesOfParent(w.V, w.PoS, "nmod", engine.CandidatesFreqLimit)
+// writeFreqDistrib writes a frequency distribution
+// of collocations described by the groups
+func (a *Actions) writeFreqDistrib(ctx *gin.Context, req *collRequest, groups markerGroups) {
+	fx, err := groups.freq(req.filter)
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
-
-	result := make(engine.FreqDistribItemList, len(candidates))
-	for i, cand := range candidates {
-
-		item := &engine.FreqDistribItem{
-			Word:       cand.Lemma,
-			Freq:       cand.FreqXY,
-			IPM:        float32(cand.FreqXY) / float32(corpusConf.Size) * 1e6,
-			CollWeight: calcCollWeight(cand, fx),
-			CoOccScore: normalizeCoOccScore(cand.CoOccScore),
-		}
-		result[i] = item
+	candidates, err := groups.candidates(req.filter)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
 	}
-	sort.SliceStable(result, mkCmp(result))
-	result = result.Cut(maxItems)
 	resp := engine.FreqDistrib{
-		Freqs:            result,
-		CorpusSize:       corpusConf.Size,
-		ExamplesQueryTpl: cql.ModifiersOf(&corpusConf.Syntax, w, "%s"),
+		Freqs: freqDistribItems(
+			&req.corpusConf.Syntax, candidates, fx, req.corpusSize, req.maxItems,
+			func(collCandidate string) string {
+				return groups.query(req.filter, collCandidate)
+			},
+		),
+		CorpusSize:       req.corpusSize,
+		MatchedLemma:     req.word.V,
+		ExamplesQueryTpl: groups.query(req.filter, "%s"),
 	}
 	uniresp.WriteJSONResponse(
 		ctx.Writer,
@@ -187,60 +384,345 @@ func (a *Actions) ModifiersOf(ctx *gin.Context) {
 	)
 }
 
+// NounsModifiedByViaPrep provides nouns modified by the word
+// grouped by prepositions attached to the word
+func (a *Actions) NounsModifiedByViaPrep(ctx *gin.Context) {
+	a.viaMarkers(
+		ctx,
+		"maxPreps",
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq(w.V, w.PoS, "", "NOUN", "nmod", filter)
+				},
+				markers: func(filter engine.CollFilter, maxItems int) ([]engine.MarkerFreq, error) {
+					return cdb.GetMarkers(w.V, w.PoS, "", "NOUN", "nmod", filter, maxItems)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfChild(
+						w.V, w.PoS, "nmod", filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.NounsModifiedBy(conf, w, collCandidate, filter)
+				},
+			}
+		},
+	)
+}
+
+// ModifiersOfViaPrep provides modifiers of the word grouped
+// by prepositions attached to the modifiers
+func (a *Actions) ModifiersOfViaPrep(ctx *gin.Context) {
+	a.viaMarkers(
+		ctx,
+		"maxPreps",
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq("", "NOUN", w.V, w.PoS, "nmod", filter)
+				},
+				markers: func(filter engine.CollFilter, maxItems int) ([]engine.MarkerFreq, error) {
+					return cdb.GetMarkers("", "NOUN", w.V, w.PoS, "nmod", filter, maxItems)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfParent(
+						w.V, w.PoS, "nmod", filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.ModifiersOf(conf, w, collCandidate, filter)
+				},
+			}
+		},
+	)
+}
+
 // VerbsSubject
 func (a *Actions) VerbsSubject(ctx *gin.Context) {
-	w := engine.Word{V: ctx.Request.URL.Query().Get("w"), PoS: ctx.Request.URL.Query().Get("pos")}
-	if !w.IsValid() {
+	a.collocations(
+		ctx,
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			// [lemma="team" & deprel="nsubj" & p_upos="VERB"]
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq(w.V, w.PoS, "", "VERB", "nsubj", filter)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfChild(
+						w.V, w.PoS, "nsubj", filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.VerbsSubject(conf, w, collCandidate, filter)
+				},
+			}
+		},
+	)
+}
+
+// VerbsObject
+func (a *Actions) VerbsObject(ctx *gin.Context) {
+	a.collocations(
+		ctx,
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			// [lemma="team" & deprel="obj|iobj" & p_upos="VERB"]
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq(w.V, w.PoS, "", "VERB", "obj|iobj", filter)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfChild(
+						w.V, w.PoS, "obj|iobj", filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.VerbsObject(conf, w, collCandidate, filter)
+				},
+			}
+		},
+	)
+}
+
+// requireRelation tests whether an optional relation (e.g.
+// engine.SyntaxProps.ObliqueValue) is configured for the requested
+// corpus. If not, an error response is written and false is returned.
+// Unknown corpora are left to getCollRequest.
+func (a *Actions) requireRelation(
+	ctx *gin.Context,
+	desc string,
+	relation func(conf *engine.SyntaxProps) string,
+) bool {
+	corpusConf := a.corpora.GetCorpusProps(ctx.Param("corpusId"))
+	if corpusConf != nil && relation(&corpusConf.Syntax) == "" {
 		uniresp.RespondWithErrorJSON(
 			ctx,
-			uniresp.NewActionError("invalid word value"),
+			uniresp.NewActionError("%s not available for corpus %s", desc, ctx.Param("corpusId")),
 			http.StatusUnprocessableEntity,
 		)
-		return
+		return false
 	}
-	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", 10)
-	if !ok {
+	return true
+}
+
+func obliqueValue(conf *engine.SyntaxProps) string {
+	return conf.ObliqueValue
+}
+
+func conjValue(conf *engine.SyntaxProps) string {
+	return conf.ConjValue
+}
+
+// obliqueArgs provides oblique arguments of verbs (e.g. `depend on`).
+// In case wordIsParent is false, the word is the oblique argument and
+// the governing verbs are listed. The `prep` argument optionally
+// restricts the pairs to a preposition.
+func (a *Actions) obliqueArgs(ctx *gin.Context, wordIsParent bool) {
+	if !a.requireRelation(ctx, "oblique arguments", obliqueValue) {
 		return
 	}
-	corpusID := ctx.Param("corpusId")
-	corpusConf := a.corpora.GetCorpusProps(corpusID)
-	if corpusConf == nil {
-		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("corpus not found"), http.StatusInternalServerError)
+	a.collocations(
+		ctx,
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			return obliqueGroups(cdb, conf, w, wordIsParent)
+		},
+	)
+}
+
+// obliqueGroups describes oblique arguments of verbs split
+// by prepositions (see obliqueArgs for the wordIsParent meaning)
+func obliqueGroups(
+	cdb *engine.CollDatabase,
+	conf *engine.SyntaxProps,
+	w engine.Word,
+	wordIsParent bool,
+) markerGroups {
+	if wordIsParent {
+		return markerGroups{
+			freq: func(filter engine.CollFilter) (int64, error) {
+				return cdb.GetFreq("", "", w.V, w.PoS, conf.ObliqueValue, filter)
+			},
+			markers: func(filter engine.CollFilter, maxItems int) ([]engine.MarkerFreq, error) {
+				return cdb.GetMarkers("", "", w.V, w.PoS, conf.ObliqueValue, filter, maxItems)
+			},
+			candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+				return cdb.GetCollCandidatesOfParent(
+					w.V, w.PoS, conf.ObliqueValue, filter, engine.CandidatesFreqLimit)
+			},
+			query: func(filter engine.CollFilter, collCandidate string) string {
+				return cql.ObliquesOf(conf, w, collCandidate, filter)
+			},
+		}
+	}
+	return markerGroups{
+		freq: func(filter engine.CollFilter) (int64, error) {
+			return cdb.GetFreq(w.V, w.PoS, "", conf.VerbValue, conf.ObliqueValue, filter)
+		},
+		markers: func(filter engine.CollFilter, maxItems int) ([]engine.MarkerFreq, error) {
+			return cdb.GetMarkers(w.V, w.PoS, "", conf.VerbValue, conf.ObliqueValue, filter, maxItems)
+		},
+		candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+			return cdb.GetCollCandidatesOfChild(
+				w.V, w.PoS, conf.ObliqueValue, filter, engine.CandidatesFreqLimit)
+		},
+		query: func(filter engine.CollFilter, collCandidate string) string {
+			return cql.VerbsOblique(conf, w, collCandidate, filter)
+		},
+	}
+}
+
+// VerbsOblique provides verbs governing the word as their
+// oblique argument (e.g. `depend` for `on` + `weather`)
+func (a *Actions) VerbsOblique(ctx *gin.Context) {
+	a.obliqueArgs(ctx, false)
+}
+
+// ObliquesOf provides oblique arguments of the word (a verb)
+func (a *Actions) ObliquesOf(ctx *gin.Context) {
+	a.obliqueArgs(ctx, true)
+}
+
+// VerbsObliqueViaPrep provides verbs governing the word as their
+// oblique argument grouped by prepositions attached to the word
+func (a *Actions) VerbsObliqueViaPrep(ctx *gin.Context) {
+	if !a.requireRelation(ctx, "oblique arguments", obliqueValue) {
 		return
 	}
-	// [lemma="team" & deprel="nsubj" & p_upos="VERB"]
-	cdb := engine.NewCollDatabase(a.db, corpusID)
+	a.viaMarkers(
+		ctx,
+		"maxPreps",
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			return obliqueGroups(cdb, conf, w, false)
+		},
+	)
+}
 
-	fx, err := cdb.GetFreq(w.V, w.PoS, "", "VERB", "nsubj")
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+// ObliquesOfViaPrep provides oblique arguments of the word (a verb)
+// grouped by prepositions attached to the arguments
+func (a *Actions) ObliquesOfViaPrep(ctx *gin.Context) {
+	if !a.requireRelation(ctx, "oblique arguments", obliqueValue) {
 		return
 	}
+	a.viaMarkers(
+		ctx,
+		"maxPreps",
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			return obliqueGroups(cdb, conf, w, true)
+		},
+	)
+}
 
-	candidates, err := cdb.GetCollCandidatesOfChild(w.V, w.PoS, "nsubj", engine.CandidatesFreqLimit)
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+// verbsDeep provides verbs governing the word by a deep relation
+// (see engine.DeepObjectValue and engine.DeepSubjectValue).
+// The query creates an examples query of a candidate.
+func (a *Actions) verbsDeep(
+	ctx *gin.Context,
+	deprel string,
+	query func(
+		conf *engine.SyntaxProps,
+		word engine.Word,
+		collCandidate string,
+		filter engine.CollFilter,
+	) string,
+) {
+	req, ok := a.getCollRequest(ctx)
+	if !ok {
 		return
 	}
+	conf := &req.corpusConf.Syntax
+	if !conf.DeepRelations {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			uniresp.NewActionError("deep relations not available for corpus %s", ctx.Param("corpusId")),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	if req.filter.Subtype != "" {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			uniresp.NewActionError("relation subtypes not available for deep relations"),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	w := req.word
+	a.writeFreqDistrib(ctx, req, markerGroups{
+		freq: func(filter engine.CollFilter) (int64, error) {
+			return req.cdb.GetFreq(w.V, w.PoS, "", conf.VerbValue, deprel, filter)
+		},
+		candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+			return req.cdb.GetCollCandidatesOfChild(
+				w.V, w.PoS, deprel, filter, engine.CandidatesFreqLimit)
+		},
+		query: func(filter engine.CollFilter, collCandidate string) string {
+			return query(conf, w, collCandidate, filter)
+		},
+	})
+}
 
-	result := make(engine.FreqDistribItemList, len(candidates))
-	for i, cand := range candidates {
+// VerbsDeepObject provides verbs having the word as their logical
+// object, i.e. objects of active verbs along with subjects of passive
+// ones (e.g. `make` for both `make a decision` and `the decision
+// was made`)
+func (a *Actions) VerbsDeepObject(ctx *gin.Context) {
+	a.verbsDeep(ctx, engine.DeepObjectValue, cql.VerbsDeepObject)
+}
 
-		item := &engine.FreqDistribItem{
-			Word:       cand.Lemma,
-			Freq:       cand.FreqXY,
-			IPM:        float32(cand.FreqXY) / float32(corpusConf.Size) * 1e6,
-			CollWeight: calcCollWeight(cand, fx),
-			CoOccScore: normalizeCoOccScore(cand.CoOccScore),
+// VerbsDeepSubject provides verbs having the word as their logical
+// subject, i.e. subjects of active verbs along with agents of passive
+// ones (e.g. `make` for both `the board made` and `made by the board`)
+func (a *Actions) VerbsDeepSubject(ctx *gin.Context) {
+	a.verbsDeep(ctx, engine.DeepSubjectValue, cql.VerbsDeepSubject)
+}
+
+// Triples provides head-centered triples (e.g. `take part in`,
+// see engine.TriplePattern) of the word ordered by their score.
+// The `pattern` argument optionally selects a single kind of triples.
+func (a *Actions) Triples(ctx *gin.Context) {
+	req, ok := a.getCollRequest(ctx)
+	if !ok {
+		return
+	}
+	w, corpusConf := req.word, req.corpusConf
+	patternName := ctx.Query("pattern")
+	if patternName != "" {
+		if _, ok := corpusConf.Syntax.TriplePattern(patternName); !ok {
+			uniresp.RespondWithErrorJSON(
+				ctx,
+				uniresp.NewActionError("unknown triple pattern `%s`", patternName),
+				http.StatusUnprocessableEntity,
+			)
+			return
 		}
-		result[i] = item
 	}
-	sort.SliceStable(result, mkCmp(result))
-	result = result.Cut(maxItems)
-	resp := engine.FreqDistrib{
-		Freqs:            result,
-		CorpusSize:       corpusConf.Size,
-		ExamplesQueryTpl: cql.VerbsSubject(&corpusConf.Syntax, w, "%s"),
+	triples, err := req.cdb.GetTriples(
+		w.V, w.PoS, patternName, engine.CandidatesFreqLimit, req.maxItems)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	resp := engine.TripleList{
+		CorpusSize:   req.corpusSize,
+		MatchedLemma: w.V,
+		Triples:      make([]*engine.TripleItem, 0, len(triples)),
+	}
+	for _, triple := range triples {
+		pattern, ok := corpusConf.Syntax.TriplePattern(triple.Pattern)
+		if !ok {
+			// stored by a different version of scollex
+			continue
+		}
+		resp.Triples = append(resp.Triples, &engine.TripleItem{
+			Pattern:       triple.Pattern,
+			Lemma:         triple.Lemma,
+			Upos:          triple.Upos,
+			Lemma1:        triple.Lemma1,
+			Upos1:         triple.Upos1,
+			Lemma2:        triple.Lemma2,
+			Upos2:         triple.Upos2,
+			Marker:        triple.Marker,
+			Freq:          triple.Freq,
+			IPM:           float32(triple.Freq) / float32(req.corpusSize) * 1e6,
+			Score:         normalizeCoOccScore(&triple.Score),
+			ExamplesQuery: cql.Triple(&corpusConf.Syntax, pattern, triple),
+		})
 	}
 	uniresp.WriteJSONResponse(
 		ctx.Writer,
@@ -248,64 +730,86 @@ func (a *Actions) VerbsSubject(ctx *gin.Context) {
 	)
 }
 
-// VerbsObject
-func (a *Actions) VerbsObject(ctx *gin.Context) {
-	w := engine.Word{V: ctx.Request.URL.Query().Get("w"), PoS: ctx.Request.URL.Query().Get("pos")}
-	if !w.IsValid() {
-		uniresp.RespondWithErrorJSON(
-			ctx,
-			uniresp.NewActionError("invalid word value"),
-			http.StatusUnprocessableEntity,
-		)
+// CoordinatedWith provides words typically coordinated with the word
+// (e.g. `salt` and `pepper`). Coordination is symmetric so the word
+// order does not matter. The `conj` argument optionally restricts
+// the pairs to a coordinating conjunction.
+func (a *Actions) CoordinatedWith(ctx *gin.Context) {
+	if !a.requireRelation(ctx, "coordinations", conjValue) {
 		return
 	}
-	maxItems, ok := unireq.GetURLIntArgOrFail(ctx, "maxItems", 10)
+	req, ok := a.getCollRequest(ctx)
 	if !ok {
 		return
 	}
+	conf := &req.corpusConf.Syntax
+	req.filter.Marker = conf.Normalization.NewNormalizer().NormalizeMarker(ctx.Query("conj"))
+	w := req.word
+	a.writeFreqDistrib(ctx, req, markerGroups{
+		freq: func(filter engine.CollFilter) (int64, error) {
+			return req.cdb.GetFreq(w.V, w.PoS, "", "", conf.ConjValue, filter)
+		},
+		candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+			return req.cdb.GetCollCandidatesOfChild(
+				w.V, w.PoS, conf.ConjValue, filter, engine.CandidatesFreqLimit)
+		},
+		query: func(filter engine.CollFilter, collCandidate string) string {
+			return cql.CoordinatedWith(conf, w, collCandidate, filter)
+		},
+	})
+}
+
+// CoordinatedWithViaConj provides words coordinated with the word
+// grouped by coordinating conjunctions (e.g. `and`, `or`)
+func (a *Actions) CoordinatedWithViaConj(ctx *gin.Context) {
+	if !a.requireRelation(ctx, "coordinations", conjValue) {
+		return
+	}
+	a.viaMarkers(
+		ctx,
+		"maxConjs",
+		func(cdb *engine.CollDatabase, conf *engine.SyntaxProps, w engine.Word) markerGroups {
+			return markerGroups{
+				freq: func(filter engine.CollFilter) (int64, error) {
+					return cdb.GetFreq(w.V, w.PoS, "", "", conf.ConjValue, filter)
+				},
+				markers: func(filter engine.CollFilter, maxItems int) ([]engine.MarkerFreq, error) {
+					return cdb.GetMarkers(w.V, w.PoS, "", "", conf.ConjValue, filter, maxItems)
+				},
+				candidates: func(filter engine.CollFilter) ([]*engine.Candidate, error) {
+					return cdb.GetCollCandidatesOfChild(
+						w.V, w.PoS, conf.ConjValue, filter, engine.CandidatesFreqLimit)
+				},
+				query: func(filter engine.CollFilter, collCandidate string) string {
+					return cql.CoordinatedWith(conf, w, collCandidate, filter)
+				},
+			}
+		},
+	)
+}
+
+// CorpusInfo provides metadata of imported data of a corpus
+// so users can cite the actual version of the dataset
+func (a *Actions) CorpusInfo(ctx *gin.Context) {
 	corpusID := ctx.Param("corpusId")
 	corpusConf := a.corpora.GetCorpusProps(corpusID)
 	if corpusConf == nil {
-		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("corpus not found"), http.StatusInternalServerError)
+		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("corpus not found"), http.StatusNotFound)
 		return
 	}
-	// [lemma="team" & deprel="obj|iobj" & p_upos="VERB"]
 	cdb := engine.NewCollDatabase(a.db, corpusID)
-
-	fx, err := cdb.GetFreq(w.V, w.PoS, "", "VERB", "obj|iobj")
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+	info, err := cdb.GetCorpusInfo()
+	if err == engine.ErrNoImportMetadata {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusNotFound)
 		return
-	}
 
-	candidates, err := cdb.GetCollCandidatesOfChild(w.V, w.PoS, "obj|iobj", engine.CandidatesFreqLimit)
-	if err != nil {
+	} else if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
-
-	result := make(engine.FreqDistribItemList, len(candidates))
-	for i, cand := range candidates {
-
-		item := &engine.FreqDistribItem{
-			Word:       cand.Lemma,
-			Freq:       cand.FreqXY,
-			IPM:        float32(cand.FreqXY) / float32(corpusConf.Size) * 1e6,
-			CollWeight: calcCollWeight(cand, fx),
-			CoOccScore: normalizeCoOccScore(cand.CoOccScore),
-		}
-		result[i] = item
-	}
-	sort.SliceStable(result, mkCmp(result))
-	result = result.Cut(maxItems)
-	resp := engine.FreqDistrib{
-		Freqs:            result,
-		CorpusSize:       corpusConf.Size,
-		ExamplesQueryTpl: cql.VerbsObject(&corpusConf.Syntax, w, "%s"),
-	}
 	uniresp.WriteJSONResponse(
 		ctx.Writer,
-		resp,
+		info,
 	)
 }
 
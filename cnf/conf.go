@@ -33,6 +33,7 @@ const (
 	dfltMaxNumConcurrentJobs   = 4
 	dfltVertMaxNumErrors       = 100
 	dfltTimeZone               = "Europe/Prague"
+	dfltRollbackWindowHours    = 24
 )
 
 // Conf is a global configuration of the app
@@ -48,6 +49,24 @@ type Conf struct {
 	Language               string              `json:"language"`
 	TimeZone               string              `json:"timeZone"`
 
+	// MaxNumConcurrentJobs specifies a default number of parallel
+	// workers used when importing data
+	MaxNumConcurrentJobs int `json:"maxNumConcurrentJobs"`
+
+	// RollbackWindowHours specifies how long a previous generation
+	// of corpus tables is kept after a re-import (see the `rollback`
+	// command). Zero means the previous generation is dropped
+	// by the next cleanup so no rollback window is provided.
+	// If not specified, dfltRollbackWindowHours is used.
+	RollbackWindowHours *int `json:"rollbackWindowHours"`
+
+	// VertMaxNumErrors specifies how many malformed lines of
+	// an imported vertical file are tolerated before the import
+	// is aborted (zero means the import fails on the first error,
+	// -1 means no limit). If not specified, dfltVertMaxNumErrors
+	// is used.
+	VertMaxNumErrors *int `json:"vertMaxNumErrors"`
+
 	srcPath string
 }
 
@@ -102,12 +121,48 @@ func ValidateAndDefaults(conf *Conf) {
 		conf.Language = dfltLanguage
 		log.Warn().Msgf("language not specified, using default: %s", conf.Language)
 	}
+	if conf.MaxNumConcurrentJobs == 0 {
+		conf.MaxNumConcurrentJobs = dfltMaxNumConcurrentJobs
+		log.Warn().Msgf(
+			"maxNumConcurrentJobs not specified, using default: %d",
+			dfltMaxNumConcurrentJobs,
+		)
+	}
+	if conf.RollbackWindowHours == nil {
+		v := dfltRollbackWindowHours
+		conf.RollbackWindowHours = &v
+		log.Warn().Msgf(
+			"rollbackWindowHours not specified, using default: %d",
+			dfltRollbackWindowHours,
+		)
+
+	} else if *conf.RollbackWindowHours < 0 {
+		log.Fatal().Msgf(
+			"invalid rollbackWindowHours %d, expected a non-negative value",
+			*conf.RollbackWindowHours,
+		)
+	}
+	if conf.VertMaxNumErrors == nil {
+		v := dfltVertMaxNumErrors
+		conf.VertMaxNumErrors = &v
+		log.Warn().Msgf(
+			"vertMaxNumErrors not specified, using default: %d",
+			dfltVertMaxNumErrors,
+		)
+
+	} else if *conf.VertMaxNumErrors < -1 {
+		log.Fatal().Msgf(
+			"invalid vertMaxNumErrors %d, expected -1 (no limit) or a non-negative value",
+			*conf.VertMaxNumErrors,
+		)
+	}
 	for _, corpConf := range conf.Corpora {
 		if err := corpConf.ValidateAndDefaults("corpora"); err != nil {
 			log.Fatal().Err(err).Msg("invalid configuration")
 		}
 	}
 	if conf.TimeZone == "" {
+		conf.TimeZone = dfltTimeZone
 		log.Warn().
 			Str("timeZone", dfltTimeZone).
 			Msg("time zone not specified, using default")
@@ -18,86 +18,480 @@ package cql
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/czcorpus/scollex/engine"
 )
 
-func NounsModifiedBy(conf *engine.SyntaxProps, word engine.Word, collCandidate string) string {
-	if word.PoS == "" {
-		return fmt.Sprintf(
-			"[%s=\"%s\" & %s=\"%s\" & %s=\"%s\" & %s=\"%s\"]",
-			conf.LemmaAttr.Name, word.V,
-			conf.ParLemmaAttr.Name, collCandidate,
-			conf.FuncAttr.Name, conf.NounModifiedValue,
-			conf.ParPosAttr.Name, conf.NounValue,
-		)
+const (
+	// maxMarkerDistance specifies how many tokens (e.g. determiners
+	// or adjectives) may be located between a marker and its noun
+	maxMarkerDistance = 3
+
+	// maxTripleDistance specifies how far from each other
+	// dependents of a triple may be located
+	maxTripleDistance = 10
+)
+
+// featsConstraints creates CQL constraints matching tokens
+// whose features attribute contains all the provided features
+func featsConstraints(attr string, feats []string) []string {
+	ans := make([]string, len(feats))
+	for i, feat := range feats {
+		ans[i] = fmt.Sprintf(`%s="(.*\|)?%s(\|.*)?"`, attr, regexp.QuoteMeta(feat))
+	}
+	return ans
+}
+
+// polarityConstraints creates CQL constraints matching a polarity
+// of the parent verb via the parent features attribute. Only the
+// negative feature (see PolarityProps.NegFeature) can be matched
+// this way so words negating verbs (e.g. `not`) are not reflected.
+func polarityConstraints(conf *engine.SyntaxProps, filter engine.CollFilter) []string {
+	if filter.Polarity == "" || conf.Polarity.NegFeature == "" || conf.ParFeatsAttr.Name == "" {
+		return nil
+	}
+	op := "="
+	if filter.Polarity == engine.PolarityPos {
+		op = "!="
+	}
+	return []string{fmt.Sprintf(
+		`%s%s"(.*\|)?%s(\|.*)?"`,
+		conf.ParFeatsAttr.Name, op, regexp.QuoteMeta(conf.Polarity.NegFeature),
+	)}
+}
+
+// deprelRegexp creates a regular expression matching relations
+// of a deprel expression (e.g. `obj|iobj`). Relations without
+// a subtype match also all their subtypes (`nmod` => `nmod(:.*)?`),
+// a non-empty subtype restricts all the relations to the subtype.
+func deprelRegexp(expr, subtype string) string {
+	rels := strings.Split(engine.DeprelWithSubtype(expr, subtype), "|")
+	for i, rel := range rels {
+		rels[i] = regexp.QuoteMeta(rel)
+		if !strings.Contains(rel, ":") {
+			rels[i] += "(:.*)?"
+		}
+	}
+	if len(rels) == 1 {
+		return rels[0]
 	}
+	return fmt.Sprintf("(%s)", strings.Join(rels, "|"))
+}
+
+// markerToken creates a query matching a single word of a marker
+// attached by a relation (e.g. `case`)
+func markerToken(conf *engine.SyntaxProps, relation, word string) string {
 	return fmt.Sprintf(
-		"[%s=\"%s\" & %s=\"%s\" & %s=\"%s\" & %s=\"%s\" & %s=\"%s\"]",
-		conf.LemmaAttr.Name, word.V,
-		conf.PosAttr.Name, word.PoS,
-		conf.ParLemmaAttr.Name, collCandidate,
-		conf.FuncAttr.Name, conf.NounModifiedValue,
-		conf.ParPosAttr.Name, conf.NounValue,
+		`[%s="%s" & %s="%s"]`,
+		conf.LemmaAttr.Name, wordRegexp(conf, word),
+		conf.FuncAttr.Name, deprelRegexp(relation, ""),
 	)
 }
 
-func ModifiersOf(conf *engine.SyntaxProps, word engine.Word, collCandidate string) string {
-	if word.PoS == "" {
-		return fmt.Sprintf(
-			"[%s=\"%s\" & %s=\"%s\" & %s=\"%s\" & %s=\"%s\"]",
-			conf.ParLemmaAttr.Name, word.V,
-			conf.FuncAttr.Name, conf.NounModifiedValue,
-			conf.PosAttr.Name, conf.NounValue,
-			conf.LemmaAttr.Name, collCandidate,
+// markerTokens creates a query matching a marker (possibly
+// consisting of multiple words, e.g. `out of`) attached to a noun
+func markerTokens(conf *engine.SyntaxProps, marker string) string {
+	words := strings.Split(marker, " ")
+	ans := make([]string, len(words))
+	for i, w := range words {
+		ans[i] = markerToken(conf, conf.CaseValue, w)
+	}
+	return strings.Join(ans, " ")
+}
+
+// withFilter adds constraints of a collocation filter to
+// a single token query. As the query always matches the child,
+// features of the parent (and its polarity) are matched via the parent
+// features attribute. A marker is matched as preceding tokens (i.e.
+// a preposition) within the same sentence.
+func withFilter(conf *engine.SyntaxProps, filter engine.CollFilter, query string) string {
+	constraints := append(
+		featsConstraints(conf.FeatsAttr.Name, filter.ChildFeats),
+		featsConstraints(conf.ParFeatsAttr.Name, filter.ParentFeats)...,
+	)
+	constraints = append(constraints, polarityConstraints(conf, filter)...)
+	if len(constraints) > 0 {
+		query = fmt.Sprintf("%s & %s]", query[:len(query)-1], strings.Join(constraints, " & "))
+	}
+	return withMarker(conf, filter, query)
+}
+
+// withMarker adds a marker of a collocation filter to a query
+// matching the child. The marker is matched as preceding tokens
+// (i.e. a preposition) within the same sentence.
+func withMarker(conf *engine.SyntaxProps, filter engine.CollFilter, query string) string {
+	if filter.Marker != "" {
+		query = fmt.Sprintf(
+			"%s []{0,%d} %s within <%s/>",
+			markerTokens(conf, filter.Marker), maxMarkerDistance, query, conf.SentenceStruct,
 		)
 	}
+	return query
+}
+
+// NeedsOwnQuery tells whether a collocation candidate cannot be
+// matched by a query template (i.e. by substituting the `%s`
+// placeholders) and a query has to be created for the candidate.
+// This applies to multiwords (see SyntaxProps.MergeMultiwords)
+// which are matched as sequences of tokens and to lemmas with
+// known variants (see NormalizationProps.LemmaForms).
+func NeedsOwnQuery(conf *engine.SyntaxProps, lemma string) bool {
+	return (conf.MergeMultiwords && strings.Contains(lemma, " ")) ||
+		len(conf.Normalization.LemmaForms(lemma)) > 1
+}
+
+// wordRegexp creates a regular expression matching a single word
+// of a normalized lemma (see SyntaxProps.Normalization) in a corpus
+// containing the original lemmas. Known variants of the lemma are
+// matched as alternatives and case folded lemmas are matched
+// case-insensitively.
+func wordRegexp(conf *engine.SyntaxProps, word string) string {
+	return formsRegexp(conf, conf.Normalization.LemmaForms(word))
+}
+
+// formsRegexp creates a regular expression matching any of the forms
+// of a normalized lemma (see wordRegexp). The forms are quoted in place.
+func formsRegexp(conf *engine.SyntaxProps, forms []string) string {
+	for i, form := range forms {
+		forms[i] = regexp.QuoteMeta(form)
+	}
+	ans := forms[0]
+	if len(forms) > 1 {
+		ans = fmt.Sprintf("(%s)", strings.Join(forms, "|"))
+	}
+	if conf.Normalization.CaseFolding {
+		ans = "(?i)" + ans
+	}
+	return ans
+}
+
+// lemmaRegexp creates a regular expression matching a lemma
+// attribute of a (possibly multiword, see SyntaxProps.MergeMultiwords)
+// normalized lemma (see wordRegexp). As the attribute of a multiword
+// contains just one of its words, any of the words is matched.
+func lemmaRegexp(conf *engine.SyntaxProps, lemma string) string {
+	var forms []string
+	for _, w := range strings.Split(lemma, " ") {
+		forms = append(forms, conf.Normalization.LemmaForms(w)...)
+	}
+	return formsRegexp(conf, forms)
+}
+
+// childQuery creates a query matching the word (a headword or
+// a collocation candidate) as a child along with constraints of the
+// word's token and a collocation filter. A multiword (see
+// SyntaxProps.MergeMultiwords) is matched as a sequence of tokens any
+// of which may be the head carrying the constraints.
+func childQuery(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	filter engine.CollFilter,
+	constraints ...string,
+) string {
+	head := func(lemma string) string {
+		ans := []string{fmt.Sprintf(`%s="%s"`, conf.LemmaAttr.Name, lemma)}
+		if word.PoS != "" {
+			ans = append(ans, fmt.Sprintf(`%s="%s"`, conf.PosAttr.Name, word.PoS))
+		}
+		ans = append(ans, constraints...)
+		ans = append(ans, featsConstraints(conf.FeatsAttr.Name, filter.ChildFeats)...)
+		ans = append(ans, featsConstraints(conf.ParFeatsAttr.Name, filter.ParentFeats)...)
+		ans = append(ans, polarityConstraints(conf, filter)...)
+		return fmt.Sprintf("[%s]", strings.Join(ans, " & "))
+	}
+	words := strings.Split(word.V, " ")
+	if len(words) == 1 {
+		return withMarker(conf, filter, head(wordRegexp(conf, word.V)))
+	}
+	variants := make([]string, len(words))
+	for k := range words {
+		tokens := make([]string, len(words))
+		for i, w := range words {
+			if i == k {
+				tokens[i] = head(wordRegexp(conf, w))
+
+			} else {
+				tokens[i] = fmt.Sprintf(`[%s="%s"]`, conf.LemmaAttr.Name, wordRegexp(conf, w))
+			}
+		}
+		variants[k] = strings.Join(tokens, " ")
+	}
+	return withMarker(conf, filter, fmt.Sprintf("(%s)", strings.Join(variants, " | ")))
+}
+
+func NounsModifiedBy(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	return childQuery(
+		conf, word, filter,
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, collCandidate)),
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.NounModifiedValue, filter.Subtype)),
+		fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, conf.NounValue),
+	)
+}
+
+func ModifiersOf(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	constraints := []string{
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, word.V)),
+	}
+	if word.PoS != "" {
+		constraints = append(
+			constraints, fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, word.PoS))
+	}
+	constraints = append(
+		constraints,
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.NounModifiedValue, filter.Subtype)),
+	)
+	return childQuery(
+		conf, engine.Word{V: collCandidate, PoS: conf.NounValue}, filter, constraints...)
+}
+
+func VerbsObject(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	return childQuery(
+		conf, word, filter,
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.NounObjectValue, filter.Subtype)),
+		fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, conf.VerbValue),
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, collCandidate)),
+	)
+}
+
+func VerbsSubject(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	return childQuery(
+		conf, word, filter,
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.NounSubjectValue, filter.Subtype)),
+		fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, conf.VerbValue),
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, collCandidate)),
+	)
+}
+
+// passiveConstraint creates a CQL constraint matching children
+// of passive verbs via the parent features attribute (an empty
+// string if the attribute is not configured)
+func passiveConstraint(conf *engine.SyntaxProps, op string) string {
+	if conf.ParFeatsAttr.Name == "" {
+		return ""
+	}
 	return fmt.Sprintf(
-		"[%s=\"%s\" & %s=\"%s\" & %s=\"%s\" & %s=\"%s\" & %s=\"%s\"]",
-		conf.ParLemmaAttr.Name, word.V,
-		conf.ParPosAttr.Name, word.PoS,
-		conf.FuncAttr.Name, conf.NounModifiedValue,
-		conf.PosAttr.Name, conf.NounValue,
-		conf.LemmaAttr.Name, collCandidate,
+		`%s%s"(.*\|)?%s(\|.*)?"`,
+		conf.ParFeatsAttr.Name, op, regexp.QuoteMeta(conf.PassiveFeature),
 	)
 }
 
-func VerbsObject(conf *engine.SyntaxProps, word engine.Word, collCandidate string) string {
-	if word.PoS == "" {
-		return fmt.Sprintf(
-			`[%s="%s" & %s="%s" & %s="%s" & %s="%s"]`,
-			conf.LemmaAttr.Name, word.V,
-			conf.FuncAttr.Name, conf.NounObjectValue,
-			conf.ParPosAttr.Name, conf.VerbValue,
-			conf.ParLemmaAttr.Name, collCandidate,
-		)
+// deepObjectConstraint creates a CQL constraint matching logical
+// objects (see engine.DeepObjectValue) by their surface relations
+func deepObjectConstraint(conf *engine.SyntaxProps) string {
+	alts := []string{
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.NounObjectValue, "")),
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.PassiveSubjectValue, "")),
+	}
+	if passive := passiveConstraint(conf, "="); passive != "" {
+		alts = append(alts, fmt.Sprintf(
+			`(%s="%s" & %s)`,
+			conf.FuncAttr.Name, deprelRegexp(conf.NounSubjectValue, ""), passive,
+		))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(alts, " | "))
+}
+
+// deepSubjectConstraint creates a CQL constraint matching logical
+// subjects (see engine.DeepSubjectValue) by their surface relations
+func deepSubjectConstraint(conf *engine.SyntaxProps) string {
+	active := []string{
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.NounSubjectValue, "")),
+		fmt.Sprintf(`%s!="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.PassiveSubjectValue, "")),
+	}
+	if passive := passiveConstraint(conf, "!="); passive != "" {
+		active = append(active, passive)
 	}
+	return fmt.Sprintf(
+		`((%s) | %s="%s")`,
+		strings.Join(active, " & "),
+		conf.FuncAttr.Name, deprelRegexp(conf.AgentValue, ""),
+	)
+}
+
+// VerbsDeepObject creates a query matching the word as a logical
+// object of a verb (collocation candidate), i.e. as an object
+// or as a subject of the verb in passive voice
+func VerbsDeepObject(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	return childQuery(
+		conf, word, filter,
+		deepObjectConstraint(conf),
+		fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, conf.VerbValue),
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, collCandidate)),
+	)
+}
+
+// VerbsDeepSubject creates a query matching the word as a logical
+// subject of a verb (collocation candidate), i.e. as a subject of
+// the verb in active voice or as its agent
+func VerbsDeepSubject(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	return childQuery(
+		conf, word, filter,
+		deepSubjectConstraint(conf),
+		fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, conf.VerbValue),
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, collCandidate)),
+	)
+}
+
+// VerbsOblique creates a query matching the word as an oblique
+// argument of a verb (collocation candidate). A preposition
+// (the filter's marker) is matched as preceding tokens.
+func VerbsOblique(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	return childQuery(
+		conf, word, filter,
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.ObliqueValue, filter.Subtype)),
+		fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, conf.VerbValue),
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, collCandidate)),
+	)
+}
+
+// ObliquesOf creates a query matching a collocation candidate
+// as an oblique argument of the word (a verb)
+func ObliquesOf(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
+	constraints := []string{
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, word.V)),
+	}
+	if word.PoS != "" {
+		constraints = append(
+			constraints, fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, word.PoS))
+	}
+	constraints = append(
+		constraints,
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.ObliqueValue, filter.Subtype)),
+	)
+	return childQuery(conf, engine.Word{V: collCandidate}, filter, constraints...)
+}
+
+// dependentToken creates a query matching a dependent
+// attached to a parent by a relation (a deprel expression)
+func dependentToken(conf *engine.SyntaxProps, lemma, upos, deprel, pLemma, pUpos string) string {
 	return fmt.Sprintf(
 		`[%s="%s" & %s="%s" & %s="%s" & %s="%s" & %s="%s"]`,
-		conf.LemmaAttr.Name, word.V,
-		conf.PosAttr.Name, word.PoS,
-		conf.FuncAttr.Name, conf.NounObjectValue,
-		conf.ParPosAttr.Name, conf.VerbValue,
-		conf.ParLemmaAttr.Name, collCandidate,
+		conf.LemmaAttr.Name, lemmaRegexp(conf, lemma),
+		conf.PosAttr.Name, upos,
+		conf.FuncAttr.Name, deprelRegexp(deprel, ""),
+		conf.ParLemmaAttr.Name, lemmaRegexp(conf, pLemma),
+		conf.ParPosAttr.Name, pUpos,
 	)
 }
 
-func VerbsSubject(conf *engine.SyntaxProps, word engine.Word, collCandidate string) string {
-	if word.PoS == "" {
-		return fmt.Sprintf(
-			`[%s="%s" & %s="%s" & %s="%s" & %s="%s"]`,
-			conf.LemmaAttr.Name, word.V,
-			conf.FuncAttr.Name, conf.NounSubjectValue,
-			conf.ParPosAttr.Name, conf.VerbValue,
-			conf.ParLemmaAttr.Name, collCandidate,
+// Triple creates a query matching a head-centered triple. Both
+// the dependents are matched via their parent attributes and combined
+// using `meet` within a sentence. Words of a marker are attached
+// to the second dependent the same way.
+func Triple(conf *engine.SyntaxProps, pattern engine.TriplePattern, triple *engine.Triple) string {
+	first := dependentToken(
+		conf, triple.Lemma1, triple.Upos1, pattern.FirstRel, triple.Lemma, triple.Upos)
+	pLemma, pUpos := triple.Lemma, triple.Upos
+	if pattern.Nested {
+		pLemma, pUpos = triple.Lemma1, triple.Upos1
+	}
+	second := dependentToken(
+		conf, triple.Lemma2, triple.Upos2, pattern.SecondRel, pLemma, pUpos)
+	if triple.Marker != "" {
+		for _, w := range strings.Split(triple.Marker, " ") {
+			second = fmt.Sprintf(
+				"(meet %s %s -%d -1)", second, markerToken(conf, conf.CaseValue, w),
+				maxMarkerDistance+1)
+		}
+	}
+	return fmt.Sprintf(
+		"(meet %s %s -%d %d) within <%s/>",
+		first, second, maxTripleDistance, maxTripleDistance, conf.SentenceStruct,
+	)
+}
+
+// coordinatedToken creates a query matching a coordinated
+// word attached to the previous conjunct. Words of a conjunction
+// (the filter's marker) are matched as preceding tokens.
+func coordinatedToken(
+	conf *engine.SyntaxProps,
+	lemma, pLemma, upos string,
+	feats, pFeats []string,
+	filter engine.CollFilter,
+) string {
+	constraints := []string{
+		fmt.Sprintf(`%s="%s"`, conf.LemmaAttr.Name, lemmaRegexp(conf, lemma)),
+		fmt.Sprintf(`%s="%s"`, conf.FuncAttr.Name, deprelRegexp(conf.ConjValue, filter.Subtype)),
+		fmt.Sprintf(`%s="%s"`, conf.ParLemmaAttr.Name, lemmaRegexp(conf, pLemma)),
+	}
+	if upos != "" {
+		constraints = append(
+			constraints,
+			fmt.Sprintf(`%s="%s"`, conf.PosAttr.Name, upos),
+			fmt.Sprintf(`%s="%s"`, conf.ParPosAttr.Name, upos),
 		)
 	}
+	constraints = append(constraints, featsConstraints(conf.FeatsAttr.Name, feats)...)
+	constraints = append(constraints, featsConstraints(conf.ParFeatsAttr.Name, pFeats)...)
+	ans := fmt.Sprintf("[%s]", strings.Join(constraints, " & "))
+	if filter.Marker != "" {
+		for _, w := range strings.Split(filter.Marker, " ") {
+			ans = fmt.Sprintf(
+				"(meet %s %s -%d -1)", ans, markerToken(conf, conf.CcValue, w),
+				maxMarkerDistance+1)
+		}
+	}
+	return ans
+}
+
+// CoordinatedWith creates a query matching the word coordinated
+// with a collocation candidate. As coordination is counted in both
+// word orders, the query matches the word either as the first
+// or as the second conjunct.
+func CoordinatedWith(
+	conf *engine.SyntaxProps,
+	word engine.Word,
+	collCandidate string,
+	filter engine.CollFilter,
+) string {
 	return fmt.Sprintf(
-		`[%s="%s" & %s="%s" & %s="%s" & %s="%s" & %s="%s"]`,
-		conf.LemmaAttr.Name, word.V,
-		conf.PosAttr.Name, word.PoS,
-		conf.FuncAttr.Name, conf.NounSubjectValue,
-		conf.ParPosAttr.Name, conf.VerbValue,
-		conf.ParLemmaAttr.Name, collCandidate,
+		"(union %s %s) within <%s/>",
+		coordinatedToken(
+			conf, word.V, collCandidate, word.PoS, filter.ChildFeats, filter.ParentFeats, filter),
+		coordinatedToken(
+			conf, collCandidate, word.V, word.PoS, filter.ParentFeats, filter.ChildFeats, filter),
+		conf.SentenceStruct,
 	)
 }
@@ -0,0 +1,379 @@
+// Copyright 2019 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2019 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cql
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/czcorpus/scollex/engine"
+)
+
+func testSyntaxProps(t *testing.T) *engine.SyntaxProps {
+	ans := &engine.SyntaxProps{
+		ParentIdxAttr:     engine.PosAttrProps{Name: "parent", VerticalCol: 10},
+		LemmaAttr:         engine.PosAttrProps{Name: "lemma", VerticalCol: 3},
+		ParLemmaAttr:      engine.PosAttrProps{Name: "p_lemma", VerticalCol: 11},
+		PosAttr:           engine.PosAttrProps{Name: "upos", VerticalCol: 4},
+		ParPosAttr:        engine.PosAttrProps{Name: "p_upos", VerticalCol: 12},
+		FuncAttr:          engine.PosAttrProps{Name: "deprel", VerticalCol: 9},
+		FeatsAttr:         engine.PosAttrProps{Name: "feats", VerticalCol: 6},
+		ParFeatsAttr:      engine.PosAttrProps{Name: "p_feats", VerticalCol: 13},
+		Features:          []string{"Case", "Number"},
+		NounValue:         "NOUN",
+		VerbValue:         "VERB",
+		NounModifiedValue: "nmod",
+		NounSubjectValue:  "nsubj",
+		NounObjectValue:   "obj|iobj",
+		ObliqueValue:      "obl",
+		ConjValue:         "conj",
+	}
+	if err := ans.ValidateAndDefaults("test"); err != nil {
+		t.Fatal(err)
+	}
+	return ans
+}
+
+// testQuery compares a generated query with the expected one
+func testQuery(t *testing.T, name, expected, found string) {
+	if found != expected {
+		t.Errorf("%s:\nexpected: %s\nfound:    %s", name, expected, found)
+	}
+}
+
+func TestFeatsConstraints(t *testing.T) {
+	testQuery(
+		t,
+		"multiple features",
+		`feats="(.*\|)?Case=Acc(\|.*)?" feats="(.*\|)?Number=Sing(\|.*)?"`,
+		strings.Join(featsConstraints("feats", []string{"Case=Acc", "Number=Sing"}), " "),
+	)
+	if ans := featsConstraints("feats", nil); len(ans) != 0 {
+		t.Errorf("expected no constraints, found %v", ans)
+	}
+}
+
+func TestFeatsFilter(t *testing.T) {
+	conf := testSyntaxProps(t)
+	testQuery(
+		t,
+		"child and parent features",
+		`[lemma="team" & upos="NOUN" & deprel="(obj(:.*)?|iobj(:.*)?)" & p_upos="VERB" `+
+			`& p_lemma="win" & feats="(.*\|)?Case=Acc(\|.*)?" `+
+			`& p_feats="(.*\|)?Mood=Ind(\|.*)?"]`,
+		VerbsObject(
+			conf,
+			engine.Word{V: "team", PoS: "NOUN"},
+			"win",
+			engine.CollFilter{ChildFeats: []string{"Case=Acc"}, ParentFeats: []string{"Mood=Ind"}},
+		),
+	)
+}
+
+func TestMarkers(t *testing.T) {
+	conf := testSyntaxProps(t)
+	testQuery(
+		t,
+		"single word marker",
+		`[lemma="of" & deprel="case(:.*)?"] []{0,3} `+
+			`[lemma="team" & upos="NOUN" & p_lemma="member" & deprel="nmod(:.*)?" `+
+			`& p_upos="NOUN"] within <s/>`,
+		NounsModifiedBy(
+			conf, engine.Word{V: "team", PoS: "NOUN"}, "member", engine.CollFilter{Marker: "of"}),
+	)
+	testQuery(
+		t,
+		"multiword marker",
+		`[lemma="out" & deprel="case(:.*)?"] [lemma="of" & deprel="case(:.*)?"] []{0,3} `+
+			`[lemma="window" & upos="NOUN" & p_lemma="view" & p_upos="NOUN" `+
+			`& deprel="nmod(:.*)?"] within <s/>`,
+		ModifiersOf(
+			conf, engine.Word{V: "view", PoS: "NOUN"}, "window", engine.CollFilter{Marker: "out of"}),
+	)
+}
+
+func TestDeprelRegexp(t *testing.T) {
+	tests := []struct {
+		expr     string
+		subtype  string
+		expected string
+	}{
+		{"nmod", "", `nmod(:.*)?`},
+		{"nmod:poss", "", `nmod:poss`},
+		{"obj|iobj", "", `(obj(:.*)?|iobj(:.*)?)`},
+		{"nmod", "poss", `nmod:poss`},
+		{"nmod:tmod", "poss", `nmod:poss`},
+		{"obj|iobj", "agent", `(obj:agent|iobj:agent)`},
+		{"nmod", "a.b", `nmod:a\.b`},
+	}
+	for _, tt := range tests {
+		testQuery(t, tt.expr+" "+tt.subtype, tt.expected, deprelRegexp(tt.expr, tt.subtype))
+	}
+}
+
+func TestSubtypeFilter(t *testing.T) {
+	conf := testSyntaxProps(t)
+	testQuery(
+		t,
+		"subtype",
+		`[lemma="team" & upos="NOUN" & p_lemma="city" & deprel="nmod:poss" & p_upos="NOUN"]`,
+		NounsModifiedBy(
+			conf, engine.Word{V: "team", PoS: "NOUN"}, "city", engine.CollFilter{Subtype: "poss"}),
+	)
+}
+
+func TestTriple(t *testing.T) {
+	conf := testSyntaxProps(t)
+	tests := []struct {
+		pattern  string
+		triple   engine.Triple
+		expected string
+	}{
+		{
+			pattern: engine.TriplePatternVerbObjObl,
+			triple: engine.Triple{
+				Lemma: "take", Upos: "VERB", Lemma1: "part", Upos1: "NOUN",
+				Lemma2: "race", Upos2: "NOUN", Marker: "in",
+			},
+			expected: `(meet ` +
+				`[lemma="part" & upos="NOUN" & deprel="(obj(:.*)?|iobj(:.*)?)" & p_lemma="take" & p_upos="VERB"] ` +
+				`(meet [lemma="race" & upos="NOUN" & deprel="obl(:.*)?" & p_lemma="take" & p_upos="VERB"] ` +
+				`[lemma="in" & deprel="case(:.*)?"] -4 -1) -10 10) within <s/>`,
+		},
+		{
+			pattern: engine.TriplePatternVerbObjNmod,
+			triple: engine.Triple{
+				Lemma: "raise", Upos: "VERB", Lemma1: "price", Upos1: "NOUN",
+				Lemma2: "oil", Upos2: "NOUN", Marker: "out of",
+			},
+			expected: `(meet ` +
+				`[lemma="price" & upos="NOUN" & deprel="(obj(:.*)?|iobj(:.*)?)" & p_lemma="raise" & p_upos="VERB"] ` +
+				`(meet (meet [lemma="oil" & upos="NOUN" & deprel="nmod(:.*)?" & p_lemma="price" & p_upos="NOUN"] ` +
+				`[lemma="out" & deprel="case(:.*)?"] -4 -1) [lemma="of" & deprel="case(:.*)?"] -4 -1) ` +
+				`-10 10) within <s/>`,
+		},
+		{
+			pattern: engine.TriplePatternNounAmodNmod,
+			triple: engine.Triple{
+				Lemma: "house", Upos: "NOUN", Lemma1: "big", Upos1: "ADJ",
+				Lemma2: "city", Upos2: "NOUN",
+			},
+			expected: `(meet ` +
+				`[lemma="big" & upos="ADJ" & deprel="amod(:.*)?" & p_lemma="house" & p_upos="NOUN"] ` +
+				`[lemma="city" & upos="NOUN" & deprel="nmod(:.*)?" & p_lemma="house" & p_upos="NOUN"] ` +
+				`-10 10) within <s/>`,
+		},
+	}
+	for _, tt := range tests {
+		pattern, ok := conf.TriplePattern(tt.pattern)
+		if !ok {
+			t.Fatalf("unknown pattern %s", tt.pattern)
+		}
+		testQuery(t, tt.pattern, tt.expected, Triple(conf, pattern, &tt.triple))
+	}
+}
+
+func TestCoordinatedWith(t *testing.T) {
+	conf := testSyntaxProps(t)
+	testQuery(
+		t,
+		"plain",
+		`(union [lemma="salt" & deprel="conj(:.*)?" & p_lemma="pepper"] `+
+			`[lemma="pepper" & deprel="conj(:.*)?" & p_lemma="salt"]) within <s/>`,
+		CoordinatedWith(conf, engine.Word{V: "salt"}, "pepper", engine.CollFilter{}),
+	)
+	testQuery(
+		t,
+		"PoS, features and conjunction",
+		`(union `+
+			`(meet [lemma="salt" & deprel="conj(:.*)?" & p_lemma="pepper" & upos="NOUN" & p_upos="NOUN" `+
+			`& feats="(.*\|)?Number=Sing(\|.*)?"] [lemma="and" & deprel="cc(:.*)?"] -4 -1) `+
+			`(meet [lemma="pepper" & deprel="conj(:.*)?" & p_lemma="salt" & upos="NOUN" & p_upos="NOUN" `+
+			`& p_feats="(.*\|)?Number=Sing(\|.*)?"] [lemma="and" & deprel="cc(:.*)?"] -4 -1)`+
+			`) within <s/>`,
+		CoordinatedWith(
+			conf,
+			engine.Word{V: "salt", PoS: "NOUN"},
+			"pepper",
+			engine.CollFilter{Marker: "and", ChildFeats: []string{"Number=Sing"}},
+		),
+	)
+}
+
+func TestObliques(t *testing.T) {
+	conf := testSyntaxProps(t)
+	testQuery(
+		t,
+		"verbs of an oblique argument",
+		`[lemma="on" & deprel="case(:.*)?"] []{0,3} `+
+			`[lemma="weather" & upos="NOUN" & deprel="obl(:.*)?" & p_upos="VERB" `+
+			`& p_lemma="depend"] within <s/>`,
+		VerbsOblique(
+			conf, engine.Word{V: "weather", PoS: "NOUN"}, "depend", engine.CollFilter{Marker: "on"}),
+	)
+	testQuery(
+		t,
+		"oblique arguments of a verb",
+		`[lemma="day" & p_lemma="work" & p_upos="VERB" & deprel="obl:tmod"]`,
+		ObliquesOf(
+			conf, engine.Word{V: "work", PoS: "VERB"}, "day", engine.CollFilter{Subtype: "tmod"}),
+	)
+}
+
+func TestMultiwords(t *testing.T) {
+	conf := testSyntaxProps(t)
+	conf.MergeMultiwords = true
+	head := `upos="PROPN" & deprel="(obj(:.*)?|iobj(:.*)?)" & p_upos="VERB" & p_lemma="visit"`
+	testQuery(
+		t,
+		"multiword headword",
+		`([lemma="New" & `+head+`] [lemma="York"] | [lemma="New"] [lemma="York" & `+head+`])`,
+		VerbsObject(conf, engine.Word{V: "New York", PoS: "PROPN"}, "visit", engine.CollFilter{}),
+	)
+	testQuery(
+		t,
+		"multiword headword with a marker",
+		`[lemma="in" & deprel="case(:.*)?"] []{0,3} `+
+			`([lemma="New" & `+head+`] [lemma="York"] | [lemma="New"] [lemma="York" & `+head+`]) `+
+			`within <s/>`,
+		VerbsObject(
+			conf, engine.Word{V: "New York", PoS: "PROPN"}, "visit", engine.CollFilter{Marker: "in"}),
+	)
+	testQuery(
+		t,
+		"multiword candidate",
+		`[lemma="mayor" & upos="NOUN" & p_lemma="(New|York)" & deprel="nmod(:.*)?" & p_upos="NOUN"]`,
+		NounsModifiedBy(conf, engine.Word{V: "mayor", PoS: "NOUN"}, "New York", engine.CollFilter{}),
+	)
+	if !NeedsOwnQuery(conf, "New York") {
+		t.Error("expected a multiword to need its own query")
+	}
+	if NeedsOwnQuery(conf, "York") {
+		t.Error("expected a single word not to need its own query")
+	}
+	conf.MergeMultiwords = false
+	if NeedsOwnQuery(conf, "New York") {
+		t.Error("expected a lemma with a space not to need its own query with multiwords not merged")
+	}
+}
+
+func TestNormalizedLemmas(t *testing.T) {
+	conf := testSyntaxProps(t)
+	conf.Normalization.CaseFolding = true
+	conf.Normalization.VariantsPath = filepath.Join(t.TempDir(), "variants.tsv")
+	err := os.WriteFile(
+		conf.Normalization.VariantsPath, []byte("Colour\tcolor\ngrey\tgray\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ValidateAndDefaults("test"); err != nil {
+		t.Fatal(err)
+	}
+	testQuery(t, "quoted form", `(?i)e\.g\.`, formsRegexp(conf, []string{"e.g."}))
+	testQuery(
+		t,
+		"variants",
+		`[lemma="(?i)(color|colour)" & upos="NOUN" & deprel="(obj(:.*)?|iobj(:.*)?)" `+
+			`& p_upos="VERB" & p_lemma="(?i)(gray|grey)"]`,
+		VerbsObject(conf, engine.Word{V: "color", PoS: "NOUN"}, "gray", engine.CollFilter{}),
+	)
+	if !NeedsOwnQuery(conf, "color") {
+		t.Error("expected a lemma with variants to need its own query")
+	}
+	if NeedsOwnQuery(conf, "red") {
+		t.Error("expected a lemma without variants not to need its own query")
+	}
+}
+
+func TestPolarityConstraints(t *testing.T) {
+	conf := testSyntaxProps(t)
+	conf.Polarity.NegFeature = "Polarity=Neg"
+	tests := []struct {
+		polarity string
+		expected string
+	}{
+		{"", ""},
+		{engine.PolarityNeg, `p_feats="(.*\|)?Polarity=Neg(\|.*)?"`},
+		{engine.PolarityPos, `p_feats!="(.*\|)?Polarity=Neg(\|.*)?"`},
+	}
+	for _, tt := range tests {
+		testQuery(
+			t,
+			"polarity "+tt.polarity,
+			tt.expected,
+			strings.Join(polarityConstraints(conf, engine.CollFilter{Polarity: tt.polarity}), " "),
+		)
+	}
+	testQuery(
+		t,
+		"negated verbs",
+		`[lemma="attention" & upos="NOUN" & deprel="(obj(:.*)?|iobj(:.*)?)" & p_upos="VERB" `+
+			`& p_lemma="pay" & p_feats="(.*\|)?Polarity=Neg(\|.*)?"]`,
+		VerbsObject(
+			conf,
+			engine.Word{V: "attention", PoS: "NOUN"},
+			"pay",
+			engine.CollFilter{Polarity: engine.PolarityNeg},
+		),
+	)
+	conf.ParFeatsAttr = engine.PosAttrProps{}
+	if ans := polarityConstraints(conf, engine.CollFilter{Polarity: engine.PolarityNeg}); ans != nil {
+		t.Errorf("expected no constraints without parent features, found %v", ans)
+	}
+}
+
+func TestDeepRelations(t *testing.T) {
+	conf := testSyntaxProps(t)
+	conf.DeepRelations = true
+	if err := conf.ValidateAndDefaults("test"); err != nil {
+		t.Fatal(err)
+	}
+	deepObject := `(deprel="(obj(:.*)?|iobj(:.*)?)" | deprel="nsubj:pass" ` +
+		`| (deprel="nsubj(:.*)?" & p_feats="(.*\|)?Voice=Pass(\|.*)?"))`
+	deepSubject := `((deprel="nsubj(:.*)?" & deprel!="nsubj:pass" ` +
+		`& p_feats!="(.*\|)?Voice=Pass(\|.*)?") | deprel="obl:agent")`
+	testQuery(t, "deep object", deepObject, deepObjectConstraint(conf))
+	testQuery(t, "deep subject", deepSubject, deepSubjectConstraint(conf))
+	testQuery(
+		t,
+		"verbs of a deep object",
+		`[lemma="decision" & upos="NOUN" & `+deepObject+` & p_upos="VERB" & p_lemma="make"]`,
+		VerbsDeepObject(conf, engine.Word{V: "decision", PoS: "NOUN"}, "make", engine.CollFilter{}),
+	)
+	testQuery(
+		t,
+		"verbs of a deep subject",
+		`[lemma="board" & upos="NOUN" & `+deepSubject+` & p_upos="VERB" & p_lemma="make"]`,
+		VerbsDeepSubject(conf, engine.Word{V: "board", PoS: "NOUN"}, "make", engine.CollFilter{}),
+	)
+
+	conf.ParFeatsAttr = engine.PosAttrProps{}
+	testQuery(
+		t,
+		"deep object without parent features",
+		`(deprel="(obj(:.*)?|iobj(:.*)?)" | deprel="nsubj:pass")`,
+		deepObjectConstraint(conf),
+	)
+	testQuery(
+		t,
+		"deep subject without parent features",
+		`((deprel="nsubj(:.*)?" & deprel!="nsubj:pass") | deprel="obl:agent")`,
+		deepSubjectConstraint(conf),
+	)
+}
@@ -0,0 +1,576 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tomachalek/vertigo/v5"
+)
+
+const (
+	// maxTrackedColumnValues limits the number of distinct values
+	// tracked for each of the configured columns
+	maxTrackedColumnValues = 10000
+
+	// minValuesForMappingCheck specifies how many values of a column
+	// must be seen before we try to guess whether the column mapping
+	// makes sense
+	minValuesForMappingCheck = 100
+
+	maxNumPosValues = 50
+)
+
+// udPosTags contains universal part-of-speech tags
+var udPosTags = map[string]bool{
+	"ADJ": true, "ADP": true, "ADV": true, "AUX": true, "CCONJ": true,
+	"DET": true, "INTJ": true, "NOUN": true, "NUM": true, "PART": true,
+	"PRON": true, "PROPN": true, "PUNCT": true, "SCONJ": true, "SYM": true,
+	"VERB": true, "X": true,
+}
+
+var featsRegexp = regexp.MustCompile(`^[A-Z][A-Za-z\[\]]*=[^|]+(\|[A-Z][A-Za-z\[\]]*=[^|]+)*$`)
+
+// ValueFreq is a value of an attribute along with its frequency
+type ValueFreq struct {
+	Value string `json:"value"`
+	Freq  int64  `json:"freq"`
+}
+
+// Distribution is a (possibly cut) frequency distribution
+// of attribute values
+type Distribution struct {
+	NumDistinct int         `json:"numDistinct"`
+	Top         []ValueFreq `json:"top"`
+
+	// OtherFreq is a total frequency of values not listed in Top
+	OtherFreq int64 `json:"otherFreq"`
+}
+
+func newDistribution(freqs map[string]int64, maxItems int) Distribution {
+	ans := Distribution{
+		NumDistinct: len(freqs),
+		Top:         make([]ValueFreq, 0, len(freqs)),
+	}
+	for v, f := range freqs {
+		ans.Top = append(ans.Top, ValueFreq{Value: v, Freq: f})
+	}
+	sort.Slice(ans.Top, func(i, j int) bool {
+		if ans.Top[i].Freq == ans.Top[j].Freq {
+			return ans.Top[i].Value < ans.Top[j].Value
+		}
+		return ans.Top[i].Freq > ans.Top[j].Freq
+	})
+	if maxItems > 0 && len(ans.Top) > maxItems {
+		for _, item := range ans.Top[maxItems:] {
+			ans.OtherFreq += item.Freq
+		}
+		ans.Top = ans.Top[:maxItems]
+	}
+	return ans
+}
+
+// RelationMatch shows how many tokens match a configured relation
+type RelationMatch struct {
+
+	// Relation is a name of the configuration
+	// key (e.g. `nounModifiedValue`)
+	Relation   string `json:"relation"`
+	Expression string `json:"expression"`
+
+	// NumTokens is a number of tokens the import would
+	// count for the relation
+	NumTokens int64 `json:"numTokens"`
+
+	// NumWithExpectedPos is a number of matching tokens whose
+	// child and parent PoS are the ones the relation is queried for
+	NumWithExpectedPos int64 `json:"numWithExpectedPos"`
+}
+
+// RowEstimates contains estimated numbers of rows an import
+// would write into respective tables
+type RowEstimates struct {
+	Fcolls     int64 `json:"fcolls"`
+	ChildSums  int64 `json:"childSums"`
+	ParentSums int64 `json:"parentSums"`
+	TokenFreqs int64 `json:"tokenFreqs"`
+}
+
+// ColumnMappingIssue describes a configured positional attribute
+// whose values do not look like expected
+type ColumnMappingIssue struct {
+	Attr    string `json:"attr"`
+	Column  int    `json:"column"`
+	Problem string `json:"problem"`
+}
+
+// AnalysisReport summarizes a vertical file from the point of view
+// of an import with a specific syntax configuration
+type AnalysisReport struct {
+	VerticalPath      string               `json:"verticalPath"`
+	Size              CorpusSize           `json:"size"`
+	Deprels           Distribution         `json:"deprels"`
+	Pos               Distribution         `json:"pos"`
+	Relations         []RelationMatch      `json:"relations"`
+	EstimatedRows     RowEstimates         `json:"estimatedRows"`
+	SuspiciousColumns []ColumnMappingIssue `json:"suspiciousColumns"`
+	Validation        *ValidationReport    `json:"validation"`
+}
+
+// ------------------------------
+
+// columnStats describes values found in a vertical column
+type columnStats struct {
+	numValues  int64
+	numNumeric int64
+	numPos     int64
+	numDeprel  int64
+	numFeats   int64
+	numEmpty   int64
+	distinct   map[string]bool
+}
+
+func (cs *columnStats) add(value string) {
+	cs.numValues++
+	if _, err := strconv.Atoi(value); err == nil {
+		cs.numNumeric++
+	}
+	if udPosTags[value] {
+		cs.numPos++
+	}
+	if isKnownDeprel(value) {
+		cs.numDeprel++
+	}
+	if featsRegexp.MatchString(value) {
+		cs.numFeats++
+	}
+	if value == "_" {
+		cs.numEmpty++
+	}
+	if len(cs.distinct) < maxTrackedColumnValues {
+		cs.distinct[value] = true
+	}
+}
+
+func (cs *columnStats) merge(other *columnStats) {
+	cs.numValues += other.numValues
+	cs.numNumeric += other.numNumeric
+	cs.numPos += other.numPos
+	cs.numDeprel += other.numDeprel
+	cs.numFeats += other.numFeats
+	cs.numEmpty += other.numEmpty
+	for v := range other.distinct {
+		if len(cs.distinct) >= maxTrackedColumnValues {
+			break
+		}
+		cs.distinct[v] = true
+	}
+}
+
+func (cs *columnStats) ratio(v int64) float64 {
+	return float64(v) / float64(cs.numValues)
+}
+
+// keySet is a set of hashed table keys used to estimate
+// numbers of distinct table rows without keeping the keys
+type keySet map[uint64]struct{}
+
+func (ks keySet) Add(fields ...string) {
+	h := fnv.New64a()
+	for _, f := range fields {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	ks[h.Sum64()] = struct{}{}
+}
+
+func (ks keySet) Merge(other keySet) {
+	for k := range other {
+		ks[k] = struct{}{}
+	}
+}
+
+// ------------------------------
+
+// analyzeProcessor collects statistics of a vertical file
+// without building the actual counting tables
+type analyzeProcessor struct {
+	conf          *SyntaxProps
+	counted       relationMatcher
+	coordinations relationMatcher
+	relations     []RelationMatch
+
+	// relationMatchers contain matchers of respective relations
+	relationMatchers []relationMatcher
+	size             CorpusSize
+	deprels          map[string]int64
+	pos              map[string]int64
+	columns          map[int]*columnStats
+	fcolls           keySet
+	childSums        keySet
+	parentSums       keySet
+	tokenFreqs       keySet
+	validator        *vertValidator
+	sentence         sentenceParents
+
+	// normalizer is nil in case lemmas are not normalized
+	normalizer *LemmaNormalizer
+
+	// deepRelations is nil in case deep relations are not counted
+	deepRelations *deepRelationMatcher
+}
+
+// hasExpectedPos tests whether child and parent PoS values
+// are the ones a relation is queried for
+func (ap *analyzeProcessor) hasExpectedPos(relation, upos, pUpos string) bool {
+	switch relation {
+	case "nounModifiedValue":
+		return upos == ap.conf.NounValue && pUpos == ap.conf.NounValue
+	case "conjValue":
+		return upos == pUpos
+	}
+	return upos == ap.conf.NounValue && pUpos == ap.conf.VerbValue
+}
+
+func (ap *analyzeProcessor) ProcToken(token *vertigo.Token, line int, err error) error {
+	if err != nil {
+		return err
+	}
+	ap.size.NumTokens++
+	if ok, err := ap.validator.validateToken(token, line, ap.conf, &ap.sentence); !ok || err != nil {
+		return err
+	}
+	for col, stats := range ap.columns {
+		stats.add(token.Attrs[col-1])
+	}
+	lemma := token.Attrs[ap.conf.LemmaAttr.VerticalCol-1]
+	upos := token.Attrs[ap.conf.PosAttr.VerticalCol-1]
+	pLemma := token.Attrs[ap.conf.ParLemmaAttr.VerticalCol-1]
+	pUpos := token.Attrs[ap.conf.ParPosAttr.VerticalCol-1]
+	deprelTmp := token.Attrs[ap.conf.FuncAttr.VerticalCol-1]
+	if ap.normalizer != nil {
+		lemma = ap.normalizer.Normalize(lemma)
+		pLemma = ap.normalizer.Normalize(pLemma)
+	}
+	var feats, pFeats string
+	if ap.conf.FeatsAttr.VerticalCol > 0 {
+		feats = selectFeats(token.Attrs[ap.conf.FeatsAttr.VerticalCol-1], ap.conf.Features)
+	}
+	if ap.conf.ParFeatsAttr.VerticalCol > 0 {
+		pFeats = selectFeats(token.Attrs[ap.conf.ParFeatsAttr.VerticalCol-1], ap.conf.Features)
+	}
+	ap.deprels[deprelTmp]++
+	ap.pos[upos]++
+	ap.tokenFreqs.Add(lemma, upos)
+
+	// here we mimic VertProcessor so the numbers correspond with
+	// the actual import (except for markers and polarity of verbs which
+	// are resolved within whole sentences - the row counts are thus
+	// rather lower estimates)
+	for _, deprel := range ap.counted.match(deprelTmp) {
+		ap.fcolls.Add(lemma, upos, pLemma, pUpos, deprel, feats, pFeats)
+		ap.childSums.Add(lemma, upos, deprel, feats)
+		ap.parentSums.Add(pLemma, pUpos, deprel, pFeats)
+	}
+	if ap.deepRelations != nil && pUpos == ap.conf.VerbValue {
+		// passive verbs are recognized by parent features here
+		// while the importer uses the features of the parent token
+		var passive bool
+		if ap.conf.ParFeatsAttr.VerticalCol > 0 {
+			passive = hasFeat(
+				token.Attrs[ap.conf.ParFeatsAttr.VerticalCol-1], ap.conf.PassiveFeature)
+		}
+		if deprel := ap.deepRelations.match(deprelTmp, passive); deprel != "" {
+			ap.fcolls.Add(lemma, upos, pLemma, pUpos, deprel, feats, pFeats)
+			ap.childSums.Add(lemma, upos, deprel, feats)
+			ap.parentSums.Add(pLemma, pUpos, deprel, pFeats)
+		}
+	}
+	if upos == pUpos {
+		for _, deprel := range ap.coordinations.match(deprelTmp) {
+			ap.fcolls.Add(lemma, upos, pLemma, pUpos, deprel, feats, pFeats)
+			ap.fcolls.Add(pLemma, pUpos, lemma, upos, deprel, pFeats, feats)
+			ap.childSums.Add(lemma, upos, deprel, feats)
+			ap.childSums.Add(pLemma, pUpos, deprel, pFeats)
+			ap.parentSums.Add(pLemma, pUpos, deprel, pFeats)
+			ap.parentSums.Add(lemma, upos, deprel, feats)
+		}
+	}
+	for i, rel := range ap.relations {
+		if len(ap.relationMatchers[i].match(deprelTmp)) > 0 {
+			ap.relations[i].NumTokens++
+			if ap.hasExpectedPos(rel.Relation, upos, pUpos) {
+				ap.relations[i].NumWithExpectedPos++
+			}
+		}
+	}
+	return nil
+}
+
+func (ap *analyzeProcessor) ProcOverrunToken(token *vertigo.Token, line int) (bool, error) {
+	return false, nil
+}
+
+func (ap *analyzeProcessor) ProcStruct(strc *vertigo.Structure, line int, err error) error {
+	if strc == nil {
+		return nil
+	}
+	switch strc.Name {
+	case ap.conf.SentenceStruct:
+		ap.size.NumSentences++
+		if !strc.IsEmpty {
+			ap.sentence.Reset(true)
+		}
+	case ap.conf.DocStruct:
+		ap.size.NumDocs++
+	}
+	return nil
+}
+
+func (ap *analyzeProcessor) ProcStructClose(strc *vertigo.StructureClose, line int, err error) error {
+	if strc != nil && strc.Name == ap.conf.SentenceStruct {
+		return ap.validator.closeSentence(&ap.sentence)
+	}
+	return nil
+}
+
+func (ap *analyzeProcessor) merge(other *analyzeProcessor) {
+	ap.size.NumTokens += other.size.NumTokens
+	ap.size.NumSentences += other.size.NumSentences
+	ap.size.NumDocs += other.size.NumDocs
+	for v, f := range other.deprels {
+		ap.deprels[v] += f
+	}
+	for v, f := range other.pos {
+		ap.pos[v] += f
+	}
+	for col, stats := range other.columns {
+		ap.columns[col].merge(stats)
+	}
+	for i, rel := range other.relations {
+		ap.relations[i].NumTokens += rel.NumTokens
+		ap.relations[i].NumWithExpectedPos += rel.NumWithExpectedPos
+	}
+	ap.fcolls.Merge(other.fcolls)
+	ap.childSums.Merge(other.childSums)
+	ap.parentSums.Merge(other.parentSums)
+	ap.tokenFreqs.Merge(other.tokenFreqs)
+}
+
+// mappingIssues tries to find configured columns with values
+// which do not correspond with the attribute's purpose
+func (ap *analyzeProcessor) mappingIssues() []ColumnMappingIssue {
+	ans := make([]ColumnMappingIssue, 0, 5)
+	attrs := ap.conf.columnAttrs()
+	addIssue := func(key string, attr *PosAttrProps, problem string, args ...any) {
+		ans = append(
+			ans,
+			ColumnMappingIssue{
+				Attr:    key,
+				Column:  attr.VerticalCol,
+				Problem: fmt.Sprintf(problem, args...),
+			},
+		)
+	}
+	for i, a := range attrs {
+		for _, b := range attrs[:i] {
+			if a.attr.VerticalCol == b.attr.VerticalCol {
+				addIssue(a.key, a.attr, "column shared with `%s`", b.key)
+			}
+		}
+		stats := ap.columns[a.attr.VerticalCol]
+		if stats.numValues < minValuesForMappingCheck {
+			continue
+		}
+		switch a.key {
+		case "parentIdxAttr":
+			if ratio := stats.ratio(stats.numNumeric); ratio < 0.95 {
+				addIssue(a.key, a.attr, "only %.1f%% of values are numeric", ratio*100)
+			}
+		case "funcAttr":
+			if ratio := stats.ratio(stats.numDeprel); ratio < 0.9 {
+				addIssue(a.key, a.attr, "only %.1f%% of values are known dependency relations", ratio*100)
+			}
+		case "featsAttr", "parFeatsAttr":
+			if ratio := stats.ratio(stats.numFeats + stats.numEmpty); ratio < 0.9 {
+				addIssue(a.key, a.attr, "only %.1f%% of values are UD features", ratio*100)
+			}
+		case "posAttr", "parPosAttr":
+			if len(stats.distinct) > maxNumPosValues {
+				addIssue(
+					a.key, a.attr, "found at least %d distinct values, expected a small tagset",
+					len(stats.distinct))
+			}
+		case "lemmaAttr", "parLemmaAttr":
+			tagLike := stats.numPos + stats.numFeats + stats.numNumeric
+			if ratio := stats.ratio(tagLike); ratio > 0.5 {
+				addIssue(
+					a.key, a.attr, "%.1f%% of values look like tags or numbers rather than lemmas",
+					ratio*100)
+
+			} else if len(stats.distinct) <= maxNumPosValues {
+				addIssue(
+					a.key, a.attr, "found only %d distinct values, expected a vocabulary",
+					len(stats.distinct))
+			}
+		}
+	}
+	for _, v := range []string{ap.conf.NounValue, ap.conf.VerbValue} {
+		if ap.size.NumTokens > 0 && ap.pos[v] == 0 {
+			addIssue("posAttr", &ap.conf.PosAttr, "configured PoS value `%s` not found", v)
+		}
+	}
+	return ans
+}
+
+func (ap *analyzeProcessor) report(vertPath string, maxDistribItems int) *AnalysisReport {
+	return &AnalysisReport{
+		VerticalPath: vertPath,
+		Size:         ap.size,
+		Deprels:      newDistribution(ap.deprels, maxDistribItems),
+		Pos:          newDistribution(ap.pos, maxDistribItems),
+		Relations:    ap.relations,
+		EstimatedRows: RowEstimates{
+			Fcolls:     int64(len(ap.fcolls)),
+			ChildSums:  int64(len(ap.childSums)),
+			ParentSums: int64(len(ap.parentSums)),
+			TokenFreqs: int64(len(ap.tokenFreqs)),
+		},
+		SuspiciousColumns: ap.mappingIssues(),
+		Validation:        &ap.validator.report,
+	}
+}
+
+func newAnalyzeProcessor(conf *SyntaxProps, validator *vertValidator) *analyzeProcessor {
+	ans := &analyzeProcessor{
+		conf: conf,
+		counted: newRelationMatcher(
+			[]string{
+				conf.NounModifiedValue,
+				conf.NounSubjectValue,
+				conf.NounObjectValue,
+				conf.ObliqueValue,
+			},
+			conf.CollapseSubtypes,
+		),
+		coordinations: newRelationMatcher([]string{conf.ConjValue}, conf.CollapseSubtypes),
+		relations: []RelationMatch{
+			{Relation: "nounModifiedValue", Expression: conf.NounModifiedValue},
+			{Relation: "nounSubjectValue", Expression: conf.NounSubjectValue},
+			{Relation: "nounObjectValue", Expression: conf.NounObjectValue},
+		},
+		deprels:    make(map[string]int64),
+		pos:        make(map[string]int64),
+		columns:    make(map[int]*columnStats),
+		fcolls:     make(keySet),
+		childSums:  make(keySet),
+		parentSums: make(keySet),
+		tokenFreqs: make(keySet),
+		validator:  validator,
+	}
+	if conf.Normalization.IsActive() {
+		ans.normalizer = conf.Normalization.NewNormalizer()
+	}
+	if conf.ObliqueValue != "" {
+		ans.relations = append(
+			ans.relations,
+			RelationMatch{Relation: "obliqueValue", Expression: conf.ObliqueValue},
+		)
+	}
+	if conf.ConjValue != "" {
+		ans.relations = append(
+			ans.relations,
+			RelationMatch{Relation: "conjValue", Expression: conf.ConjValue},
+		)
+	}
+	if conf.DeepRelations {
+		deepRelations := newDeepRelationMatcher(conf)
+		ans.deepRelations = &deepRelations
+		ans.relations = append(
+			ans.relations,
+			RelationMatch{Relation: "passiveSubjectValue", Expression: conf.PassiveSubjectValue},
+			RelationMatch{Relation: "agentValue", Expression: conf.AgentValue},
+		)
+	}
+	for _, rel := range ans.relations {
+		ans.relationMatchers = append(
+			ans.relationMatchers, newRelationMatcher([]string{rel.Expression}, false))
+	}
+	for _, attr := range []PosAttrProps{
+		conf.ParentIdxAttr,
+		conf.LemmaAttr,
+		conf.ParLemmaAttr,
+		conf.PosAttr,
+		conf.ParPosAttr,
+		conf.FuncAttr,
+	} {
+		ans.columns[attr.VerticalCol] = &columnStats{distinct: make(map[string]bool)}
+	}
+	for _, item := range conf.optionalAttrs() {
+		ans.columns[item.attr.VerticalCol] = &columnStats{distinct: make(map[string]bool)}
+	}
+	return ans
+}
+
+// AnalyzeOptions contains parameters of a vertical file analysis
+type AnalyzeOptions struct {
+	NumWorkers int
+
+	// MaxDistribItems limits the number of items listed
+	// in attribute distributions (0 = no limit)
+	MaxDistribItems int
+}
+
+// Analyze parses a vertical file the same way an import would and
+// reports statistics which may help to verify the configuration
+// before running an actual import. No database is needed.
+// Found errors do not stop the analysis.
+func Analyze(vertPath string, conf *SyntaxProps, opts AnalyzeOptions) (*AnalysisReport, error) {
+	validator := newVertValidator(vertPath, conf, -1)
+	procs, err := parseVerticalParallel(
+		vertPath,
+		opts.NumWorkers,
+		nil,
+		func() *analyzeProcessor {
+			return newAnalyzeProcessor(conf, validator)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	ans := procs[0]
+	for _, p := range procs[1:] {
+		ans.merge(p)
+	}
+	if err := validator.Finish(""); err != nil {
+		return nil, err
+	}
+	report := ans.report(vertPath, opts.MaxDistribItems)
+	for _, issue := range report.SuspiciousColumns {
+		log.Warn().
+			Str("attr", issue.Attr).
+			Int("column", issue.Column).
+			Str("problem", issue.Problem).
+			Msg("suspicious column mapping")
+	}
+	return report, nil
+}
@@ -0,0 +1,577 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	deltaTableInfix = "_delta"
+)
+
+// rowsIterator provides database rows with string columns
+// as records with zero frequency
+type rowsIterator struct {
+	rows      *sql.Rows
+	numFields int
+}
+
+func (ri *rowsIterator) Next() (*spillRecord, error) {
+	if !ri.rows.Next() {
+		return nil, ri.rows.Err()
+	}
+	ans := &spillRecord{Fields: make([]string, ri.numFields)}
+	dest := make([]any, ri.numFields)
+	for i := range ans.Fields {
+		dest[i] = &ans.Fields[i]
+	}
+	if err := ri.rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
+
+func (ri *rowsIterator) Close() error {
+	return ri.rows.Close()
+}
+
+// ------------------------------
+
+// deltaTable is a temporary table with counts of appended data
+// which are then merged into a respective table (a staging copy
+// of a live table, see mergeDeltaTables)
+type deltaTable struct {
+	name   string
+	target string
+
+	// columns specify key columns identifying a record;
+	// the `freq` column is always present
+	columns []string
+}
+
+// sinkColumns returns all the columns rows are written into
+func (dt deltaTable) sinkColumns() []string {
+	ans := make([]string, len(dt.columns), len(dt.columns)+1)
+	copy(ans, dt.columns)
+	return append(ans, "freq")
+}
+
+func (dt deltaTable) joinCond(targetAlias, deltaAlias string) string {
+	conds := make([]string, len(dt.columns))
+	for i, col := range dt.columns {
+		conds[i] = fmt.Sprintf("%s.%s = %s.%s", targetAlias, col, deltaAlias, col)
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// appendTables contains all the delta tables
+// used when appending data
+type appendTables struct {
+	fcolls     deltaTable
+	coOccs     deltaTable
+	childSums  deltaTable
+	parentSums deltaTable
+	tokenFreqs deltaTable
+	triples    deltaTable
+}
+
+func (at appendTables) all() []deltaTable {
+	return []deltaTable{
+		at.fcolls, at.coOccs, at.childSums, at.parentSums, at.tokenFreqs, at.triples}
+}
+
+func (cdb *CollDatabase) appendTables() appendTables {
+	staging := cdb.stagingTables()
+	return appendTables{
+		fcolls: deltaTable{
+			name:   fmt.Sprintf("%s%s_fcolls", cdb.corpusID, deltaTableInfix),
+			target: staging.fcolls,
+			columns: []string{
+				"lemma", "upos", "p_lemma", "p_upos", "deprel", "feats", "p_feats", "marker",
+				"polarity"},
+		},
+		coOccs: deltaTable{
+			name:    fmt.Sprintf("%s%s_cooccs", cdb.corpusID, deltaTableInfix),
+			target:  staging.fcolls,
+			columns: []string{"lemma", "upos", "p_lemma", "p_upos"},
+		},
+		childSums: deltaTable{
+			name:    fmt.Sprintf("%s%s_child_sums", cdb.corpusID, deltaTableInfix),
+			target:  staging.childSums,
+			columns: []string{"lemma", "upos", "deprel", "feats", "marker", "polarity"},
+		},
+		parentSums: deltaTable{
+			name:    fmt.Sprintf("%s%s_parent_sums", cdb.corpusID, deltaTableInfix),
+			target:  staging.parentSums,
+			columns: []string{"p_lemma", "p_upos", "deprel", "p_feats", "marker", "polarity"},
+		},
+		tokenFreqs: deltaTable{
+			name:    fmt.Sprintf("%s%s_token_freqs", cdb.corpusID, deltaTableInfix),
+			target:  staging.tokenFreqs,
+			columns: []string{"lemma", "upos"},
+		},
+		triples: deltaTable{
+			name:   fmt.Sprintf("%s%s_triples", cdb.corpusID, deltaTableInfix),
+			target: staging.triples,
+			columns: []string{
+				"pattern", "lemma", "upos", "lemma1", "upos1", "lemma2", "upos2", "marker"},
+		},
+	}
+}
+
+func (cdb *CollDatabase) createDeltaTable(tx *sql.Tx, dt deltaTable, vcLen int) error {
+	colDefs := make([]string, len(dt.columns))
+	for i, col := range dt.columns {
+		size := 50
+		switch col {
+		case "lemma", "p_lemma", "lemma1", "lemma2":
+			size = vcLen
+		case "feats", "p_feats":
+			size = featsColumnSize
+		case "marker":
+			size = markerColumnSize
+		case "polarity":
+			size = polarityColumnSize
+		}
+		colDefs[i] = fmt.Sprintf("%s varchar(%d) NOT NULL", col, size)
+	}
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		%s,
+		freq int(11) NOT NULL,
+		INDEX (%s)
+	)`, dt.name, strings.Join(colDefs, ",\n\t\t"), dt.columns[0]))
+	if err != nil {
+		return fmt.Errorf("failed to CREATE table %s: %w", dt.name, err)
+	}
+	return nil
+}
+
+// createDeltaTables (re)creates empty delta tables for appended data
+func (cdb *CollDatabase) createDeltaTables() error {
+	tx, err := cdb.db.BeginTx(cdb.ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	for _, dt := range cdb.appendTables().all() {
+		_, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", dt.name))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to DROP table %s: %w", dt.name, err)
+		}
+		if err := cdb.createDeltaTable(tx, dt, defaultWordColumnSize); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (cdb *CollDatabase) dropDeltaTables() {
+	for _, dt := range cdb.appendTables().all() {
+		_, err := cdb.db.ExecContext(cdb.ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", dt.name))
+		if err != nil {
+			log.Error().Err(err).Str("table", dt.name).Msg("failed to remove delta table")
+		}
+	}
+}
+
+// checkAppendSupported tests whether the live tables contain
+// all the data required to merge new counts (installations imported
+// by older versions do not store co-occurrence and token frequencies)
+func (cdb *CollDatabase) checkAppendSupported() error {
+	if err := cdb.CheckSchemaVersion(); err != nil {
+		return fmt.Errorf("cannot append data: %w", err)
+	}
+	live := cdb.liveTables()
+	exist, err := cdb.tablesExist(live)
+	if err != nil {
+		return err
+	}
+	for i, tableName := range live.all() {
+		if !exist[i] {
+			return fmt.Errorf(
+				"cannot append data - table %s not found, full re-import required", tableName)
+		}
+	}
+	hasCoOccFreqs, err := cdb.columnExists(live.fcolls, "co_occurrence_freq")
+	if err != nil {
+		return err
+	}
+	if !hasCoOccFreqs {
+		return fmt.Errorf(
+			"cannot append data - table %s does not store co-occurrence frequencies, full re-import required",
+			live.fcolls)
+	}
+	// tables migrated from older versions contain the
+	// respective columns but not the data
+	numSources, err := cdb.countRows(live.sources)
+	if err != nil {
+		return err
+	}
+	if numSources == 0 {
+		return fmt.Errorf(
+			"cannot append data - live tables have been migrated from a version without " +
+				"co-occurrence and token frequencies, full re-import required")
+	}
+	return nil
+}
+
+// livePairs provides all the distinct (lemma, upos, p_lemma, p_upos)
+// pairs stored in the live collocations table along with an upper
+// bound of their number.
+func (cdb *CollDatabase) livePairs() (recordIterator, int, error) {
+	tableName := cdb.liveTables().fcolls
+	row := cdb.db.QueryRowContext(cdb.ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName))
+	var numRows int
+	if err := row.Scan(&numRows); err != nil {
+		return nil, 0, err
+	}
+	rows, err := cdb.db.QueryContext(
+		cdb.ctx,
+		fmt.Sprintf("SELECT DISTINCT lemma, upos, p_lemma, p_upos FROM %s", tableName),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &rowsIterator{rows: rows, numFields: 4}, numRows, nil
+}
+
+var (
+	sourcesColumns = []string{
+		"source_id", "path", "num_tokens", "num_sentences", "num_docs", "imported_at"}
+	importsColumns = []string{
+		"import_type", "source_path", "source_id", "co_occ_span", "scollex_version",
+		"fcolls_rows", "child_sums_rows", "parent_sums_rows", "token_freqs_rows",
+		"triples_rows", "started_at", "finished_at",
+	}
+)
+
+// copyLiveTables copies all the live tables into (empty) staging ones.
+// Record IDs are preserved. Please note that columns are listed
+// explicitly as tables migrated from older versions may have them
+// in a different order.
+func (cdb *CollDatabase) copyLiveTables() error {
+	live := cdb.liveTables()
+	staging := cdb.stagingTables()
+	for i, columns := range [][]string{
+		fcollsColumns,
+		childSumsColumns,
+		parentSumsColumns,
+		tokenFreqsColumns,
+		sourcesColumns,
+		importsColumns,
+		triplesColumns,
+	} {
+		cols := "id, " + strings.Join(columns, ", ")
+		t0 := time.Now()
+		_, err := cdb.db.ExecContext(
+			cdb.ctx,
+			fmt.Sprintf(
+				"INSERT INTO %s (%s) SELECT %s FROM %s",
+				staging.all()[i], cols, cols, live.all()[i],
+			),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s into %s: %w", live.all()[i], staging.all()[i], err)
+		}
+		log.Debug().
+			Str("table", live.all()[i]).
+			Float64("durationSec", time.Since(t0).Seconds()).
+			Msg("copied live table")
+	}
+	return nil
+}
+
+// mergeDeltaTables adds counts from delta tables to the staging copies
+// of the live tables and recalculates co-occurrence scores of all
+// the collocations involving tokens found in the appended data.
+//
+// The live tables are only read (see copyLiveTables) so they are not
+// locked by the (possibly long-running) updates and the merged data
+// replace them at once by swapping the generations of tables.
+// Each statement is committed on its own as the staging tables are
+// not used by anyone else and they are recreated in case of a failure.
+func (cdb *CollDatabase) mergeDeltaTables() error {
+	at := cdb.appendTables()
+	for _, dt := range []deltaTable{
+		at.fcolls, at.childSums, at.parentSums, at.tokenFreqs, at.triples} {
+		// existing records must be updated before new ones are inserted
+		_, err := cdb.db.ExecContext(cdb.ctx, fmt.Sprintf(
+			"UPDATE %s AS t JOIN %s AS d ON %s SET t.freq = t.freq + d.freq",
+			dt.target, dt.name, dt.joinCond("t", "d"),
+		))
+		if err != nil {
+			return fmt.Errorf("failed to merge %s into %s: %w", dt.name, dt.target, err)
+		}
+		insertCols := strings.Join(dt.columns, ", ") + ", freq"
+		selectCols := "d." + strings.Join(dt.columns, ", d.") + ", d.freq"
+		if dt.name == at.fcolls.name {
+			// All the variants (deprel, feats etc.) of a pair share
+			// co-occurrences of the pair so a new variant of a known pair
+			// starts with the existing value (the appended co-occurrences
+			// are added below).
+			insertCols += ", co_occurrence_freq"
+			selectCols += fmt.Sprintf(
+				", COALESCE((SELECT MAX(e.co_occurrence_freq) FROM %s AS e WHERE %s), 0)",
+				dt.target, at.coOccs.joinCond("e", "d"),
+			)
+		}
+		_, err = cdb.db.ExecContext(cdb.ctx, fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT %s FROM %s AS d "+
+				"LEFT JOIN %s AS t ON %s WHERE t.id IS NULL",
+			dt.target, insertCols, selectCols, dt.name,
+			dt.target, dt.joinCond("t", "d"),
+		))
+		if err != nil {
+			return fmt.Errorf("failed to merge %s into %s: %w", dt.name, dt.target, err)
+		}
+		log.Debug().Str("table", dt.target).Msg("merged appended counts")
+	}
+
+	_, err := cdb.db.ExecContext(cdb.ctx, fmt.Sprintf(
+		"UPDATE %s AS t JOIN %s AS d ON %s "+
+			"SET t.co_occurrence_freq = t.co_occurrence_freq + d.freq",
+		at.coOccs.target, at.coOccs.name, at.coOccs.joinCond("t", "d"),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to merge %s into %s: %w", at.coOccs.name, at.coOccs.target, err)
+	}
+
+	// Both fx and fy change for all the tokens from the appended data
+	// so we have to recalculate scores of collocations where the tokens
+	// occur either as a child or as a parent. Please note that
+	// the substitution of the invalid logDice values is the same
+	// as in writeFxy (pairs of lemmas without token frequencies are
+	// not joined so their scores stay NULL) and that the `2e0` literal forces floating point
+	// division (integer division yields a DECIMAL with only a few
+	// fractional digits in MySQL).
+	for _, deltaJoin := range []string{
+		"t.lemma = dt.lemma AND t.upos = dt.upos",
+		"t.p_lemma = dt.lemma AND t.p_upos = dt.upos",
+	} {
+		_, err := cdb.db.ExecContext(cdb.ctx, fmt.Sprintf(
+			"UPDATE %s AS t "+
+				"JOIN %s AS dt ON %s "+
+				"JOIN %s AS tx ON t.lemma = tx.lemma AND t.upos = tx.upos "+
+				"JOIN %s AS ty ON t.p_lemma = ty.lemma AND t.p_upos = ty.upos "+
+				"SET t.co_occurrence_score = IF(t.co_occurrence_freq = 0, -3.4e38, "+
+				"14 + LOG2(2e0 * t.co_occurrence_freq / (tx.freq + ty.freq)))",
+			at.fcolls.target, at.tokenFreqs.name, deltaJoin,
+			at.tokenFreqs.target, at.tokenFreqs.target,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to recalculate co-occurrence scores: %w", err)
+		}
+	}
+
+	// the same applies to scores of triples (see tripleScore)
+	for _, deltaJoin := range []string{
+		"t.lemma = dt.lemma AND t.upos = dt.upos",
+		"t.lemma1 = dt.lemma AND t.upos1 = dt.upos",
+		"t.lemma2 = dt.lemma AND t.upos2 = dt.upos",
+	} {
+		_, err := cdb.db.ExecContext(cdb.ctx, fmt.Sprintf(
+			"UPDATE %s AS t "+
+				"JOIN %s AS dt ON %s "+
+				"JOIN %s AS tx ON t.lemma = tx.lemma AND t.upos = tx.upos "+
+				"JOIN %s AS ty ON t.lemma1 = ty.lemma AND t.upos1 = ty.upos "+
+				"JOIN %s AS tz ON t.lemma2 = tz.lemma AND t.upos2 = tz.upos "+
+				"SET t.score = 14 + LOG2(3e0 * t.freq / (tx.freq + ty.freq + tz.freq))",
+			at.triples.target, at.tokenFreqs.name, deltaJoin,
+			at.tokenFreqs.target, at.tokenFreqs.target, at.tokenFreqs.target,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to recalculate scores of triples: %w", err)
+		}
+	}
+	return nil
+}
+
+// runAppend counts data of a vertical file and adds them to the
+// existing live tables. Only the new data are processed.
+//
+// Please note that the result is an approximation of a full re-import:
+// co-occurrences of pairs spanning the boundary between the original
+// data and the appended file are not counted and for pairs first seen in
+// the appended data, co-occurrences are known only from the appended file.
+func runAppend(
+	corpusID, vertPath string,
+	conf *SyntaxProps,
+	db *sql.DB,
+	opts ImportOptions,
+	progress *importProgress,
+) error {
+	started := time.Now()
+	cdb := NewCollDatabase(db, corpusID)
+	if err := cdb.checkAppendSupported(); err != nil {
+		return err
+	}
+	sourceID, err := vertSourceID(vertPath)
+	if err != nil {
+		return err
+	}
+	imported, err := cdb.IsSourceImported(sourceID)
+	if err != nil {
+		return err
+	}
+	if imported {
+		return fmt.Errorf("source %s (%s) already imported", vertPath, sourceID)
+	}
+	if opts.SinglePass {
+		log.Warn().Msg("single pass processing not supported when appending data, using two passes")
+	}
+
+	spills := newImportSpills(opts.SpillDir, corpusID)
+	defer spills.Close()
+	validator := newVertValidator(vertPath, conf, opts.MaxNumErrors)
+	proc, coProc, err := countTwoPass(vertPath, conf, spills, opts, validator, cdb, progress)
+	if err := validator.Finish(opts.ValidationReport); err != nil {
+		log.Error().Err(err).Msg("failed to finish vertical file validation")
+	}
+	if err != nil {
+		return err
+	}
+
+	pairs, err := finishSpilling(spills.table, proc.Table.records())
+	if err != nil {
+		return err
+	}
+	defer pairs.Close()
+	coOccs, err := finishSpilling(spills.coOccs, coProc.CoOccTable.records())
+	if err != nil {
+		return err
+	}
+	defer coOccs.Close()
+	children, err := finishSpilling(spills.children, proc.ChildCounts.records())
+	if err != nil {
+		return err
+	}
+	defer children.Close()
+	parents, err := finishSpilling(spills.parents, proc.ParentCounts.records())
+	if err != nil {
+		return err
+	}
+	defer parents.Close()
+	triples, err := finishSpilling(spills.triples, proc.Triples.records())
+	if err != nil {
+		return err
+	}
+	defer triples.Close()
+
+	progress.setPhase(ImportPhaseWriting)
+	if err := cdb.createDeltaTables(); err != nil {
+		return err
+	}
+	defer cdb.dropDeltaTables()
+
+	t0 := time.Now()
+	log.Info().Msg("writing appended counts into database")
+	tx, err := db.BeginTx(cdb.ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	// a no-op once the transaction is committed
+	defer tx.Rollback()
+	at := cdb.appendTables()
+	importRec := newImportRecord(ImportTypeAppend, vertPath, sourceID, opts, started)
+	for _, item := range []struct {
+		records recordIterator
+		table   deltaTable
+		numRows *int64
+	}{
+		{records: pairs, table: at.fcolls, numRows: &importRec.TableRows.Fcolls},
+		{records: coOccs, table: at.coOccs},
+		{records: children, table: at.childSums, numRows: &importRec.TableRows.ChildSums},
+		{records: parents, table: at.parentSums, numRows: &importRec.TableRows.ParentSums},
+		{
+			records: &sliceIterator{records: coProc.TokenCounts.records()},
+			table:   at.tokenFreqs,
+			numRows: &importRec.TableRows.TokenFreqs,
+		},
+		{records: triples, table: at.triples, numRows: &importRec.TableRows.Triples},
+	} {
+		sink, err := newRowSink(tx, item.table.name, item.table.sinkColumns(), opts)
+		if err != nil {
+			return err
+		}
+		numRows, err := writeRecords(sink, item.records)
+		if err != nil {
+			return err
+		}
+		if item.numRows != nil {
+			*item.numRows = numRows
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Info().Msg("merging appended counts with live tables")
+	if err := cdb.createStagingTables(false); err != nil {
+		return fmt.Errorf("failed to initialize database tables: %w", err)
+	}
+	if err := cdb.copyLiveTables(); err != nil {
+		return err
+	}
+	// indexes are created once the live data are copied
+	// (they are required by the joins of the merge)
+	if err := cdb.createMissingIndexes(cdb.stagingTables()); err != nil {
+		return err
+	}
+	if err := cdb.mergeDeltaTables(); err != nil {
+		return err
+	}
+	tx, err = db.BeginTx(cdb.ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = writeSource(
+		tx, cdb.stagingTables().sources, sourceID, vertPath, coProc.size(), opts.Location)
+	if err != nil {
+		return err
+	}
+	err = writeImportRecord(tx, cdb.stagingTables().imports, importRec, opts.Location)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := cdb.SwapStagingTables(); err != nil {
+		return err
+	}
+	log.Info().Float64("durationSec", time.Since(t0).Seconds()).Msg("...appending done")
+
+	corpusSize, err := cdb.GetCorpusSize()
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Int64("numTokens", coProc.NumTokens).
+		Int64("corpusSize", corpusSize.NumTokens).
+		Int64("corpusSentences", corpusSize.NumSentences).
+		Int64("corpusDocs", corpusSize.NumDocs).
+		Msg("appended data merged")
+	return nil
+}
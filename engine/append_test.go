@@ -0,0 +1,143 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+const (
+	// testDSNEnv specifies a MySQL/MariaDB database used by tests
+	// requiring a database server. If not set, such tests are skipped.
+	testDSNEnv = "SCOLLEX_TEST_DSN"
+
+	testCorpusID = "scollex_test"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testDSNEnv)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestMergeDeltaTablesNewPairVariant tests that a new variant (here with
+// different feats) of a pair already stored in the live tables gets
+// the same co-occurrence frequency (and score) as the existing variant
+func TestMergeDeltaTablesNewPairVariant(t *testing.T) {
+	db := openTestDB(t)
+	cdb := NewCollDatabase(db, testCorpusID)
+	if err := cdb.createStagingTables(true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cdb.dropTables(db, cdb.stagingTables()) })
+	if err := cdb.createDeltaTables(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cdb.dropDeltaTables)
+
+	staging := cdb.stagingTables()
+	at := cdb.appendTables()
+	for _, stmt := range []string{
+		// data of the live tables (copied into the staging ones)
+		fmt.Sprintf(
+			"INSERT INTO %s (lemma, upos, p_lemma, p_upos, deprel, freq, co_occurrence_freq) "+
+				"VALUES ('dog', 'NOUN', 'bark', 'VERB', 'nsubj', 5, 10)",
+			staging.fcolls),
+		fmt.Sprintf(
+			"INSERT INTO %s (lemma, upos, freq) VALUES ('dog', 'NOUN', 20), ('bark', 'VERB', 30)",
+			staging.tokenFreqs),
+		// appended data
+		fmt.Sprintf(
+			"INSERT INTO %s (lemma, upos, p_lemma, p_upos, deprel, feats, p_feats, marker, "+
+				"polarity, freq) "+
+				"VALUES ('dog', 'NOUN', 'bark', 'VERB', 'nsubj', 'Number=Plur', '', '', '', 2)",
+			at.fcolls.name),
+		fmt.Sprintf(
+			"INSERT INTO %s (lemma, upos, p_lemma, p_upos, freq) "+
+				"VALUES ('dog', 'NOUN', 'bark', 'VERB', 3)",
+			at.coOccs.name),
+		fmt.Sprintf(
+			"INSERT INTO %s (lemma, upos, freq) VALUES ('dog', 'NOUN', 2), ('bark', 'VERB', 2)",
+			at.tokenFreqs.name),
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cdb.mergeDeltaTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT feats, freq, co_occurrence_freq, co_occurrence_score FROM %s "+
+			"WHERE lemma = 'dog' AND p_lemma = 'bark' ORDER BY feats",
+		staging.fcolls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	// fx = 20 + 2, fy = 30 + 2
+	score := 14 + math.Log2(2*13.0/(22+32))
+	expected := []struct {
+		feats      string
+		freq       int64
+		coOccFreq  int64
+		coOccScore float64
+	}{
+		{"", 5, 13, score},
+		{"Number=Plur", 2, 13, score},
+	}
+	var i int
+	for ; rows.Next(); i++ {
+		var feats string
+		var freq, coOccFreq int64
+		var coOccScore float64
+		if err := rows.Scan(&feats, &freq, &coOccFreq, &coOccScore); err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(expected) {
+			continue
+		}
+		exp := expected[i]
+		if feats != exp.feats || freq != exp.freq || coOccFreq != exp.coOccFreq {
+			t.Errorf(
+				"expected row (%q, %d, %d), found (%q, %d, %d)",
+				exp.feats, exp.freq, exp.coOccFreq, feats, freq, coOccFreq)
+		}
+		// the column is FLOAT
+		if diff := coOccScore - exp.coOccScore; diff > 1e-4 || diff < -1e-4 {
+			t.Errorf("expected score %v of %q, found %v", exp.coOccScore, feats, coOccScore)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d rows, found %d", len(expected), i)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+const (
+	bloomBitsPerItem = 10
+	bloomNumHashes   = 7
+)
+
+// pairBloomFilter is a probabilistic set of (lemma, upos, coLemma, coUpos)
+// tuples. It is used instead of a complete table of syntactic pairs
+// in case the table does not fit into the configured memory budget.
+// False positives are later removed by merge-joining with the actual
+// (spilled) syntactic pairs.
+type pairBloomFilter struct {
+	bits []uint64
+	size uint64
+}
+
+func (bf *pairBloomFilter) hashes(lemma, upos, coLemma, coUpos string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join([]string{lemma, upos, coLemma, coUpos}, "\t")))
+	v := h.Sum64()
+	return v, (v >> 32) | 1
+}
+
+func (bf *pairBloomFilter) Add(lemma, upos, coLemma, coUpos string) {
+	h1, h2 := bf.hashes(lemma, upos, coLemma, coUpos)
+	for i := uint64(0); i < bloomNumHashes; i++ {
+		pos := (h1 + i*h2) % bf.size
+		bf.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (bf *pairBloomFilter) Has(lemma, upos, coLemma, coUpos string) bool {
+	h1, h2 := bf.hashes(lemma, upos, coLemma, coUpos)
+	for i := uint64(0); i < bloomNumHashes; i++ {
+		pos := (h1 + i*h2) % bf.size
+		if bf.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func newPairBloomFilter(numItems int) *pairBloomFilter {
+	size := uint64(numItems*bloomBitsPerItem/64+1) * 64
+	return &pairBloomFilter{
+		bits: make([]uint64, size/64),
+		size: size,
+	}
+}
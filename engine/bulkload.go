@@ -0,0 +1,314 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sinkMethodInsert   = "insert"
+	sinkMethodLoadData = "loadData"
+)
+
+// rowSink is a target rows of an import table are written to
+type rowSink interface {
+
+	// Add adds a row. The values must match the sink's columns.
+	// A nil value is written as NULL.
+	Add(values ...any) error
+
+	// Finish writes all the pending rows and returns
+	// the total number of rows written by the sink
+	Finish() (int64, error)
+
+	// Abort discards pending rows after a failure
+	Abort()
+
+	numColumns() int
+}
+
+// sinkStats measures throughput of a sink so both the write
+// methods can be compared on real data
+type sinkStats struct {
+	method    string
+	tableName string
+	numRows   int64
+	started   time.Time
+}
+
+func (ss *sinkStats) log() {
+	dur := time.Since(ss.started).Seconds()
+	evt := log.Info().
+		Str("table", ss.tableName).
+		Str("method", ss.method).
+		Int64("numRows", ss.numRows).
+		Float64("durationSec", dur)
+	if dur > 0 {
+		evt = evt.Float64("rowsPerSec", float64(ss.numRows)/dur)
+	}
+	evt.Msg("table rows written")
+}
+
+// ------------------------------
+
+// insertSink writes rows using multi-row INSERT
+// statements of bulkInsertChunkSize rows
+type insertSink struct {
+	target       bulkExecer
+	columns      []string
+	insertSQL    string
+	placeholder  string
+	args         []any
+	placeholders []string
+	stats        sinkStats
+}
+
+func (sink *insertSink) numColumns() int {
+	return len(sink.columns)
+}
+
+func (sink *insertSink) flush() error {
+	if len(sink.placeholders) == 0 {
+		return nil
+	}
+	_, err := sink.target.Exec(sink.insertSQL+strings.Join(sink.placeholders, ", "), sink.args...)
+	if err != nil {
+		sink.target.Rollback()
+		return err
+	}
+	log.Debug().
+		Str("table", sink.stats.tableName).
+		Int("items", len(sink.placeholders)).
+		Msg("written bulk into database")
+	sink.args = make([]any, 0, bulkInsertChunkSize*len(sink.columns))
+	sink.placeholders = make([]string, 0, bulkInsertChunkSize)
+	return nil
+}
+
+func (sink *insertSink) Add(values ...any) error {
+	if len(sink.placeholders) == bulkInsertChunkSize {
+		if err := sink.flush(); err != nil {
+			return err
+		}
+	}
+	sink.args = append(sink.args, values...)
+	sink.placeholders = append(sink.placeholders, sink.placeholder)
+	sink.stats.numRows++
+	return nil
+}
+
+func (sink *insertSink) Finish() (int64, error) {
+	if err := sink.flush(); err != nil {
+		return sink.stats.numRows - int64(len(sink.placeholders)), err
+	}
+	sink.stats.log()
+	return sink.stats.numRows, nil
+}
+
+func (sink *insertSink) Abort() {
+	sink.target.Rollback()
+}
+
+func newInsertSink(target bulkExecer, tableName string, columns []string) *insertSink {
+	return &insertSink{
+		target:  target,
+		columns: columns,
+		insertSQL: fmt.Sprintf(
+			"INSERT INTO %s (%s) VALUES ", tableName, strings.Join(columns, ", ")),
+		placeholder:  "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")",
+		args:         make([]any, 0, bulkInsertChunkSize*len(columns)),
+		placeholders: make([]string, 0, bulkInsertChunkSize),
+		stats:        sinkStats{method: sinkMethodInsert, tableName: tableName, started: time.Now()},
+	}
+}
+
+// ------------------------------
+
+// loadDataSink streams rows into a temporary TSV file which is then
+// loaded at once using LOAD DATA LOCAL INFILE. This is considerably
+// faster than INSERT statements but the database server must allow
+// loading local files (`local_infile = 1` in case of MySQL/MariaDB).
+//
+// Written values come from tab-separated vertical files so they
+// cannot contain tabs or newlines. The file is therefore written
+// without any escaping (which also keeps backslashes in lemmas intact).
+// As `\N` is not recognized without escaping, NULL values are written
+// as empty fields and converted back to NULL when loaded. This means
+// a column containing NULL values must not contain empty strings.
+type loadDataSink struct {
+	target    bulkExecer
+	tableName string
+	columns   []string
+	file      *os.File
+	buff      *bufio.Writer
+	rowBuff   []byte
+	stats     sinkStats
+
+	// nullable marks columns where at least one NULL value
+	// has been written
+	nullable []bool
+}
+
+func (sink *loadDataSink) numColumns() int {
+	return len(sink.columns)
+}
+
+func (sink *loadDataSink) appendValue(value any) error {
+	switch tValue := value.(type) {
+	case string:
+		if strings.ContainsAny(tValue, "\t\n") {
+			return fmt.Errorf("cannot bulk load value containing tab or newline: %q", tValue)
+		}
+		sink.rowBuff = append(sink.rowBuff, tValue...)
+	case int:
+		sink.rowBuff = strconv.AppendInt(sink.rowBuff, int64(tValue), 10)
+	case int64:
+		sink.rowBuff = strconv.AppendInt(sink.rowBuff, tValue, 10)
+	case float64:
+		sink.rowBuff = strconv.AppendFloat(sink.rowBuff, tValue, 'g', -1, 64)
+	default:
+		return fmt.Errorf("cannot bulk load value of type %T", value)
+	}
+	return nil
+}
+
+func (sink *loadDataSink) Add(values ...any) error {
+	sink.rowBuff = sink.rowBuff[:0]
+	for i, v := range values {
+		if i > 0 {
+			sink.rowBuff = append(sink.rowBuff, '\t')
+		}
+		if v == nil {
+			sink.nullable[i] = true
+			continue
+		}
+		if err := sink.appendValue(v); err != nil {
+			sink.Abort()
+			return err
+		}
+	}
+	sink.rowBuff = append(sink.rowBuff, '\n')
+	if _, err := sink.buff.Write(sink.rowBuff); err != nil {
+		sink.Abort()
+		return fmt.Errorf("failed to write bulk load file: %w", err)
+	}
+	sink.stats.numRows++
+	return nil
+}
+
+// columnsSQL provides the column list of the LOAD DATA statement.
+// Columns containing NULL values are read into user variables
+// and their empty values are converted to NULL.
+func (sink *loadDataSink) columnsSQL() string {
+	columns := make([]string, len(sink.columns))
+	var setSQL []string
+	for i, col := range sink.columns {
+		if !sink.nullable[i] {
+			columns[i] = col
+			continue
+		}
+		columns[i] = fmt.Sprintf("@v%d", i)
+		setSQL = append(setSQL, fmt.Sprintf("%s = NULLIF(@v%d, '')", col, i))
+	}
+	ans := "(" + strings.Join(columns, ", ") + ")"
+	if len(setSQL) > 0 {
+		ans += " SET " + strings.Join(setSQL, ", ")
+	}
+	return ans
+}
+
+func (sink *loadDataSink) Finish() (int64, error) {
+	defer os.Remove(sink.file.Name())
+	if err := sink.buff.Flush(); err != nil {
+		sink.Abort()
+		return 0, fmt.Errorf("failed to write bulk load file: %w", err)
+	}
+	if err := sink.file.Close(); err != nil {
+		sink.target.Rollback()
+		return 0, fmt.Errorf("failed to write bulk load file: %w", err)
+	}
+	if sink.stats.numRows == 0 {
+		return 0, nil
+	}
+	mysql.RegisterLocalFile(sink.file.Name())
+	defer mysql.DeregisterLocalFile(sink.file.Name())
+	res, err := sink.target.Exec(fmt.Sprintf(
+		"LOAD DATA LOCAL INFILE '%s' INTO TABLE %s "+
+			"FIELDS TERMINATED BY '\\t' ESCAPED BY '' LINES TERMINATED BY '\\n' %s",
+		sink.file.Name(), sink.tableName, sink.columnsSQL()))
+	if err != nil {
+		sink.target.Rollback()
+		return 0, fmt.Errorf("failed to bulk load table %s: %w", sink.tableName, err)
+	}
+	// with LOCAL, the server turns data errors into warnings
+	// so we have to make sure nothing has been skipped
+	numLoaded, err := res.RowsAffected()
+	if err != nil {
+		sink.target.Rollback()
+		return 0, err
+	}
+	if numLoaded != sink.stats.numRows {
+		sink.target.Rollback()
+		return numLoaded, fmt.Errorf(
+			"failed to bulk load table %s: loaded %d of %d rows",
+			sink.tableName, numLoaded, sink.stats.numRows)
+	}
+	sink.stats.log()
+	return numLoaded, nil
+}
+
+func (sink *loadDataSink) Abort() {
+	sink.file.Close()
+	os.Remove(sink.file.Name())
+	sink.target.Rollback()
+}
+
+func newLoadDataSink(target bulkExecer, tableName string, columns []string, tmpDir string) (*loadDataSink, error) {
+	file, err := os.CreateTemp(tmpDir, "scollex-load-"+tableName+"-*.tsv")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bulk load file: %w", err)
+	}
+	return &loadDataSink{
+		target:    target,
+		tableName: tableName,
+		columns:   columns,
+		file:      file,
+		buff:      bufio.NewWriterSize(file, 1024*1024),
+		stats:     sinkStats{method: sinkMethodLoadData, tableName: tableName, started: time.Now()},
+		nullable:  make([]bool, len(columns)),
+	}, nil
+}
+
+// ------------------------------
+
+// newRowSink creates a sink writing into a table with provided
+// columns. The write method is selected based on ImportOptions.BulkLoad.
+func newRowSink(target bulkExecer, tableName string, columns []string, opts ImportOptions) (rowSink, error) {
+	if opts.BulkLoad {
+		return newLoadDataSink(target, tableName, columns, opts.SpillDir)
+	}
+	return newInsertSink(target, tableName, columns), nil
+}
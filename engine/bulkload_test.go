@@ -0,0 +1,379 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	benchNumRows = 50000
+
+	// benchDSNEnv specifies a MySQL/MariaDB database the sink benchmarks
+	// write into (the server must allow `local_infile`). If not set,
+	// statements are discarded so only the client side is measured.
+	benchDSNEnv = "SCOLLEX_BENCH_DSN"
+
+	benchTableName = "scollex_bench_fcolls"
+)
+
+// discardExecer accepts all the statements without executing them.
+// For LOAD DATA statements, all the rows written by the sink are
+// reported as loaded.
+type discardExecer struct {
+	numRows int64
+}
+
+func (de *discardExecer) Exec(query string, args ...any) (sql.Result, error) {
+	if strings.HasPrefix(query, "LOAD DATA") {
+		return driver.RowsAffected(de.numRows), nil
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (de *discardExecer) Rollback() error {
+	return nil
+}
+
+// recordingExecer renders rows written by a sink the way
+// the database would store them. Values are strings,
+// nil stands for NULL.
+type recordingExecer struct {
+	rows    [][]any
+	columns []string
+}
+
+var (
+	loadDataColumnsRegexp = regexp.MustCompile(`LINES TERMINATED BY '\\n' \(([^)]*)\)(?: SET (.*))?$`)
+	nullifRegexp          = regexp.MustCompile(`^(\w+) = NULLIF\((@\w+), ''\)$`)
+)
+
+func (re *recordingExecer) Exec(query string, args ...any) (sql.Result, error) {
+	if strings.HasPrefix(query, "LOAD DATA") {
+		return re.execLoadData(query)
+	}
+	return re.execInsert(query, args)
+}
+
+// execInsert renders rows of a multi-row INSERT
+func (re *recordingExecer) execInsert(query string, args []any) (sql.Result, error) {
+	cols := query[strings.Index(query, "(")+1 : strings.Index(query, ")")]
+	re.columns = strings.Split(cols, ", ")
+	for i := 0; i < len(args); i += len(re.columns) {
+		row := make([]any, len(re.columns))
+		for j, v := range args[i : i+len(re.columns)] {
+			switch tv := v.(type) {
+			case nil:
+				row[j] = nil
+			case string:
+				row[j] = tv
+			case int64:
+				row[j] = strconv.FormatInt(tv, 10)
+			case float64:
+				row[j] = strconv.FormatFloat(tv, 'g', -1, 64)
+			default:
+				return nil, fmt.Errorf("unexpected value of type %T", v)
+			}
+		}
+		re.rows = append(re.rows, row)
+	}
+	return driver.RowsAffected(len(args) / len(re.columns)), nil
+}
+
+// execLoadData renders rows of a loaded file (the file is still
+// available as it is removed once the statement is executed).
+// Only the subset of the LOAD DATA syntax written by loadDataSink
+// is supported.
+func (re *recordingExecer) execLoadData(query string) (sql.Result, error) {
+	path := strings.Split(query, "'")[1]
+	srch := loadDataColumnsRegexp.FindStringSubmatch(query)
+	if srch == nil {
+		return nil, fmt.Errorf("unsupported statement: %s", query)
+	}
+	targets := strings.Split(srch[1], ", ")
+	// user variables are assigned to columns as NULL if empty
+	assignments := make(map[string]string)
+	if srch[2] != "" {
+		for _, item := range strings.Split(srch[2], "), ") {
+			asrch := nullifRegexp.FindStringSubmatch(strings.TrimSuffix(item, ")") + ")")
+			if asrch == nil {
+				return nil, fmt.Errorf("unsupported assignment: %s", item)
+			}
+			assignments[asrch[2]] = asrch[1]
+		}
+	}
+	re.columns = make([]string, len(targets))
+	for i, target := range targets {
+		re.columns[i] = target
+		if strings.HasPrefix(target, "@") {
+			re.columns[i] = assignments[target]
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	if lines[len(lines)-1] != "" {
+		return nil, fmt.Errorf("unterminated line %q", lines[len(lines)-1])
+	}
+	var numLoaded int64
+	for _, line := range lines[:len(lines)-1] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(targets) {
+			// LOCAL turns the error into a warning
+			continue
+		}
+		row := make([]any, len(fields))
+		for j, field := range fields {
+			if strings.HasPrefix(targets[j], "@") && field == "" {
+				continue
+			}
+			row[j] = field
+		}
+		re.rows = append(re.rows, row)
+		numLoaded++
+	}
+	return driver.RowsAffected(numLoaded), nil
+}
+
+func (re *recordingExecer) Rollback() error {
+	return nil
+}
+
+// TestSinksWriteSameRows tests that both the write methods
+// store the same values
+func TestSinksWriteSameRows(t *testing.T) {
+	columns := []string{"lemma", "upos", "feats", "freq", "score"}
+	tests := []struct {
+		name   string
+		rows   [][]any
+		errRow int // index of a row rejected by loadDataSink (-1 = none)
+	}{
+		{
+			name: "plain values",
+			rows: [][]any{
+				{"dog", "NOUN", "Number=Sing", int64(5), 9.5},
+				{"bark", "VERB", "", int64(1), -3.4e38},
+			},
+			errRow: -1,
+		},
+		{
+			name: "backslashes are kept",
+			rows: [][]any{
+				{`C:\\dir`, "X", `\N`, int64(1), 0.25},
+				{`\t`, `\\`, `a\nb`, int64(2), 1.0},
+			},
+			errRow: -1,
+		},
+		{
+			name: "NULL and empty values",
+			rows: [][]any{
+				{"dog", "", "", int64(0), nil},
+				{"NULL", "NOUN", "", int64(3), 12.125},
+				{"", "", "", int64(0), nil},
+				{"cat", "NOUN", "", nil, nil},
+			},
+			errRow: -1,
+		},
+		{
+			name: "tab is rejected",
+			rows: [][]any{
+				{"dog", "NOUN", "", int64(1), 1.0},
+				{"a\tb", "NOUN", "", int64(1), 1.0},
+			},
+			errRow: 1,
+		},
+		{
+			name: "newline is rejected",
+			rows: [][]any{
+				{"dog", "NOUN", "a\nb", int64(1), nil},
+			},
+			errRow: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserted := &recordingExecer{}
+			insSink := newInsertSink(inserted, "test", columns)
+			for _, row := range tt.rows {
+				if err := insSink.Add(row...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := insSink.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := &recordingExecer{}
+			loadSink, err := newLoadDataSink(loaded, "test", columns, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, row := range tt.rows {
+				err := loadSink.Add(row...)
+				if i == tt.errRow {
+					if err == nil {
+						t.Fatalf("expected row %d to be rejected", i)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.errRow >= 0 {
+				t.Fatalf("expected row %d to be rejected", tt.errRow)
+			}
+			numRows, err := loadSink.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numRows != int64(len(tt.rows)) {
+				t.Errorf("expected %d loaded rows, found %d", len(tt.rows), numRows)
+			}
+			if !reflect.DeepEqual(loaded.columns, inserted.columns) {
+				t.Errorf("expected columns %v, found %v", inserted.columns, loaded.columns)
+			}
+			if !reflect.DeepEqual(loaded.rows, inserted.rows) {
+				t.Errorf("expected rows %q, found %q", inserted.rows, loaded.rows)
+			}
+		})
+	}
+}
+
+// benchFcolls generates rows of the fcolls table with values
+// resembling real data
+func benchFcolls(numRows int) [][]any {
+	ans := make([][]any, numRows)
+	for i := range ans {
+		ans[i] = []any{
+			fmt.Sprintf("lemma%d", i%5000),
+			"NOUN",
+			fmt.Sprintf("lemma%d", i/5000),
+			"VERB",
+			"obj",
+			"Case=Acc|Number=Sing",
+			"",
+			"",
+			"",
+			int64(i%100 + 1),
+			int64(i % 50),
+			9.123456789,
+		}
+	}
+	return ans
+}
+
+// openBenchTarget provides a target of the sinks (see benchDSNEnv)
+// along with a function finishing a single benchmark iteration.
+func openBenchTarget(b *testing.B) (func() bulkExecer, func(target bulkExecer)) {
+	dsn := os.Getenv(benchDSNEnv)
+	if dsn == "" {
+		return func() bulkExecer {
+				return &discardExecer{numRows: benchNumRows}
+			},
+			func(target bulkExecer) {}
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	// a temporary table is visible only within its connection
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(fmt.Sprintf(`CREATE TEMPORARY TABLE %s (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		lemma varchar(%d) NOT NULL,
+		upos varchar(50) NOT NULL,
+		p_lemma varchar(%d) NOT NULL,
+		p_upos varchar(50) NOT NULL,
+		deprel varchar(50) NOT NULL,
+		feats varchar(%d) NOT NULL DEFAULT '',
+		p_feats varchar(%d) NOT NULL DEFAULT '',
+		marker varchar(%d) NOT NULL DEFAULT '',
+		polarity varchar(%d) NOT NULL DEFAULT '',
+		freq int(11) NOT NULL,
+		co_occurrence_freq int(11) NOT NULL DEFAULT 0,
+		co_occurrence_score FLOAT,
+		PRIMARY KEY (id)
+	)`, benchTableName, defaultWordColumnSize, defaultWordColumnSize, featsColumnSize,
+		featsColumnSize, markerColumnSize, polarityColumnSize))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return func() bulkExecer {
+			tx, err := db.Begin()
+			if err != nil {
+				b.Fatal(err)
+			}
+			return tx
+		},
+		func(target bulkExecer) {
+			// written rows are discarded so all the iterations
+			// write into an empty table
+			target.Rollback()
+		}
+}
+
+func benchmarkSink(b *testing.B, newSink func(target bulkExecer) (rowSink, error)) {
+	rows := benchFcolls(benchNumRows)
+	begin, finish := openBenchTarget(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		target := begin()
+		sink, err := newSink(target)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := sink.Add(row...); err != nil {
+				b.Fatal(err)
+			}
+		}
+		numRows, err := sink.Finish()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if numRows != benchNumRows {
+			b.Fatalf("expected %d rows written, got %d", benchNumRows, numRows)
+		}
+		finish(target)
+	}
+}
+
+func BenchmarkInsertSink(b *testing.B) {
+	benchmarkSink(b, func(target bulkExecer) (rowSink, error) {
+		return newInsertSink(target, benchTableName, fcollsColumns), nil
+	})
+}
+
+func BenchmarkLoadDataSink(b *testing.B) {
+	tmpDir := b.TempDir()
+	benchmarkSink(b, func(target bulkExecer) (rowSink, error) {
+		return newLoadDataSink(target, benchTableName, fcollsColumns, tmpDir)
+	})
+}
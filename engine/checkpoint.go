@@ -0,0 +1,460 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	checkpointManifestFile = "checkpoint.json"
+
+	checkpointPairs      = "pairs"
+	checkpointChildren   = "children"
+	checkpointParents    = "parents"
+	checkpointTriples    = "triples"
+	checkpointCoOccs     = "cooccs"
+	checkpointTokenFreqs = "tokenfreqs"
+)
+
+// checkpointManifest describes the state of an import
+// so it can be resumed after a failure
+type checkpointManifest struct {
+	CorpusID   string    `json:"corpusId"`
+	SourceID   string    `json:"sourceId"`
+	CoOccSpan  int       `json:"coOccSpan"`
+	SinglePass bool      `json:"singlePass"`
+	StartedAt  time.Time `json:"startedAt"`
+
+	// SchemaVersion is a version of tables the checkpointed
+	// records are prepared for (their fields follow table columns)
+	SchemaVersion int `json:"schemaVersion"`
+
+	// Features are morphological features captured
+	// by the import (see SyntaxProps.Features)
+	Features []string `json:"features"`
+
+	// ConfHash is a checksum of the full syntax configuration
+	// of the corpus along with import options affecting stored
+	// records (see importConfHash)
+	ConfHash string `json:"confHash"`
+
+	// PairsDone is true once syntactic pairs (along with
+	// parent and child sums and triples) are stored
+	PairsDone bool `json:"pairsDone"`
+	NumPairs  int  `json:"numPairs"`
+
+	// CoOccsDone is true once co-occurrences and
+	// token frequencies are stored
+	CoOccsDone bool       `json:"coOccsDone"`
+	Size       CorpusSize `json:"size"`
+
+	// WritingStarted is true once staging tables
+	// have been prepared for writing
+	WritingStarted bool `json:"writingStarted"`
+
+	// WrittenRows contains numbers of rows written in committed
+	// bulk insert chunks for each staging table
+	WrittenRows map[string]int64 `json:"writtenRows"`
+}
+
+// importCheckpoint stores results of finished import phases
+// in a local directory
+type importCheckpoint struct {
+	dir      string
+	manifest checkpointManifest
+}
+
+func (ic *importCheckpoint) path(name string) string {
+	return filepath.Join(ic.dir, name+".run")
+}
+
+func (ic *importCheckpoint) save() error {
+	if err := writeJSONFile(filepath.Join(ic.dir, checkpointManifestFile), ic.manifest); err != nil {
+		return fmt.Errorf("failed to save import checkpoint: %w", err)
+	}
+	return nil
+}
+
+// saveRecords stores sorted records (closing the iterator)
+// and returns the number of the stored records
+func (ic *importCheckpoint) saveRecords(name string, records recordIterator) (int, error) {
+	defer records.Close()
+	f, err := os.CreateTemp(ic.dir, name+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to save import checkpoint: %w", err)
+	}
+	bw := bufio.NewWriterSize(f, spillFileBufferSize)
+	var ans int
+	for {
+		rec, err := records.Next()
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		writeRecord(bw, rec)
+		ans++
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, fmt.Errorf("failed to save import checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return 0, fmt.Errorf("failed to save import checkpoint: %w", err)
+	}
+	if err := os.Rename(f.Name(), ic.path(name)); err != nil {
+		return 0, fmt.Errorf("failed to save import checkpoint: %w", err)
+	}
+	return ans, nil
+}
+
+// records provides stored records. The iterator must be
+// closed by the caller.
+func (ic *importCheckpoint) records(name string) (recordIterator, error) {
+	return newMergeIterator([]string{ic.path(name)})
+}
+
+// tokenCounts loads stored token frequencies
+func (ic *importCheckpoint) tokenCounts() (FyTable, error) {
+	records, err := ic.records(checkpointTokenFreqs)
+	if err != nil {
+		return FyTable{}, err
+	}
+	defer records.Close()
+	ans := newFyTable(newStringPool())
+	for {
+		rec, err := records.Next()
+		if err != nil {
+			return FyTable{}, err
+		}
+		if rec == nil {
+			return ans, nil
+		}
+		ans.Add(rec.Fields[0], rec.Fields[1], "", rec.Freq)
+	}
+}
+
+// savePairs stores results of syntactic pairs counting
+func (ic *importCheckpoint) savePairs(proc *VertProcessor, spills *importSpills) error {
+	for _, item := range []struct {
+		name    string
+		store   *spillStore
+		records []spillRecord
+	}{
+		{checkpointPairs, spills.table, proc.Table.records()},
+		{checkpointChildren, spills.children, proc.ChildCounts.records()},
+		{checkpointParents, spills.parents, proc.ParentCounts.records()},
+		{checkpointTriples, spills.triples, proc.Triples.records()},
+	} {
+		records, err := finishSpilling(item.store, item.records)
+		if err != nil {
+			return err
+		}
+		numRecords, err := ic.saveRecords(item.name, records)
+		if err != nil {
+			return err
+		}
+		if item.name == checkpointPairs {
+			ic.manifest.NumPairs = numRecords
+		}
+		// the runs are not needed anymore
+		if err := item.store.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to remove spilled data")
+		}
+	}
+	ic.manifest.PairsDone = true
+	return ic.save()
+}
+
+// saveCoOccs stores results of co-occurrences counting
+func (ic *importCheckpoint) saveCoOccs(coProc *CoVertProcessor, spills *importSpills) error {
+	coOccs, err := finishSpilling(spills.coOccs, coProc.CoOccTable.records())
+	if err != nil {
+		return err
+	}
+	if _, err := ic.saveRecords(checkpointCoOccs, coOccs); err != nil {
+		return err
+	}
+	if err := spills.coOccs.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to remove spilled data")
+	}
+	tokenFreqs := coProc.TokenCounts.records()
+	sortRecords(tokenFreqs)
+	if _, err := ic.saveRecords(checkpointTokenFreqs, &sliceIterator{records: tokenFreqs}); err != nil {
+		return err
+	}
+	ic.manifest.Size = coProc.size()
+	ic.manifest.CoOccsDone = true
+	return ic.save()
+}
+
+// coOccCandidates prepares a set of stored syntactic pairs we
+// count co-occurrences for. In case memory is limited, a Bloom
+// filter is used.
+func (ic *importCheckpoint) coOccCandidates(opts ImportOptions) (pairSet, error) {
+	pairs, err := ic.records(checkpointPairs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MemoryLimitMB > 0 {
+		bf := newPairBloomFilter(ic.manifest.NumPairs)
+		if err := addToBloomFilter(bf, pairs); err != nil {
+			return nil, err
+		}
+		log.Info().Msg("cooccurrence candidates filter done")
+		return bf, nil
+	}
+	defer pairs.Close()
+	ans := newCoOccTable(newStringPool())
+	for {
+		rec, err := pairs.Next()
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return ans, nil
+		}
+		ans.Add(rec.Fields[0], rec.Fields[1], rec.Fields[2], rec.Fields[3], 0)
+	}
+}
+
+// writeTable writes stored data into a staging table using `write`.
+// Each bulk insert chunk is committed separately so in case the table
+// already contains some rows (i.e. a resumed import), the respective
+// number of records is skipped.
+func (ic *importCheckpoint) writeTable(
+	cdb *CollDatabase,
+	tableName string,
+	write func(target bulkExecer, numSkip int64) (int64, error),
+) (int64, error) {
+	numWritten, err := cdb.countRows(tableName)
+	if err != nil {
+		return 0, err
+	}
+	if recorded := ic.manifest.WrittenRows[tableName]; recorded != numWritten {
+		// this may happen in case the import stopped after a chunk
+		// has been committed but before the checkpoint was saved
+		log.Warn().
+			Str("table", tableName).
+			Int64("recordedRows", recorded).
+			Int64("foundRows", numWritten).
+			Msg("checkpoint differs from the staging table, using the number of found rows")
+		ic.manifest.WrittenRows[tableName] = numWritten
+	}
+	if numWritten > 0 {
+		log.Info().
+			Str("table", tableName).
+			Int64("numRows", numWritten).
+			Msg("resuming writing into a partially filled table")
+	}
+	target := &chunkCommitter{db: cdb.db, checkpoint: ic, tableName: tableName}
+	numNew, err := write(target, numWritten)
+	return numWritten + numNew, err
+}
+
+// Remove deletes all the checkpoint data
+func (ic *importCheckpoint) Remove() {
+	if err := os.RemoveAll(ic.dir); err != nil {
+		log.Error().Err(err).Str("dir", ic.dir).Msg("failed to remove import checkpoint")
+	}
+}
+
+func (ic *importCheckpoint) matches(other checkpointManifest) error {
+	if ic.manifest.SchemaVersion != other.SchemaVersion {
+		return fmt.Errorf("checkpoint has been created by a different version of scollex")
+	}
+	if ic.manifest.SourceID != other.SourceID {
+		return fmt.Errorf("checkpoint has been created for a different vertical file")
+	}
+	if ic.manifest.CoOccSpan != other.CoOccSpan || ic.manifest.SinglePass != other.SinglePass {
+		return fmt.Errorf("checkpoint has been created with different import options")
+	}
+	if strings.Join(ic.manifest.Features, "|") != strings.Join(other.Features, "|") {
+		return fmt.Errorf("checkpoint has been created with different captured features")
+	}
+	if ic.manifest.ConfHash != other.ConfHash {
+		return fmt.Errorf("checkpoint has been created with a different corpus configuration")
+	}
+	return nil
+}
+
+// importConfHash creates a checksum of the syntax configuration
+// and of the import options which affect checkpointed records
+// or the state of staging tables. Any change in relations, attributes,
+// normalization etc. makes stored records incompatible. Lemma variants
+// are included by their contents as the file may change in place.
+func importConfHash(conf *SyntaxProps, opts ImportOptions) (string, error) {
+	data, err := json.Marshal(struct {
+		Syntax     *SyntaxProps      `json:"syntax"`
+		Variants   map[string]string `json:"variants"`
+		CoOccSpan  int               `json:"coOccSpan"`
+		SinglePass bool              `json:"singlePass"`
+		BulkLoad   bool              `json:"bulkLoad"`
+	}{
+		Syntax:     conf,
+		Variants:   conf.Normalization.variants,
+		CoOccSpan:  opts.CoOccSpan,
+		SinglePass: opts.SinglePass,
+		BulkLoad:   opts.BulkLoad,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate configuration checksum: %w", err)
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// openImportCheckpoint prepares a checkpoint of a full import. In case
+// opts.Resume is true, an existing checkpoint is loaded (if found).
+// Otherwise, any existing checkpoint data are removed.
+func openImportCheckpoint(
+	corpusID, sourceID string,
+	conf *SyntaxProps,
+	opts ImportOptions,
+) (*importCheckpoint, error) {
+	dir := opts.CheckpointDir
+	if dir == "" {
+		dir = opts.SpillDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		dir = filepath.Join(dir, "scollex-checkpoint-"+corpusID)
+	}
+	confHash, err := importConfHash(conf, opts)
+	if err != nil {
+		return nil, err
+	}
+	ans := &importCheckpoint{
+		dir: dir,
+		manifest: checkpointManifest{
+			CorpusID:      corpusID,
+			SourceID:      sourceID,
+			CoOccSpan:     opts.CoOccSpan,
+			SinglePass:    opts.SinglePass,
+			StartedAt:     time.Now(),
+			SchemaVersion: CurrentSchemaVersion,
+			Features:      conf.Features,
+			ConfHash:      confHash,
+			WrittenRows:   make(map[string]int64),
+		},
+	}
+	if opts.Resume {
+		data, err := os.ReadFile(filepath.Join(dir, checkpointManifestFile))
+		if errors.Is(err, os.ErrNotExist) {
+			log.Warn().Str("dir", dir).Msg("no import checkpoint found, starting from scratch")
+
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to load import checkpoint: %w", err)
+
+		} else {
+			var stored checkpointManifest
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return nil, fmt.Errorf("failed to load import checkpoint: %w", err)
+			}
+			if err := ans.matches(stored); err != nil {
+				return nil, fmt.Errorf("cannot resume import from %s: %w", dir, err)
+			}
+			if stored.WrittenRows == nil {
+				stored.WrittenRows = make(map[string]int64)
+			}
+			ans.manifest = stored
+			log.Info().
+				Str("dir", dir).
+				Bool("pairsDone", stored.PairsDone).
+				Bool("coOccsDone", stored.CoOccsDone).
+				Bool("writingStarted", stored.WritingStarted).
+				Msg("resuming import from checkpoint")
+			return ans, nil
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove old import checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create import checkpoint: %w", err)
+	}
+	return ans, ans.save()
+}
+
+// ------------------------------
+
+// chunkCommitter executes each bulk insert as a separate (autocommitted)
+// statement and records written rows in the checkpoint so an interrupted
+// import can continue without duplicating rows
+type chunkCommitter struct {
+	db         *sql.DB
+	checkpoint *importCheckpoint
+	tableName  string
+}
+
+func (cc *chunkCommitter) Exec(query string, args ...any) (sql.Result, error) {
+	res, err := cc.db.Exec(query, args...)
+	if err != nil {
+		return res, err
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return res, err
+	}
+	cc.checkpoint.manifest.WrittenRows[cc.tableName] += numRows
+	return res, cc.checkpoint.save()
+}
+
+// Rollback does nothing as all the executed
+// statements are already committed
+func (cc *chunkCommitter) Rollback() error {
+	return nil
+}
+
+// skipIterator skips a number of initial records
+// of a wrapped iterator
+type skipIterator struct {
+	records recordIterator
+	numSkip int64
+}
+
+func (si *skipIterator) Next() (*spillRecord, error) {
+	for ; si.numSkip > 0; si.numSkip-- {
+		rec, err := si.records.Next()
+		if err != nil || rec == nil {
+			return rec, err
+		}
+	}
+	return si.records.Next()
+}
+
+func (si *skipIterator) Close() error {
+	return si.records.Close()
+}
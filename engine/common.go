@@ -29,6 +29,13 @@ func (w Word) IsValid() bool {
 	return w.V != ""
 }
 
+// PolarityFreqs is a breakdown of a collocation's frequency
+// by polarity of the verb (see PolarityProps)
+type PolarityFreqs struct {
+	Neg int64 `json:"neg"`
+	Pos int64 `json:"pos"`
+}
+
 type FreqDistribItem struct {
 	Word       string   `json:"word"`
 	Freq       int64    `json:"freq"`
@@ -36,6 +43,16 @@ type FreqDistribItem struct {
 	IPM        float32  `json:"ipm"`
 	CollWeight *float64 `json:"collWeight"`
 	CoOccScore *float64 `json:"coOccScore"`
+
+	// Polarity is provided only for pairs with verbs
+	// in case polarity of verbs is detected
+	Polarity *PolarityFreqs `json:"polarity,omitempty"`
+
+	// ExamplesQuery provides a (CQL) query for obtaining examples
+	// of the word in case a query template (`examplesQueryTpl`)
+	// cannot match it (e.g. a multiword expression spanning
+	// multiple tokens)
+	ExamplesQuery string `json:"examplesQuery,omitempty"`
 }
 
 type FreqDistribItemList []*FreqDistribItem
@@ -53,12 +70,98 @@ type FreqDistrib struct {
 	// (even if we work with a subcorpus)
 	CorpusSize int64 `json:"corpusSize"`
 
+	// MatchedLemma is the normalized lemma actually searched
+	// for (see SyntaxProps.Normalization)
+	MatchedLemma string `json:"matchedLemma"`
+
 	Freqs FreqDistribItemList `json:"freqs"`
 
 	// ExamplesQueryTpl provides a (CQL) query template
 	// for obtaining examples matching words from the `Freqs`
-	// atribute (one by one).
+	// atribute (one by one). All the `%s` placeholders
+	// stand for the word.
 	ExamplesQueryTpl string `json:"examplesQueryTpl"`
 
 	Error string `json:"error"`
 }
+
+// MarkerFreqDistrib is a frequency distribution of collocations
+// sharing a marker (e.g. `nmod` via the preposition `with`)
+type MarkerFreqDistrib struct {
+	Marker string `json:"marker"`
+
+	// Freq is a total frequency of the marked pairs
+	Freq int64 `json:"freq"`
+
+	Freqs FreqDistribItemList `json:"freqs"`
+
+	// ExamplesQueryTpl provides a (CQL) query template
+	// for obtaining examples matching words from the `Freqs`
+	// atribute (one by one) along with the marker. All the
+	// `%s` placeholders stand for the word.
+	ExamplesQueryTpl string `json:"examplesQueryTpl"`
+}
+
+// MarkerFreqDistribs contains frequency distributions
+// of collocations grouped by their markers
+type MarkerFreqDistribs struct {
+
+	// CorpusSize is always equal to the whole corpus size
+	// (even if we work with a subcorpus)
+	CorpusSize int64 `json:"corpusSize"`
+
+	// MatchedLemma is the normalized lemma actually searched
+	// for (see SyntaxProps.Normalization)
+	MatchedLemma string `json:"matchedLemma"`
+
+	Markers []*MarkerFreqDistrib `json:"markers"`
+
+	Error string `json:"error"`
+}
+
+// TripleItem is a head-centered triple (see TriplePattern)
+type TripleItem struct {
+	Pattern string `json:"pattern"`
+
+	// Lemma and Upos describe the head
+	Lemma string `json:"lemma"`
+	Upos  string `json:"upos"`
+
+	// Lemma1 and Upos1 describe the first dependent
+	Lemma1 string `json:"lemma1"`
+	Upos1  string `json:"upos1"`
+
+	// Lemma2 and Upos2 describe the second dependent
+	Lemma2 string `json:"lemma2"`
+	Upos2  string `json:"upos2"`
+
+	// Marker is a function word (typically a preposition)
+	// attached to the second dependent
+	Marker string  `json:"marker"`
+	Freq   int64   `json:"freq"`
+	IPM    float32 `json:"ipm"`
+
+	// Score is a generalized logDice of the triple
+	// (nil if it cannot be calculated)
+	Score *float64 `json:"score"`
+
+	// ExamplesQuery provides a (CQL) query for obtaining
+	// examples of the triple
+	ExamplesQuery string `json:"examplesQuery"`
+}
+
+// TripleList contains triples of a headword
+type TripleList struct {
+
+	// CorpusSize is always equal to the whole corpus size
+	// (even if we work with a subcorpus)
+	CorpusSize int64 `json:"corpusSize"`
+
+	// MatchedLemma is the normalized lemma actually searched
+	// for (see SyntaxProps.Normalization)
+	MatchedLemma string `json:"matchedLemma"`
+
+	Triples []*TripleItem `json:"triples"`
+
+	Error string `json:"error"`
+}
@@ -16,7 +16,21 @@
 
 package engine
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltSentenceStruct   = "s"
+	dfltDocStruct        = "doc"
+	dfltCaseValue        = "case"
+	dfltAdjModifierValue = "amod"
+	dfltCcValue          = "cc"
+	dfltMultiwordValue   = "flat|fixed|compound"
+)
 
 type DBConf struct {
 	Host     string `json:"host"`
@@ -45,16 +59,41 @@ func (cp CorporaConf) GetCorpusProps(corpusID string) *CorpusProps {
 
 type CorpusProps struct {
 	Name string `json:"name"`
-	Size int64  `json:"size"`
+
+	// Size is a number of tokens of the corpus. It is used only
+	// in case the size is not stored in the database (i.e. data
+	// imported by an older version of scollex).
+	Size int64 `json:"size"`
+
 	// HasMaterializedViews if true then scollex will use queries
 	// targeting those views for the corpus to provide better performance.
 	// This is highly recommended (see scripts/schema.sql for
 	// the views' definitions)
-	HasMaterializedViews bool        `json:"hasMaterializedViews"`
-	Syntax               SyntaxProps `json:"syntax"`
+	HasMaterializedViews bool `json:"hasMaterializedViews"`
+
+	// RegistryPath is an optional path of the corpus' Manatee
+	// registry file. If set, vertical columns of syntax attributes
+	// are derived from the order of positional attributes in
+	// the registry (so `verticalCol` can be omitted).
+	RegistryPath string `json:"registryPath"`
+
+	Syntax SyntaxProps `json:"syntax"`
 }
 
 func (conf *CorpusProps) ValidateAndDefaults(confContext string) error {
+	// columns from the registry are validated along with
+	// the other syntax properties
+	if conf.RegistryPath != "" {
+		err := conf.Syntax.applyRegistry(
+			conf.RegistryPath, fmt.Sprintf("%s.%s.syntax", confContext, conf.Name))
+		if err != nil {
+			return err
+		}
+		log.Info().
+			Str("corpusId", conf.Name).
+			Str("registry", conf.RegistryPath).
+			Msg("vertical columns loaded from registry file")
+	}
 	return conf.Syntax.ValidateAndDefaults(confContext)
 }
 
@@ -97,6 +136,185 @@ type SyntaxProps struct {
 
 	// (in intercorp_v13ud: `obj|iobj`)
 	NounObjectValue string `json:"nounObjectValue"`
+
+	// CollapseSubtypes if true then relation subtypes (e.g. `nmod:poss`,
+	// `nsubj:pass`) are counted as their base relations. Otherwise,
+	// subtypes are stored so they can be queried either exactly
+	// or through their base relation.
+	CollapseSubtypes bool `json:"collapseSubtypes"`
+
+	// CaseValue is a relation of function words (typically
+	// prepositions) attached to nouns. Lemmas of such words are
+	// stored along with collocations of the nouns as their markers
+	// (default: `case`)
+	CaseValue string `json:"caseValue"`
+
+	// ObliqueValue is a relation of oblique arguments of verbs
+	// (typically prepositional ones, e.g. `depend on`). Such pairs
+	// are counted along with their markers and also used by triples
+	// (see TriplePatterns). If empty, oblique arguments are not counted.
+	ObliqueValue string `json:"obliqueValue"`
+
+	// AdjModifierValue is a relation of adjectival modifiers of nouns.
+	// It is used by triples (see TriplePatterns). (default: `amod`)
+	AdjModifierValue string `json:"adjModifierValue"`
+
+	// ConjValue is a relation of coordinated words. Such pairs
+	// of words of the same PoS are counted in both word orders.
+	// If empty, coordinations are not counted.
+	ConjValue string `json:"conjValue"`
+
+	// CcValue is a relation of coordinating conjunctions. Their
+	// lemmas are stored as markers of coordinated pairs
+	// (default: `cc`)
+	CcValue string `json:"ccValue"`
+
+	// MergeMultiwords if true then multiword expressions (e.g. `New
+	// York`, `because of`) are merged into single composite lemmas
+	// (words joined by a space) before syntactic pairs and triples
+	// are counted. Token frequencies and co-occurrences are still
+	// counted for single tokens so pairs and triples involving
+	// the composite lemmas have no co-occurrence scores.
+	MergeMultiwords bool `json:"mergeMultiwords"`
+
+	// MultiwordValue specifies relations of multiword expressions
+	// merged in case MergeMultiwords is enabled
+	// (default: `flat|fixed|compound`)
+	MultiwordValue string `json:"multiwordValue"`
+
+	// DeepRelations if true then arguments of verbs are also counted
+	// by their logical (deep) relations (see DeepObjectValue and
+	// DeepSubjectValue) so e.g. `the decision was made` and `make
+	// a decision` form the same verb-object collocation. The surface
+	// relations are counted as usual.
+	DeepRelations bool `json:"deepRelations"`
+
+	// PassiveSubjectValue is a relation of subjects of passive verbs
+	// counted as deep objects (default: `nsubj:pass`)
+	PassiveSubjectValue string `json:"passiveSubjectValue"`
+
+	// AgentValue is a relation of agents of passive verbs
+	// counted as deep subjects (default: `obl:agent`)
+	AgentValue string `json:"agentValue"`
+
+	// PassiveFeature is a UD feature of passive verbs (default:
+	// `Voice=Pass`). Subjects of verbs having the feature are counted
+	// as deep objects even if their relation is not PassiveSubjectValue.
+	// The feature is searched only in case `featsAttr` is configured.
+	PassiveFeature string `json:"passiveFeature"`
+
+	// SentenceStruct is a structure representing sentences
+	// (default: `s`)
+	SentenceStruct string `json:"sentenceStruct"`
+
+	// DocStruct is a structure representing documents
+	// (default: `doc`)
+	DocStruct string `json:"docStruct"`
+
+	// FeatsAttr - an optional attribute specifying morphological
+	// features in the UD format (in intercorp_v13ud: `feats`)
+	FeatsAttr PosAttrProps `json:"featsAttr"`
+
+	// ParFeatsAttr - an optional attribute specifying morphological
+	// features of parent (in intercorp_v13ud: `p_feats`)
+	ParFeatsAttr PosAttrProps `json:"parFeatsAttr"`
+
+	// Features lists UD features (e.g. `Case`, `Number`, `Aspect`,
+	// `VerbForm`) captured as additional dimensions of collocations
+	// so they can be used as query filters. Features of parents are
+	// captured only in case ParFeatsAttr is configured.
+	Features []string `json:"features"`
+
+	// Normalization configures normalization of lemmas applied
+	// both on import and on query time
+	Normalization NormalizationProps `json:"normalization"`
+
+	// Polarity configures detection of negated verbs
+	// stored along with their pairs
+	Polarity PolarityProps `json:"polarity"`
+}
+
+// HasFeature tests whether a UD feature is captured
+// for the corpus' collocations
+func (conf *SyntaxProps) HasFeature(name string) bool {
+	return collections.SliceContains(conf.Features, name)
+}
+
+// keyedAttr is a positional attribute along
+// with its configuration key
+type keyedAttr struct {
+	key  string
+	attr *PosAttrProps
+}
+
+// optionalAttrs returns configured optional attributes
+func (conf *SyntaxProps) optionalAttrs() []keyedAttr {
+	ans := make([]keyedAttr, 0, 2)
+	if conf.FeatsAttr.Name != "" {
+		ans = append(ans, keyedAttr{"featsAttr", &conf.FeatsAttr})
+	}
+	if conf.ParFeatsAttr.Name != "" {
+		ans = append(ans, keyedAttr{"parFeatsAttr", &conf.ParFeatsAttr})
+	}
+	return ans
+}
+
+// columnAttrs returns all the configured positional attributes
+func (conf *SyntaxProps) columnAttrs() []keyedAttr {
+	ans := []keyedAttr{
+		{"parentIdxAttr", &conf.ParentIdxAttr},
+		{"lemmaAttr", &conf.LemmaAttr},
+		{"parLemmaAttr", &conf.ParLemmaAttr},
+		{"posAttr", &conf.PosAttr},
+		{"parPosAttr", &conf.ParPosAttr},
+		{"funcAttr", &conf.FuncAttr},
+	}
+	return append(ans, conf.optionalAttrs()...)
+}
+
+// validateColumns tests whether all the configured attributes
+// have their vertical columns (either configured explicitly
+// or derived from a registry file) and whether each column
+// is used by a single attribute only
+func (conf *SyntaxProps) validateColumns(confContext string) error {
+	usedBy := make(map[int]string)
+	for _, item := range conf.columnAttrs() {
+		if item.attr.VerticalCol <= 0 {
+			return fmt.Errorf(
+				"missing or invalid `%s.%s.verticalCol` (the first column is 1, "+
+					"`word` cannot be used)",
+				confContext, item.key)
+		}
+		if other, ok := usedBy[item.attr.VerticalCol]; ok {
+			return fmt.Errorf(
+				"`%s.%s`: vertical column %d already used by `%s`",
+				confContext, item.key, item.attr.VerticalCol, other)
+		}
+		usedBy[item.attr.VerticalCol] = item.key
+	}
+	return nil
+}
+
+// RequiredNumColumns returns a minimum number of token columns
+// (not counting the first `word` column) a vertical file must
+// provide to contain all the configured attributes
+func (conf *SyntaxProps) RequiredNumColumns() int {
+	ans := 0
+	for _, attr := range []PosAttrProps{
+		conf.ParentIdxAttr,
+		conf.LemmaAttr,
+		conf.ParLemmaAttr,
+		conf.PosAttr,
+		conf.ParPosAttr,
+		conf.FuncAttr,
+		conf.FeatsAttr,
+		conf.ParFeatsAttr,
+	} {
+		if attr.VerticalCol > ans {
+			ans = attr.VerticalCol
+		}
+	}
+	return ans
 }
 
 func (conf *SyntaxProps) ValidateAndDefaults(confContext string) error {
@@ -133,5 +351,40 @@ func (conf *SyntaxProps) ValidateAndDefaults(confContext string) error {
 	if conf.NounObjectValue == "" {
 		return fmt.Errorf("missing `%s.nounObjectValue`", confContext)
 	}
-	return nil
+	if conf.SentenceStruct == "" {
+		conf.SentenceStruct = dfltSentenceStruct
+	}
+	if conf.DocStruct == "" {
+		conf.DocStruct = dfltDocStruct
+	}
+	if conf.CaseValue == "" {
+		conf.CaseValue = dfltCaseValue
+	}
+	if conf.AdjModifierValue == "" {
+		conf.AdjModifierValue = dfltAdjModifierValue
+	}
+	if conf.CcValue == "" {
+		conf.CcValue = dfltCcValue
+	}
+	if conf.MultiwordValue == "" {
+		conf.MultiwordValue = dfltMultiwordValue
+	}
+	if len(conf.Features) > 0 && conf.FeatsAttr.Name == "" {
+		return fmt.Errorf("`%s.features` require `%s.featsAttr`", confContext, confContext)
+	}
+	for _, feat := range conf.Features {
+		if !featNameRegexp.MatchString(feat) {
+			return fmt.Errorf("invalid feature name `%s` in `%s.features`", feat, confContext)
+		}
+	}
+	if err := conf.validateColumns(confContext); err != nil {
+		return err
+	}
+	if err := conf.validateDeepRelations(confContext); err != nil {
+		return err
+	}
+	if err := conf.Polarity.validateAndDefaults(confContext, conf.FeatsAttr); err != nil {
+		return err
+	}
+	return conf.Normalization.loadVariants(confContext)
 }
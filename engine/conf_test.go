@@ -0,0 +1,122 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testColumnsConf() SyntaxProps {
+	return SyntaxProps{
+		ParentIdxAttr:     PosAttrProps{Name: "parent", VerticalCol: 10},
+		LemmaAttr:         PosAttrProps{Name: "lemma", VerticalCol: 3},
+		ParLemmaAttr:      PosAttrProps{Name: "p_lemma", VerticalCol: 11},
+		PosAttr:           PosAttrProps{Name: "upos", VerticalCol: 4},
+		ParPosAttr:        PosAttrProps{Name: "p_upos", VerticalCol: 12},
+		FuncAttr:          PosAttrProps{Name: "deprel", VerticalCol: 9},
+		NounValue:         "NOUN",
+		VerbValue:         "VERB",
+		NounModifiedValue: "nmod",
+		NounSubjectValue:  "nsubj",
+		NounObjectValue:   "obj|iobj",
+	}
+}
+
+// TestValidateColumns tests that vertical columns are required
+// and distinct both with and without a registry file
+func TestValidateColumns(t *testing.T) {
+	registryPath := filepath.Join(t.TempDir(), "registry")
+	err := os.WriteFile(
+		registryPath,
+		[]byte("ATTRIBUTE word\nATTRIBUTE lemma\nATTRIBUTE upos\nATTRIBUTE deprel\n"+
+			"ATTRIBUTE parent\nATTRIBUTE p_lemma\nATTRIBUTE p_upos\n"),
+		0644,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		registry string
+		modify   func(conf *SyntaxProps)
+		errPart  string
+	}{
+		{
+			name:   "valid",
+			modify: func(conf *SyntaxProps) {},
+		},
+		{
+			name:    "missing column",
+			modify:  func(conf *SyntaxProps) { conf.ParLemmaAttr.VerticalCol = 0 },
+			errPart: "`test.parLemmaAttr.verticalCol`",
+		},
+		{
+			name:    "negative column",
+			modify:  func(conf *SyntaxProps) { conf.FuncAttr.VerticalCol = -1 },
+			errPart: "`test.funcAttr.verticalCol`",
+		},
+		{
+			name: "duplicate column",
+			modify: func(conf *SyntaxProps) {
+				conf.FeatsAttr = PosAttrProps{Name: "feats", VerticalCol: 4}
+			},
+			errPart: "vertical column 4 already used by `posAttr`",
+		},
+		{
+			name:     "columns from registry",
+			registry: registryPath,
+			modify: func(conf *SyntaxProps) {
+				for _, item := range conf.columnAttrs() {
+					item.attr.VerticalCol = 0
+				}
+			},
+		},
+		{
+			name:     "missing name with registry",
+			registry: registryPath,
+			modify: func(conf *SyntaxProps) {
+				for _, item := range conf.columnAttrs() {
+					item.attr.VerticalCol = 0
+				}
+				conf.ParPosAttr = PosAttrProps{}
+			},
+			errPart: "missing `test.c.syntax.parPosAttr`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := CorpusProps{Name: "c", RegistryPath: tt.registry, Syntax: testColumnsConf()}
+			tt.modify(&conf.Syntax)
+			err := conf.ValidateAndDefaults("test")
+			if tt.errPart == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.errPart)
+			}
+			if !strings.Contains(err.Error(), tt.errPart) {
+				t.Errorf("expected error containing %q, found %q", tt.errPart, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+)
+
+const (
+	// DeepObjectValue is a relation of logical objects of verbs,
+	// i.e. objects and subjects of passive verbs (see
+	// SyntaxProps.DeepRelations)
+	DeepObjectValue = "deepobj"
+
+	// DeepSubjectValue is a relation of logical subjects of verbs,
+	// i.e. subjects of active verbs and agents of passive verbs
+	// (see SyntaxProps.DeepRelations)
+	DeepSubjectValue = "deepsubj"
+
+	dfltPassiveSubjectValue = "nsubj:pass"
+	dfltAgentValue          = "obl:agent"
+	dfltPassiveFeature      = "Voice=Pass"
+)
+
+// validateDeepRelations validates and sets defaults
+// of props related to deep relations
+func (conf *SyntaxProps) validateDeepRelations(confContext string) error {
+	if conf.PassiveSubjectValue == "" {
+		conf.PassiveSubjectValue = dfltPassiveSubjectValue
+	}
+	if conf.AgentValue == "" {
+		conf.AgentValue = dfltAgentValue
+	}
+	if conf.PassiveFeature == "" {
+		conf.PassiveFeature = dfltPassiveFeature
+	}
+	if _, err := ParseFeatFilter(conf.PassiveFeature); err != nil {
+		return fmt.Errorf("invalid `%s.passiveFeature`: %w", confContext, err)
+	}
+	return nil
+}
+
+// deepRelationMatcher resolves deep relations (see DeepObjectValue,
+// DeepSubjectValue) of verb arguments from their surface relations
+type deepRelationMatcher struct {
+	objects         relationMatcher
+	subjects        relationMatcher
+	passiveSubjects relationMatcher
+	agents          relationMatcher
+}
+
+// match returns a deep relation of a verb argument attached by
+// a (possibly multi-value) deprel or an empty string if the argument
+// is neither a logical object nor a logical subject. The `passive`
+// argument tells whether the verb's features mark passive voice.
+func (dm deepRelationMatcher) match(deprel string, passive bool) string {
+	switch {
+	case len(dm.agents.match(deprel)) > 0:
+		return DeepSubjectValue
+	case len(dm.passiveSubjects.match(deprel)) > 0:
+		return DeepObjectValue
+	case len(dm.subjects.match(deprel)) > 0:
+		if passive {
+			return DeepObjectValue
+		}
+		return DeepSubjectValue
+	case len(dm.objects.match(deprel)) > 0:
+		return DeepObjectValue
+	}
+	return ""
+}
+
+func newDeepRelationMatcher(conf *SyntaxProps) deepRelationMatcher {
+	return deepRelationMatcher{
+		objects:         newRelationMatcher([]string{conf.NounObjectValue}, false),
+		subjects:        newRelationMatcher([]string{conf.NounSubjectValue}, false),
+		passiveSubjects: newRelationMatcher([]string{conf.PassiveSubjectValue}, false),
+		agents:          newRelationMatcher([]string{conf.AgentValue}, false),
+	}
+}
+
+// deepRelationCache matches deprels of tokens (IDs of interned
+// strings) and caches IDs of the respective deep relations
+type deepRelationCache struct {
+	matcher deepRelationMatcher
+	pool    *stringPool
+	verb    uint32
+
+	// cache contains deep relations of active (index 0)
+	// and passive (index 1) verbs
+	cache map[uint32][2]uint32
+}
+
+// relation returns an ID of a deep relation of a verb argument
+// (emptyStringID if there is no deep relation)
+func (dc *deepRelationCache) relation(deprel uint32, passive bool) uint32 {
+	ans, ok := dc.cache[deprel]
+	if !ok {
+		value := dc.pool.Value(deprel)
+		ans[0] = dc.pool.ID(dc.matcher.match(value, false))
+		ans[1] = dc.pool.ID(dc.matcher.match(value, true))
+		dc.cache[deprel] = ans
+	}
+	if passive {
+		return ans[1]
+	}
+	return ans[0]
+}
+
+// tokenRelation returns an ID of a deep relation of a sentence token
+// (emptyStringID if the token is not an argument of a verb)
+func (dc *deepRelationCache) tokenRelation(tokens []sentToken, i int) uint32 {
+	tk := tokens[i]
+	if tk.parent < 0 || tk.pUpos != dc.verb {
+		return emptyStringID
+	}
+	return dc.relation(tk.deprel, tokens[tk.parent].passive)
+}
+
+func newDeepRelationCache(conf *SyntaxProps, pool *stringPool) *deepRelationCache {
+	return &deepRelationCache{
+		matcher: newDeepRelationMatcher(conf),
+		pool:    pool,
+		verb:    pool.ID(conf.VerbValue),
+		cache:   make(map[uint32][2]uint32),
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	subtypeRegexp = regexp.MustCompile(`^[a-z]+$`)
+)
+
+// splitDeprel splits a UD relation into its base
+// relation and subtype (e.g. `nmod:poss` => `nmod`, `poss`)
+func splitDeprel(deprel string) (string, string) {
+	base, subtype, _ := strings.Cut(deprel, ":")
+	return base, subtype
+}
+
+// relationMatcher selects relations of tokens collocations
+// are counted for
+type relationMatcher struct {
+
+	// relations contains configured relations; relations without
+	// a subtype match also all their subtypes
+	relations map[string]bool
+
+	// collapseSubtypes if true then matching relations
+	// are reported as their base relations
+	collapseSubtypes bool
+}
+
+// match returns relations of a (possibly multi-value, e.g. `obj|iobj`)
+// deprel collocations should be counted for
+func (rm relationMatcher) match(deprel string) []string {
+	var ans []string
+	for _, rel := range strings.Split(deprel, "|") {
+		base, _ := splitDeprel(rel)
+		if !rm.relations[rel] && !rm.relations[base] {
+			continue
+		}
+		if rm.collapseSubtypes {
+			rel = base
+		}
+		ans = append(ans, rel)
+	}
+	return ans
+}
+
+// newRelationMatcher creates a matcher of relations specified
+// by deprel expressions (e.g. `nmod`, `obj|iobj`)
+func newRelationMatcher(exprs []string, collapseSubtypes bool) relationMatcher {
+	ans := relationMatcher{
+		relations:        make(map[string]bool),
+		collapseSubtypes: collapseSubtypes,
+	}
+	for _, expr := range exprs {
+		if expr == "" {
+			// not configured
+			continue
+		}
+		for _, rel := range strings.Split(expr, "|") {
+			ans.relations[rel] = true
+		}
+	}
+	return ans
+}
+
+// relationCache matches deprels of tokens (IDs of interned strings)
+// and caches IDs of the matching relations
+type relationCache struct {
+	matcher relationMatcher
+	pool    *stringPool
+	cache   map[uint32][]uint32
+}
+
+// relations returns IDs of relations a token with
+// the deprel (an ID) should be counted for
+func (rc *relationCache) relations(deprel uint32) []uint32 {
+	if ans, ok := rc.cache[deprel]; ok {
+		return ans
+	}
+	var ans []uint32
+	for _, rel := range rc.matcher.match(rc.pool.Value(deprel)) {
+		ans = append(ans, rc.pool.ID(rel))
+	}
+	rc.cache[deprel] = ans
+	return ans
+}
+
+func newRelationCache(matcher relationMatcher, pool *stringPool) *relationCache {
+	return &relationCache{
+		matcher: matcher,
+		pool:    pool,
+		cache:   make(map[uint32][]uint32),
+	}
+}
+
+// ValidateSubtype tests whether a relation subtype (e.g. `poss`)
+// can be used in queries of a corpus
+func ValidateSubtype(subtype string, conf *SyntaxProps) error {
+	if !subtypeRegexp.MatchString(subtype) {
+		return fmt.Errorf("invalid relation subtype `%s`", subtype)
+	}
+	if conf.CollapseSubtypes {
+		return fmt.Errorf("relation subtypes are not available (collapsed into base relations)")
+	}
+	return nil
+}
+
+// DeprelWithSubtype restricts all the relations of a deprel
+// expression to a subtype (e.g. `obj|iobj` => `obj:x|iobj:x`).
+// An empty subtype leaves the expression as it is.
+func DeprelWithSubtype(expr, subtype string) string {
+	if subtype == "" {
+		return expr
+	}
+	rels := strings.Split(expr, "|")
+	for i, rel := range rels {
+		base, _ := splitDeprel(rel)
+		rels[i] = base + ":" + subtype
+	}
+	return strings.Join(rels, "|")
+}
+
+// deprelSQL creates an SQL condition (along with its arguments)
+// matching any of the relations of a deprel expression. Relations
+// without a subtype match also all their subtypes (e.g. `nmod`
+// matches `nmod:poss`) so subtypes can be queried either exactly
+// or through their base relation.
+func deprelSQL(expr string) (string, []any) {
+	rels := strings.Split(expr, "|")
+	conds := make([]string, 0, 2*len(rels))
+	args := make([]any, 0, 2*len(rels))
+	for _, rel := range rels {
+		conds = append(conds, "deprel = ?")
+		args = append(args, rel)
+		if !strings.Contains(rel, ":") {
+			conds = append(conds, "deprel LIKE ?")
+			args = append(args, rel+":%")
+		}
+	}
+	return fmt.Sprintf("(%s)", strings.Join(conds, " OR ")), args
+}
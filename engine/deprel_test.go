@@ -0,0 +1,71 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"testing"
+)
+
+// TestUnconfiguredObliqueValue tests that oblique arguments
+// are neither counted nor used by triples unless configured
+func TestUnconfiguredObliqueValue(t *testing.T) {
+	conf := testColumnsConf()
+	if err := conf.ValidateAndDefaults("test"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.ObliqueValue != "" {
+		t.Errorf("expected no default oblique value, found %q", conf.ObliqueValue)
+	}
+	matcher := newRelationMatcher(
+		[]string{conf.NounSubjectValue, conf.ObliqueValue}, conf.CollapseSubtypes)
+	for _, deprel := range []string{"", "obl"} {
+		if rels := matcher.match(deprel); len(rels) > 0 {
+			t.Errorf("expected deprel %q not to be counted, found %v", deprel, rels)
+		}
+	}
+	if _, ok := conf.TriplePattern(TriplePatternVerbObjObl); ok {
+		t.Errorf("expected no %s triples", TriplePatternVerbObjObl)
+	}
+
+	conf.ObliqueValue = "obl"
+	matcher = newRelationMatcher(
+		[]string{conf.NounSubjectValue, conf.ObliqueValue}, conf.CollapseSubtypes)
+	if rels := matcher.match("obl:tmod"); len(rels) != 1 {
+		t.Errorf("expected deprel obl:tmod to be counted, found %v", rels)
+	}
+	if _, ok := conf.TriplePattern(TriplePatternVerbObjObl); !ok {
+		t.Errorf("expected %s triples", TriplePatternVerbObjObl)
+	}
+}
+
+// TestUnconfiguredConjValue tests that coordinations
+// are not counted unless configured
+func TestUnconfiguredConjValue(t *testing.T) {
+	conf := testColumnsConf()
+	if err := conf.ValidateAndDefaults("test"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.ConjValue != "" {
+		t.Errorf("expected no default conj value, found %q", conf.ConjValue)
+	}
+	matcher := newRelationMatcher([]string{conf.ConjValue}, conf.CollapseSubtypes)
+	for _, deprel := range []string{"", "conj"} {
+		if rels := matcher.match(deprel); len(rels) > 0 {
+			t.Errorf("expected deprel %q not to be counted, found %v", deprel, rels)
+		}
+	}
+}
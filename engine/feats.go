@@ -0,0 +1,92 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/czcorpus/cnc-gokit/collections"
+)
+
+const (
+	// featsColumnSize is a size of database columns
+	// storing selected morphological features
+	featsColumnSize = 100
+)
+
+var (
+	featNameRegexp   = regexp.MustCompile(`^[A-Z][A-Za-z0-9\[\]]*$`)
+	featFilterRegexp = regexp.MustCompile(`^([A-Z][A-Za-z0-9\[\]]*)=([A-Za-z0-9,]+)$`)
+)
+
+// selectFeats extracts configured features from a UD FEATS value
+// (e.g. `Case=Gen|Gender=Fem|Number=Sing`). The original order
+// of the features is preserved so equal selections always produce
+// the same string. An empty string is returned if none of the features
+// is present.
+func selectFeats(value string, features []string) string {
+	if len(features) == 0 || value == "" || value == "_" {
+		return ""
+	}
+	var ans strings.Builder
+	for _, feat := range strings.Split(value, "|") {
+		name, _, _ := strings.Cut(feat, "=")
+		if collections.SliceContains(features, name) {
+			if ans.Len() > 0 {
+				ans.WriteString("|")
+			}
+			ans.WriteString(feat)
+		}
+	}
+	return ans.String()
+}
+
+// hasFeat tests whether a UD FEATS value contains
+// a feature (e.g. `Polarity=Neg`)
+func hasFeat(value, feat string) bool {
+	for _, item := range strings.Split(value, "|") {
+		if item == feat {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFeatFilter validates a feature filter (e.g. `Case=Gen`)
+// and returns the name of the feature
+func ParseFeatFilter(value string) (string, error) {
+	srch := featFilterRegexp.FindStringSubmatch(value)
+	if len(srch) == 0 {
+		return "", fmt.Errorf("invalid feature filter `%s`, expected `Name=Value`", value)
+	}
+	return srch[1], nil
+}
+
+// featsSQL creates SQL conditions (along with their arguments)
+// matching rows whose `column` contains all the provided features.
+// The filters must be validated by ParseFeatFilter.
+func featsSQL(column string, filters []string) ([]string, []any) {
+	conds := make([]string, len(filters))
+	args := make([]any, len(filters))
+	for i, feat := range filters {
+		conds[i] = fmt.Sprintf("CONCAT('|', %s, '|') LIKE ?", column)
+		args[i] = "%|" + feat + "|%"
+	}
+	return conds, args
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollFilterFeatsSQL(t *testing.T) {
+	filter := CollFilter{
+		ChildFeats:  []string{"Case=Acc", "Number=Sing"},
+		ParentFeats: []string{"Mood=Ind"},
+	}
+	tests := []struct {
+		name          string
+		fn            func() ([]string, []any)
+		expectedConds []string
+		expectedArgs  []any
+	}{
+		{
+			name: "fcolls",
+			fn:   filter.fcollsSQL,
+			expectedConds: []string{
+				"CONCAT('|', feats, '|') LIKE ?",
+				"CONCAT('|', feats, '|') LIKE ?",
+				"CONCAT('|', p_feats, '|') LIKE ?",
+			},
+			expectedArgs: []any{"%|Case=Acc|%", "%|Number=Sing|%", "%|Mood=Ind|%"},
+		},
+		{
+			name: "childSums",
+			fn:   filter.childSumsSQL,
+			expectedConds: []string{
+				"CONCAT('|', feats, '|') LIKE ?",
+				"CONCAT('|', feats, '|') LIKE ?",
+			},
+			expectedArgs: []any{"%|Case=Acc|%", "%|Number=Sing|%"},
+		},
+		{
+			name:          "parentSums",
+			fn:            filter.parentSumsSQL,
+			expectedConds: []string{"CONCAT('|', p_feats, '|') LIKE ?"},
+			expectedArgs:  []any{"%|Mood=Ind|%"},
+		},
+	}
+	for _, tt := range tests {
+		conds, args := tt.fn()
+		if !reflect.DeepEqual(conds, tt.expectedConds) {
+			t.Errorf("%s: expected conditions %q, found %q", tt.name, tt.expectedConds, conds)
+		}
+		if !reflect.DeepEqual(args, tt.expectedArgs) {
+			t.Errorf("%s: expected arguments %q, found %q", tt.name, tt.expectedArgs, args)
+		}
+	}
+}
@@ -19,158 +19,587 @@ package engine
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
 const (
 	defaultWordColumnSize = 300
+
+	stagingTableSuffix  = "_staging"
+	prevTableSuffix     = "_prev"
+	rollbackTableSuffix = "_rollback"
 )
 
-func (cdb *CollDatabase) dropCollsTable(tx *sql.Tx) error {
-	_, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s_fcolls`, cdb.corpusID))
-	return err
+// importTables specifies names of tables holding
+// one generation of imported data
+type importTables struct {
+	fcolls     string
+	childSums  string
+	parentSums string
+	tokenFreqs string
+	sources    string
+	imports    string
+	triples    string
+}
+
+func (it importTables) all() []string {
+	return []string{
+		it.fcolls, it.childSums, it.parentSums, it.tokenFreqs, it.sources, it.imports, it.triples}
+}
+
+// importCounts contains numbers of rows written
+// into respective tables
+type importCounts struct {
+	fcolls     int64
+	childSums  int64
+	parentSums int64
+	tokenFreqs int64
+	sources    int64
+	imports    int64
+	triples    int64
+}
+
+func (cdb *CollDatabase) tablesWithSuffix(suffix string) importTables {
+	return importTables{
+		fcolls:     fmt.Sprintf("%s_fcolls%s", cdb.corpusID, suffix),
+		childSums:  fmt.Sprintf("%s_child_sums%s", cdb.corpusID, suffix),
+		parentSums: fmt.Sprintf("%s_parent_sums%s", cdb.corpusID, suffix),
+		tokenFreqs: fmt.Sprintf("%s_token_freqs%s", cdb.corpusID, suffix),
+		sources:    fmt.Sprintf("%s_sources%s", cdb.corpusID, suffix),
+		imports:    fmt.Sprintf("%s_imports%s", cdb.corpusID, suffix),
+		triples:    fmt.Sprintf("%s_triples%s", cdb.corpusID, suffix),
+	}
+}
+
+func (cdb *CollDatabase) liveTables() importTables {
+	return cdb.tablesWithSuffix("")
+}
+
+func (cdb *CollDatabase) stagingTables() importTables {
+	return cdb.tablesWithSuffix(stagingTableSuffix)
 }
 
-func (cdb *CollDatabase) createCollsTable(tx *sql.Tx, vcLen int) error {
-	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s_fcolls (
+func (cdb *CollDatabase) prevTables() importTables {
+	return cdb.tablesWithSuffix(prevTableSuffix)
+}
+
+// tableIndex is a secondary index of an import table
+type tableIndex struct {
+	tableName string
+	column    string
+}
+
+func (ti tableIndex) name() string {
+	return fmt.Sprintf("%s_%s_idx", ti.tableName, ti.column)
+}
+
+// indexes returns secondary indexes of the tables
+func (it importTables) indexes() []tableIndex {
+	return []tableIndex{
+		{tableName: it.fcolls, column: "lemma"},
+		{tableName: it.fcolls, column: "p_lemma"},
+		{tableName: it.parentSums, column: "p_lemma"},
+		{tableName: it.childSums, column: "lemma"},
+		{tableName: it.tokenFreqs, column: "lemma"},
+		{tableName: it.triples, column: "lemma"},
+	}
+}
+
+func (cdb *CollDatabase) createCollsTable(tx *sql.Tx, tableName string, vcLen int) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
 		id int(11) NOT NULL AUTO_INCREMENT,
 		lemma varchar(%d) NOT NULL,
 		upos varchar(50) NOT NULL,
 		p_lemma varchar(%d) NOT NULL,
 		p_upos varchar(50) NOT NULL,
 		deprel varchar(50) NOT NULL,
+		feats varchar(%d) NOT NULL DEFAULT '',
+		p_feats varchar(%d) NOT NULL DEFAULT '',
+		marker varchar(%d) NOT NULL DEFAULT '',
+		polarity varchar(%d) NOT NULL DEFAULT '',
 		freq int(11) NOT NULL,
+		co_occurrence_freq int(11) NOT NULL DEFAULT 0,
 		co_occurrence_score FLOAT,
 		PRIMARY KEY (id)
-	  )`, cdb.corpusID, vcLen, vcLen))
+	  )`, tableName, vcLen, vcLen, featsColumnSize, featsColumnSize, markerColumnSize,
+		polarityColumnSize))
 
 	if err != nil {
-		return fmt.Errorf("failed to CREATE table %s_fcolls: %w", cdb.corpusID, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
+	return nil
+}
 
-	idxName := fmt.Sprintf("%s_fcolls_lemma_idx", cdb.corpusID)
-	_, err = tx.Exec(
-		fmt.Sprintf("CREATE INDEX %s ON %s_fcolls(lemma)", idxName, cdb.corpusID),
-	)
+func (cdb *CollDatabase) createParentSumsTable(tx *sql.Tx, tableName string, vcLen int) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		p_lemma varchar(%d) NOT NULL,
+		p_upos varchar(50) NOT NULL,
+		deprel varchar(50) NOT NULL,
+		p_feats varchar(%d) NOT NULL DEFAULT '',
+		marker varchar(%d) NOT NULL DEFAULT '',
+		polarity varchar(%d) NOT NULL DEFAULT '',
+		freq int(11) NOT NULL,
+		PRIMARY KEY (id)
+	  )`, tableName, vcLen, featsColumnSize, markerColumnSize, polarityColumnSize))
 	if err != nil {
-		return fmt.Errorf("failed to CREATE index %s: %w", idxName, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
+	return nil
+}
 
-	idxName = fmt.Sprintf("%s_fcolls_p_lemma_idx", cdb.corpusID)
-	_, err = tx.Exec(
-		fmt.Sprintf("CREATE INDEX %s ON %s_fcolls(p_lemma)", idxName, cdb.corpusID),
-	)
+func (cdb *CollDatabase) createChildSumsTable(tx *sql.Tx, tableName string, vcLen int) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		lemma varchar(%d) NOT NULL,
+		upos varchar(50) NOT NULL,
+		deprel varchar(50) NOT NULL,
+		feats varchar(%d) NOT NULL DEFAULT '',
+		marker varchar(%d) NOT NULL DEFAULT '',
+		polarity varchar(%d) NOT NULL DEFAULT '',
+		freq int(11) NOT NULL,
+		PRIMARY KEY (id)
+	)`, tableName, vcLen, featsColumnSize, markerColumnSize, polarityColumnSize))
 	if err != nil {
-		return fmt.Errorf("failed to CREATE index %s: %w", idxName, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
-
 	return nil
 }
 
-func (cdb *CollDatabase) dropParentSumsTable(tx *sql.Tx) error {
-	_, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s_parent_sums`, cdb.corpusID))
+func (cdb *CollDatabase) createTokenFreqsTable(tx *sql.Tx, tableName string, vcLen int) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		lemma varchar(%d) NOT NULL,
+		upos varchar(50) NOT NULL,
+		freq int(11) NOT NULL,
+		PRIMARY KEY (id)
+	)`, tableName, vcLen))
 	if err != nil {
-		return fmt.Errorf("failed to DROP table %s_parent_sums: %w", cdb.corpusID, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
 	return nil
 }
 
-func (cdb *CollDatabase) createParentSumsTable(tx *sql.Tx, vcLen int) error {
-	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s_parent_sums (
+// createTriplesTable creates a table of head-centered
+// triples (see TriplePattern)
+func (cdb *CollDatabase) createTriplesTable(tx *sql.Tx, tableName string, vcLen int) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
 		id int(11) NOT NULL AUTO_INCREMENT,
-		p_lemma varchar(%d) NOT NULL,
-		p_upos varchar(50) NOT NULL,
-		deprel varchar(50) NOT NULL,
+		pattern varchar(50) NOT NULL,
+		lemma varchar(%d) NOT NULL,
+		upos varchar(50) NOT NULL,
+		lemma1 varchar(%d) NOT NULL,
+		upos1 varchar(50) NOT NULL,
+		lemma2 varchar(%d) NOT NULL,
+		upos2 varchar(50) NOT NULL,
+		marker varchar(%d) NOT NULL DEFAULT '',
 		freq int(11) NOT NULL,
+		score FLOAT,
 		PRIMARY KEY (id)
-	  )`, cdb.corpusID, vcLen))
+	)`, tableName, vcLen, vcLen, vcLen, markerColumnSize))
 	if err != nil {
-		return fmt.Errorf("failed to CREATE table %s_parent_sums: %w", cdb.corpusID, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
+	return nil
+}
 
-	idxName := fmt.Sprintf("%s_parent_sums_p_lemma_idx", cdb.corpusID)
-	_, err = tx.Exec(fmt.Sprintf("CREATE INDEX %s ON %s_parent_sums(p_lemma)", idxName, cdb.corpusID))
+// createSourcesTable creates a table with records of imported
+// vertical files so the same data cannot be appended twice
+func (cdb *CollDatabase) createSourcesTable(tx *sql.Tx, tableName string) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		source_id varchar(64) NOT NULL,
+		path varchar(1000) NOT NULL,
+		num_tokens bigint NOT NULL,
+		num_sentences bigint NOT NULL,
+		num_docs bigint NOT NULL,
+		imported_at DATETIME NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE KEY (source_id)
+	)`, tableName))
 	if err != nil {
-		return fmt.Errorf("failed to CREATE index %s: %w", idxName, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
-
 	return nil
 }
 
-func (cdb *CollDatabase) dropChildSumsTable(tx *sql.Tx) error {
-	_, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s_child_sums`, cdb.corpusID))
+// createImportsTable creates a table with metadata of imports
+// (see ImportRecord)
+func (cdb *CollDatabase) createImportsTable(tx *sql.Tx, tableName string) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		import_type varchar(20) NOT NULL,
+		source_path varchar(1000) NOT NULL,
+		source_id varchar(64) NOT NULL,
+		co_occ_span int(11) NOT NULL,
+		scollex_version varchar(50) NOT NULL,
+		fcolls_rows bigint NOT NULL,
+		child_sums_rows bigint NOT NULL,
+		parent_sums_rows bigint NOT NULL,
+		token_freqs_rows bigint NOT NULL,
+		triples_rows bigint NOT NULL DEFAULT 0,
+		started_at varchar(40) NOT NULL,
+		finished_at varchar(40) NOT NULL,
+		PRIMARY KEY (id)
+	)`, tableName))
 	if err != nil {
-		return fmt.Errorf("failed to DROP table %s_child_sums: %w", cdb.corpusID, err)
+		return fmt.Errorf("failed to CREATE table %s: %w", tableName, err)
 	}
 	return nil
 }
 
-func (cdb *CollDatabase) createChildSumsTable(tx *sql.Tx, vcLen int) error {
-	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s_child_sums (
-		id int(11) NOT NULL AUTO_INCREMENT,
-		lemma varchar(%d) NOT NULL,
-		upos varchar(50) NOT NULL,
-		deprel varchar(50) NOT NULL,
-		freq int(11) NOT NULL,
-		PRIMARY KEY (id)
-	)`, cdb.corpusID, vcLen))
+// dropTables removes all the tables using a single statement
+func (cdb *CollDatabase) dropTables(execer sqlExecer, tables importTables) error {
+	_, err := execer.ExecContext(
+		cdb.ctx,
+		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, strings.Join(tables.all(), ", ")),
+	)
 	if err != nil {
-		return fmt.Errorf("failed to CREATE table %s_child_sums: %w", cdb.corpusID, err)
+		return fmt.Errorf("failed to DROP tables: %w", err)
 	}
+	return nil
+}
 
-	idxName := fmt.Sprintf("%s_child_sums_p_lemma_idx", cdb.corpusID)
-	_, err = tx.Exec(fmt.Sprintf("CREATE INDEX %s ON %s_child_sums(lemma)", idxName, cdb.corpusID))
+// createStagingTables (re)creates empty staging tables an import
+// writes into. The live tables are not affected. In case withIndexes
+// is false, secondary indexes are not created (see createMissingIndexes)
+// which speeds up bulk loading.
+func (cdb *CollDatabase) createStagingTables(withIndexes bool) error {
+	tx, err := cdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	tables := cdb.stagingTables()
+	log.Info().Msg("creating staging tables")
+	err = cdb.dropTables(tx, tables)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = cdb.createCollsTable(tx, tables.fcolls, defaultWordColumnSize)
 	if err != nil {
-		return fmt.Errorf("failed to CREATE index %s: %w", idxName, err)
+		tx.Rollback()
+		return err
+	}
+	err = cdb.createParentSumsTable(tx, tables.parentSums, defaultWordColumnSize)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = cdb.createChildSumsTable(tx, tables.childSums, defaultWordColumnSize)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = cdb.createTokenFreqsTable(tx, tables.tokenFreqs, defaultWordColumnSize)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = cdb.createTriplesTable(tx, tables.triples, defaultWordColumnSize)
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
+	err = cdb.createSourcesTable(tx, tables.sources)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = cdb.createImportsTable(tx, tables.imports)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if withIndexes {
+		for _, idx := range tables.indexes() {
+			if err := cdb.createIndex(tx, idx); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
 
+func (cdb *CollDatabase) createIndex(tx *sql.Tx, idx tableIndex) error {
+	_, err := tx.Exec(
+		fmt.Sprintf("CREATE INDEX %s ON %s(%s)", idx.name(), idx.tableName, idx.column))
+	if err != nil {
+		return fmt.Errorf("failed to CREATE index %s: %w", idx.name(), err)
+	}
 	return nil
 }
 
-func (cdb *CollDatabase) InitializeDB(db *sql.DB, force bool) error {
-	tx, err := db.Begin()
+func (cdb *CollDatabase) indexExists(idx tableIndex) (bool, error) {
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		"SELECT COUNT(*) FROM information_schema.statistics "+
+			"WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?",
+		idx.tableName, idx.name(),
+	)
+	var ans int
+	if err := row.Scan(&ans); err != nil {
+		return false, err
+	}
+	return ans > 0, nil
+}
+
+// createMissingIndexes creates secondary indexes
+// of the tables which do not exist yet
+func (cdb *CollDatabase) createMissingIndexes(tables importTables) error {
+	tx, err := cdb.db.Begin()
 	if err != nil {
 		return err
 	}
-	if force {
-		log.Info().Msg("dropping existing tables (requested by the -f arg.)")
-		err = cdb.dropCollsTable(tx)
+	for _, idx := range tables.indexes() {
+		exists, err := cdb.indexExists(idx)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
-		err = cdb.dropParentSumsTable(tx)
-		if err != nil {
+		if exists {
+			continue
+		}
+		t0 := time.Now()
+		if err := cdb.createIndex(tx, idx); err != nil {
 			tx.Rollback()
 			return err
 		}
-		err = cdb.dropChildSumsTable(tx)
+		log.Info().
+			Str("index", idx.name()).
+			Float64("durationSec", time.Since(t0).Seconds()).
+			Msg("created deferred index")
+	}
+	return tx.Commit()
+}
+
+// ValidateStagingTables tests whether staging tables contain
+// expected numbers of rows.
+func (cdb *CollDatabase) ValidateStagingTables(expected importCounts) error {
+	tables := cdb.stagingTables()
+	if expected.fcolls == 0 {
+		return fmt.Errorf("no collocations found - refusing to replace live data")
+	}
+	for tableName, numRows := range map[string]int64{
+		tables.fcolls:     expected.fcolls,
+		tables.childSums:  expected.childSums,
+		tables.parentSums: expected.parentSums,
+		tables.tokenFreqs: expected.tokenFreqs,
+		tables.sources:    expected.sources,
+		tables.imports:    expected.imports,
+		tables.triples:    expected.triples,
+	} {
+		found, err := cdb.countRows(tableName)
 		if err != nil {
-			tx.Rollback()
-			return err
+			return fmt.Errorf("failed to validate table %s: %w", tableName, err)
+		}
+		if found != numRows {
+			return fmt.Errorf(
+				"failed to validate table %s: expected %d rows, found %d",
+				tableName, numRows, found)
+		}
+	}
+	return nil
+}
+
+func (cdb *CollDatabase) countRows(tableName string) (int64, error) {
+	var ans int64
+	row := cdb.db.QueryRowContext(cdb.ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName))
+	err := row.Scan(&ans)
+	return ans, err
+}
+
+func (cdb *CollDatabase) tableExists(tableName string) (bool, error) {
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		"SELECT COUNT(*) FROM information_schema.tables "+
+			"WHERE table_schema = DATABASE() AND table_name = ?",
+		tableName,
+	)
+	var ans int
+	if err := row.Scan(&ans); err != nil {
+		return false, err
+	}
+	return ans > 0, nil
+}
+
+// tablesExist tests the existence of each of the provided tables.
+// The returned values are in the order of importTables.all().
+func (cdb *CollDatabase) tablesExist(tables importTables) ([]bool, error) {
+	ans := make([]bool, len(tables.all()))
+	for i, tableName := range tables.all() {
+		exists, err := cdb.tableExists(tableName)
+		if err != nil {
+			return ans, err
 		}
+		ans[i] = exists
 	}
-	log.Info().Msg("creating tables")
-	err = cdb.createCollsTable(tx, defaultWordColumnSize)
+	return ans, nil
+}
+
+func (cdb *CollDatabase) ensureGenerationsTable() error {
+	_, err := cdb.db.ExecContext(
+		cdb.ctx,
+		`CREATE TABLE IF NOT EXISTS scollex_generations (
+			corpus_id varchar(100) NOT NULL,
+			swapped_at DATETIME NOT NULL,
+			PRIMARY KEY (corpus_id)
+		)`,
+	)
 	if err != nil {
-		tx.Rollback()
+		return fmt.Errorf("failed to CREATE table scollex_generations: %w", err)
+	}
+	return nil
+}
+
+func (cdb *CollDatabase) setGenerationSwapped(execer sqlExecer) error {
+	_, err := execer.ExecContext(
+		cdb.ctx,
+		"REPLACE INTO scollex_generations (corpus_id, swapped_at) VALUES (?, NOW())",
+		cdb.corpusID,
+	)
+	return err
+}
+
+// SwapStagingTables atomically replaces live tables with staging ones.
+// The replaced tables are kept as a previous generation (see Rollback).
+// An older previous generation (if any) is removed.
+func (cdb *CollDatabase) SwapStagingTables() error {
+	if err := cdb.ensureGenerationsTable(); err != nil {
+		return err
+	}
+	if err := cdb.ensureSchemaVersionsTable(); err != nil {
 		return err
 	}
-	err = cdb.createParentSumsTable(tx, defaultWordColumnSize)
+	live := cdb.liveTables()
+	prev := cdb.prevTables()
+	staging := cdb.stagingTables()
+	// Please note that installations created by older versions
+	// may miss some of the live tables
+	liveExists, err := cdb.tablesExist(live)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	err = cdb.createChildSumsTable(tx, defaultWordColumnSize)
+
+	// Please note that MySQL implicitly commits DDL statements
+	// so there is no point in running them within a transaction.
+	if err := cdb.dropTables(cdb.db, prev); err != nil {
+		return err
+	}
+	renames := make([]string, 0, 2*len(live.all()))
+	for i, tableName := range live.all() {
+		if liveExists[i] {
+			renames = append(renames, fmt.Sprintf("%s TO %s", tableName, prev.all()[i]))
+		}
+		renames = append(renames, fmt.Sprintf("%s TO %s", staging.all()[i], tableName))
+	}
+	// RENAME TABLE with multiple tables is atomic
+	_, err = cdb.db.ExecContext(
+		cdb.ctx, fmt.Sprintf("RENAME TABLE %s", strings.Join(renames, ", ")))
 	if err != nil {
-		tx.Rollback()
+		return fmt.Errorf("failed to swap staging tables: %w", err)
+	}
+	if err := cdb.setGenerationSwapped(cdb.db); err != nil {
 		return err
 	}
-	err = tx.Commit()
+	// staging tables are always created by the current version
+	return cdb.setSchemaVersion(cdb.db, CurrentSchemaVersion)
+}
+
+// Rollback swaps the live tables with the previous generation.
+// Calling the method twice restores the original state.
+func (cdb *CollDatabase) Rollback() error {
+	live := cdb.liveTables()
+	prev := cdb.prevTables()
+	liveExists, err := cdb.tablesExist(live)
+	if err != nil {
+		return err
+	}
+	prevExists, err := cdb.tablesExist(prev)
+	if err != nil {
+		return err
+	}
+	if !prevExists[0] {
+		return fmt.Errorf("no previous generation of corpus %s found", cdb.corpusID)
+	}
+	// the previous generation may have been created by an older version
+	prevVersion, err := cdb.detectSchemaVersion(prev)
+	if err != nil {
+		return err
+	}
+	if err := cdb.ensureGenerationsTable(); err != nil {
+		return err
+	}
+	if err := cdb.ensureSchemaVersionsTable(); err != nil {
+		return err
+	}
+	// generations may differ in the set of tables (e.g. a generation
+	// created by an older version) so we rename only existing ones
+	renames := make([]string, 0, 3*len(live.all()))
+	for i, tableName := range live.all() {
+		tmpName := tableName + rollbackTableSuffix
+		if liveExists[i] {
+			renames = append(renames, fmt.Sprintf("%s TO %s", tableName, tmpName))
+		}
+		if prevExists[i] {
+			renames = append(renames, fmt.Sprintf("%s TO %s", prev.all()[i], tableName))
+		}
+		if liveExists[i] {
+			renames = append(renames, fmt.Sprintf("%s TO %s", tmpName, prev.all()[i]))
+		}
+	}
+	// a single RENAME TABLE statement is atomic (and MySQL
+	// implicitly commits it anyway so no transaction is used)
+	_, err = cdb.db.ExecContext(
+		cdb.ctx, fmt.Sprintf("RENAME TABLE %s", strings.Join(renames, ", ")))
+	if err != nil {
+		return fmt.Errorf("failed to rollback corpus %s: %w", cdb.corpusID, err)
+	}
+	if err := cdb.setGenerationSwapped(cdb.db); err != nil {
+		return err
+	}
+	return cdb.setSchemaVersion(cdb.db, prevVersion)
+}
+
+// DropExpiredGeneration removes the previous generation of tables
+// in case it has been replaced more than `windowHours` ago.
+func (cdb *CollDatabase) DropExpiredGeneration(windowHours int) error {
+	if err := cdb.ensureGenerationsTable(); err != nil {
+		return err
+	}
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		"SELECT COUNT(*) FROM scollex_generations "+
+			"WHERE corpus_id = ? AND swapped_at < NOW() - INTERVAL ? HOUR",
+		cdb.corpusID, windowHours,
+	)
+	var numExpired int
+	if err := row.Scan(&numExpired); err != nil {
+		return err
+	}
+	if numExpired == 0 {
+		return nil
+	}
+	if err := cdb.dropTables(cdb.db, cdb.prevTables()); err != nil {
+		return err
+	}
+	_, err := cdb.db.ExecContext(
+		cdb.ctx, "DELETE FROM scollex_generations WHERE corpus_id = ?", cdb.corpusID)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	log.Info().
+		Str("corpusId", cdb.corpusID).
+		Int("windowHours", windowHours).
+		Msg("dropped expired previous generation of tables")
 	return nil
 }
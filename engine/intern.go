@@ -0,0 +1,111 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import "strings"
+
+const (
+	// emptyStringID is an ID of the empty string
+	// which is interned in every pool
+	emptyStringID uint32 = 0
+
+	// approxPoolEntryOverhead is a rough estimate of memory occupied
+	// by a single interned string (map entry, slice item, string
+	// headers) excluding the actual string data
+	approxPoolEntryOverhead = 64
+)
+
+// stringPool interns strings (lemmas, PoS tags, deprels) into compact
+// integer IDs so counting tables can be keyed by small structs and each
+// distinct string is stored only once. The pool is not thread-safe -
+// each worker uses its own pool (see stringPool.translator for
+// merging tables of different pools).
+type stringPool struct {
+	ids    map[string]uint32
+	values []string
+
+	// grown is an estimated number of bytes the pool
+	// has grown by since the last call of takeGrowth
+	grown int
+}
+
+// ID returns an ID of the value. In case the value is
+// not interned yet, it is added to the pool.
+func (sp *stringPool) ID(value string) uint32 {
+	if id, ok := sp.ids[value]; ok {
+		return id
+	}
+	// tokens' attributes may refer to their whole vertical
+	// line so we have to make a copy
+	value = strings.Clone(value)
+	id := uint32(len(sp.values))
+	sp.values = append(sp.values, value)
+	sp.ids[value] = id
+	sp.grown += len(value) + approxPoolEntryOverhead
+	return id
+}
+
+// lookup returns an ID of an already interned value. The pool
+// is not modified so this can be used for membership tests.
+func (sp *stringPool) lookup(value string) (uint32, bool) {
+	id, ok := sp.ids[value]
+	return id, ok
+}
+
+// Value returns an interned string with the ID
+func (sp *stringPool) Value(id uint32) string {
+	return sp.values[id]
+}
+
+// takeGrowth returns an estimated number of bytes the pool
+// has grown by since the last call
+func (sp *stringPool) takeGrowth() int {
+	ans := sp.grown
+	sp.grown = 0
+	return ans
+}
+
+// translator provides a function converting IDs of another pool into
+// IDs of this pool (missing values are interned). Translated IDs are
+// cached so each distinct string is looked up only once.
+func (sp *stringPool) translator(other *stringPool) func(id uint32) uint32 {
+	if sp == other {
+		return func(id uint32) uint32 {
+			return id
+		}
+	}
+	// cached values are shifted by one so zero means "unknown"
+	cache := make([]uint32, len(other.values))
+	return func(id uint32) uint32 {
+		if v := cache[id]; v > 0 {
+			return v - 1
+		}
+		ans := sp.ID(other.values[id])
+		cache[id] = ans + 1
+		return ans
+	}
+}
+
+func newStringPool() *stringPool {
+	ans := &stringPool{
+		ids:    make(map[string]uint32),
+		values: make([]string, 0, 1000),
+	}
+	ans.ID("")
+	ans.takeGrowth()
+	return ans
+}
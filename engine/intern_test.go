@@ -0,0 +1,355 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/tomachalek/vertigo/v5"
+)
+
+const (
+	benchCoOccSpan = 2
+)
+
+func TestStringPool(t *testing.T) {
+	pool := newStringPool()
+	if id, ok := pool.lookup(""); !ok || id != emptyStringID {
+		t.Errorf("expected the empty string to have ID %d, found %d (%v)", emptyStringID, id, ok)
+	}
+	if growth := pool.takeGrowth(); growth != 0 {
+		t.Errorf("expected no growth of a new pool, found %d", growth)
+	}
+	line := "dog\tNOUN"
+	dog := pool.ID(line[:3])
+	noun := pool.ID(line[4:])
+	if dog == noun || dog == emptyStringID || noun == emptyStringID {
+		t.Errorf("expected distinct IDs, found %d and %d", dog, noun)
+	}
+	if id := pool.ID("dog"); id != dog {
+		t.Errorf("expected ID %d of an interned value, found %d", dog, id)
+	}
+	if v := pool.Value(dog); v != "dog" {
+		t.Errorf("expected value dog, found %s", v)
+	}
+	if growth := pool.takeGrowth(); growth != 2*approxPoolEntryOverhead+len("dog")+len("NOUN") {
+		t.Errorf("unexpected growth %d", growth)
+	}
+	if growth := pool.takeGrowth(); growth != 0 {
+		t.Errorf("expected growth to be reset, found %d", growth)
+	}
+	if _, ok := pool.lookup("cat"); ok {
+		t.Error("expected cat not to be interned")
+	}
+	if len(pool.values) != 3 {
+		t.Errorf("expected lookup not to intern values, found %d values", len(pool.values))
+	}
+}
+
+func TestStringPoolTranslator(t *testing.T) {
+	pool := newStringPool()
+	other := newStringPool()
+	for _, v := range []string{"dog", "NOUN", "bark"} {
+		pool.ID(v)
+	}
+	otherIDs := make(map[string]uint32)
+	for _, v := range []string{"VERB", "bark", "cat", "dog"} {
+		otherIDs[v] = other.ID(v)
+	}
+	otherIDs[""] = emptyStringID
+	tr := pool.translator(other)
+	// twice to test cached values
+	for i := 0; i < 2; i++ {
+		for v, id := range otherIDs {
+			if found := pool.Value(tr(id)); found != v {
+				t.Errorf("expected ID %d to be translated to %q, found %q", id, v, found)
+			}
+		}
+	}
+	if len(pool.values) != 6 {
+		t.Errorf("expected 6 interned values, found %d", len(pool.values))
+	}
+	same := pool.translator(pool)
+	if id := pool.ID("cat"); same(id) != id {
+		t.Errorf("expected identity translation of ID %d, found %d", id, same(id))
+	}
+}
+
+// TestMergeProcessorsWithSeparatePools tests that keys of tables
+// of parallel workers (each having its own string pool) are translated
+// when the tables are merged
+func TestMergeProcessorsWithSeparatePools(t *testing.T) {
+	conf := testSyntaxProps(t)
+	spills := newImportSpills(t.TempDir(), "test")
+	t.Cleanup(spills.Close)
+	validator := newVertValidator("test.vert", conf, -1)
+	procs := make([]*VertProcessor, 2)
+	coProcs := make([]*CoVertProcessor, 2)
+	for i := range procs {
+		procs[i] = newVertProcessor(conf, spills, &memoryBudget{}, validator)
+		coProcs[i] = newCoVertProcessor(conf, 2, anyPair{}, spills, &memoryBudget{}, validator)
+	}
+	if procs[0].pool == procs[1].pool || coProcs[0].pool == coProcs[1].pool {
+		t.Fatal("expected separate pools of the workers")
+	}
+	// the workers intern the same strings in different orders
+	for i, words := range [][]string{
+		{"dog", "NOUN", "bark", "VERB", "nsubj"},
+		{"VERB", "nsubj", "cat", "NOUN", "bark", "dog"},
+	} {
+		for _, w := range words {
+			procs[i].pool.ID(w)
+			coProcs[i].pool.ID(w)
+		}
+	}
+	for i, lemma := range []string{"dog", "cat"} {
+		pool := procs[i].pool
+		key := ctKey{
+			lemma:  pool.ID(lemma),
+			upos:   pool.ID("NOUN"),
+			pLemma: pool.ID("bark"),
+			pUpos:  pool.ID("VERB"),
+			deprel: pool.ID("nsubj"),
+		}
+		procs[i].Table.add(key, int64(i+1))
+		procs[i].ChildCounts.add(fyKey{lemma: key.lemma, upos: key.upos, deprel: key.deprel}, 1)
+
+		coPool := coProcs[i].pool
+		coProcs[i].CoOccTable.add(
+			coKey{
+				lemma:   coPool.ID("dog"),
+				upos:    coPool.ID("NOUN"),
+				coLemma: coPool.ID("bark"),
+				coUpos:  coPool.ID("VERB"),
+			},
+			int64(i+2),
+		)
+		coProcs[i].TokenCounts.add(fyKey{lemma: coPool.ID(lemma), upos: coPool.ID("NOUN")}, 10)
+	}
+
+	proc, err := mergeVertProcessors(procs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareRecords(
+		t,
+		"fcolls",
+		[]spillRecord{
+			{Fields: []string{"cat", "NOUN", "bark", "VERB", "nsubj", "", "", "", ""}, Freq: 2},
+			{Fields: []string{"dog", "NOUN", "bark", "VERB", "nsubj", "", "", "", ""}, Freq: 1},
+		},
+		proc.Table.records(),
+	)
+	compareRecords(
+		t,
+		"childSums",
+		[]spillRecord{
+			{Fields: []string{"cat", "NOUN", "nsubj", "", "", ""}, Freq: 1},
+			{Fields: []string{"dog", "NOUN", "nsubj", "", "", ""}, Freq: 1},
+		},
+		proc.ChildCounts.records(),
+	)
+
+	coProc, err := mergeCoVertProcessors(coProcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareRecords(
+		t,
+		"coOccs",
+		[]spillRecord{{Fields: []string{"dog", "NOUN", "bark", "VERB"}, Freq: 5}},
+		coProc.CoOccTable.records(),
+	)
+	compareRecords(
+		t,
+		"tokenFreqs",
+		[]spillRecord{
+			{Fields: []string{"cat", "NOUN", "", "", "", ""}, Freq: 10},
+			{Fields: []string{"dog", "NOUN", "", "", "", ""}, Freq: 10},
+		},
+		coProc.TokenCounts.records(),
+	)
+}
+
+// stringKeyedItem is an item of stringKeyedTable
+type stringKeyedItem struct {
+	fields []string
+	freq   int64
+}
+
+// stringKeyedTable counts items keyed by their formatted fields
+// the way the counting tables did before strings were interned.
+// It serves only as a baseline of the benchmarks.
+type stringKeyedTable map[string]*stringKeyedItem
+
+func (table stringKeyedTable) add(key string, fields ...string) {
+	v, ok := table[key]
+	if !ok {
+		v = &stringKeyedItem{fields: fields}
+		table[key] = v
+	}
+	v.freq++
+}
+
+// benchTokens reads all the tokens of a generated vertical file
+// so the benchmarks measure only the counting
+func benchTokens(b *testing.B) []*vertigo.Token {
+	vertPath := writeTestVertical(
+		b,
+		testVerticalSpec{numTokens: 200000, vocabSize: 20000, outsideEvery: 37},
+	)
+	f, err := os.Open(vertPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	ans := make([]*vertigo.Token, 0, 200000)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		value, err := parseVertLine(sc.Text())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if tk, ok := value.(*vertigo.Token); ok {
+			ans = append(ans, tk)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		b.Fatal(err)
+	}
+	return ans
+}
+
+// BenchmarkCountStringKeyed counts pairs, token frequencies and window
+// co-occurrences of pre-parsed tokens using string keys
+func BenchmarkCountStringKeyed(b *testing.B) {
+	conf := testSyntaxProps(b)
+	tokens := benchTokens(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pairs := make(stringKeyedTable)
+		children := make(stringKeyedTable)
+		tokenFreqs := make(stringKeyedTable)
+		coOccs := make(stringKeyedTable)
+		window := make([][2]string, 0, 2*benchCoOccSpan+1)
+		for _, tk := range tokens {
+			lemma := tk.Attrs[conf.LemmaAttr.VerticalCol-1]
+			upos := tk.Attrs[conf.PosAttr.VerticalCol-1]
+			pLemma := tk.Attrs[conf.ParLemmaAttr.VerticalCol-1]
+			pUpos := tk.Attrs[conf.ParPosAttr.VerticalCol-1]
+			deprel := tk.Attrs[conf.FuncAttr.VerticalCol-1]
+			pairs.add(
+				fmt.Sprintf("%s:%s:%s:%s:%s", lemma, upos, deprel, pLemma, pUpos),
+				lemma, upos, pLemma, pUpos, deprel,
+			)
+			children.add(fmt.Sprintf("%s:%s:%s", lemma, upos, deprel), lemma, upos, deprel)
+			tokenFreqs.add(fmt.Sprintf("%s:%s:", lemma, upos), lemma, upos, "")
+
+			if len(window) == 2*benchCoOccSpan+1 {
+				window = append(window[1:], [2]string{lemma, upos})
+			} else {
+				window = append(window, [2]string{lemma, upos})
+			}
+			if len(window) < 2*benchCoOccSpan+1 {
+				continue
+			}
+			middle := window[benchCoOccSpan]
+			for j, near := range window {
+				if j != benchCoOccSpan {
+					coOccs.add(
+						fmt.Sprintf("%s:%s::%s:%s", middle[0], middle[1], near[0], near[1]),
+						middle[0], middle[1], near[0], near[1],
+					)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkCountInterned counts the same items as BenchmarkCountStringKeyed
+// using the interned tables
+func BenchmarkCountInterned(b *testing.B) {
+	conf := testSyntaxProps(b)
+	tokens := benchTokens(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pool := newStringPool()
+		pairs := newCounterTable(pool)
+		children := newFyTable(pool)
+		tokenFreqs := newFyTable(pool)
+		coOccs := newCoOccTable(pool)
+		window := make([][2]uint32, 0, 2*benchCoOccSpan+1)
+		for _, tk := range tokens {
+			lemma := pool.ID(tk.Attrs[conf.LemmaAttr.VerticalCol-1])
+			upos := pool.ID(tk.Attrs[conf.PosAttr.VerticalCol-1])
+			deprel := pool.ID(tk.Attrs[conf.FuncAttr.VerticalCol-1])
+			pairs.add(
+				ctKey{
+					lemma:  lemma,
+					upos:   upos,
+					pLemma: pool.ID(tk.Attrs[conf.ParLemmaAttr.VerticalCol-1]),
+					pUpos:  pool.ID(tk.Attrs[conf.ParPosAttr.VerticalCol-1]),
+					deprel: deprel,
+				},
+				1,
+			)
+			children.add(fyKey{lemma: lemma, upos: upos, deprel: deprel}, 1)
+			tokenFreqs.add(fyKey{lemma: lemma, upos: upos}, 1)
+
+			if len(window) == 2*benchCoOccSpan+1 {
+				window = append(window[1:], [2]uint32{lemma, upos})
+			} else {
+				window = append(window, [2]uint32{lemma, upos})
+			}
+			if len(window) < 2*benchCoOccSpan+1 {
+				continue
+			}
+			middle := window[benchCoOccSpan]
+			for j, near := range window {
+				if j != benchCoOccSpan {
+					coOccs.add(
+						coKey{lemma: middle[0], upos: middle[1], coLemma: near[0], coUpos: near[1]},
+						1,
+					)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkCountVertical measures the whole counting
+// of both the passes over a generated vertical file
+func BenchmarkCountVertical(b *testing.B) {
+	conf := testSyntaxProps(b)
+	vertPath := writeTestVertical(
+		b,
+		testVerticalSpec{numTokens: 200000, vocabSize: 20000, outsideEvery: 37},
+	)
+	for _, numWorkers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", numWorkers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				countVertical(b, vertPath, conf, numWorkers)
+			}
+		})
+	}
+}
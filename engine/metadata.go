@@ -0,0 +1,161 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ImportTypeFull   = "full"
+	ImportTypeAppend = "append"
+)
+
+var (
+	ErrNoImportMetadata = errors.New("no import metadata available")
+)
+
+// ImportTableRows contains numbers of rows written into
+// respective tables by an import. In case of appended data,
+// the values represent numbers of updated or inserted rows.
+type ImportTableRows struct {
+	Fcolls     int64 `json:"fcolls"`
+	ChildSums  int64 `json:"childSums"`
+	ParentSums int64 `json:"parentSums"`
+	TokenFreqs int64 `json:"tokenFreqs"`
+	Triples    int64 `json:"triples"`
+}
+
+// ImportRecord describes a single import of data
+// into corpus tables
+type ImportRecord struct {
+	ImportType     string          `json:"importType"`
+	SourcePath     string          `json:"sourcePath"`
+	SourceID       string          `json:"sourceId"`
+	CoOccSpan      int             `json:"coOccSpan"`
+	ScollexVersion string          `json:"scollexVersion"`
+	TableRows      ImportTableRows `json:"tableRows"`
+
+	// StartedAt and FinishedAt are RFC3339 timestamps
+	// in the configured time zone
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt"`
+}
+
+// CorpusInfo describes the data currently available for a corpus
+type CorpusInfo struct {
+	CorpusID string     `json:"corpusId"`
+	Size     CorpusSize `json:"size"`
+
+	// Imports contains all the imports the live data
+	// consist of (i.e. a full import possibly followed
+	// by appended data) in chronological order
+	Imports []ImportRecord `json:"imports"`
+}
+
+func newImportRecord(importType, vertPath, sourceID string, opts ImportOptions, started time.Time) ImportRecord {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return ImportRecord{
+		ImportType:     importType,
+		SourcePath:     vertPath,
+		SourceID:       sourceID,
+		CoOccSpan:      opts.CoOccSpan,
+		ScollexVersion: opts.AppVersion,
+		StartedAt:      started.In(loc).Format(time.RFC3339),
+	}
+}
+
+// writeImportRecord stores an import record. The record's finish
+// time is set to the current time.
+func writeImportRecord(tx *sql.Tx, tableName string, rec ImportRecord, loc *time.Location) error {
+	if loc == nil {
+		loc = time.Local
+	}
+	rec.FinishedAt = time.Now().In(loc).Format(time.RFC3339)
+	_, err := tx.Exec(
+		fmt.Sprintf(
+			`INSERT INTO %s (import_type, source_path, source_id, co_occ_span, scollex_version,
+				fcolls_rows, child_sums_rows, parent_sums_rows, token_freqs_rows,
+				triples_rows, started_at, finished_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			tableName,
+		),
+		rec.ImportType, rec.SourcePath, rec.SourceID, rec.CoOccSpan, rec.ScollexVersion,
+		rec.TableRows.Fcolls, rec.TableRows.ChildSums, rec.TableRows.ParentSums,
+		rec.TableRows.TokenFreqs, rec.TableRows.Triples, rec.StartedAt, rec.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write import metadata: %w", err)
+	}
+	return nil
+}
+
+// GetCorpusInfo provides metadata of imports of the live data.
+// In case the data have been imported by an older version without
+// import metadata, ErrNoImportMetadata is returned.
+func (cdb *CollDatabase) GetCorpusInfo() (*CorpusInfo, error) {
+	tableName := cdb.liveTables().imports
+	exists, err := cdb.tableExists(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoImportMetadata
+	}
+	rows, err := cdb.db.QueryContext(
+		cdb.ctx,
+		fmt.Sprintf(
+			`SELECT import_type, source_path, source_id, co_occ_span, scollex_version,
+				fcolls_rows, child_sums_rows, parent_sums_rows, token_freqs_rows,
+				triples_rows, started_at, finished_at
+			FROM %s ORDER BY id`,
+			tableName,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	size, err := cdb.GetCorpusSize()
+	if err != nil {
+		return nil, err
+	}
+	ans := &CorpusInfo{
+		CorpusID: cdb.corpusID,
+		Size:     size,
+		Imports:  make([]ImportRecord, 0, 5),
+	}
+	for rows.Next() {
+		var rec ImportRecord
+		err := rows.Scan(
+			&rec.ImportType, &rec.SourcePath, &rec.SourceID, &rec.CoOccSpan, &rec.ScollexVersion,
+			&rec.TableRows.Fcolls, &rec.TableRows.ChildSums, &rec.TableRows.ParentSums,
+			&rec.TableRows.TokenFreqs, &rec.TableRows.Triples, &rec.StartedAt, &rec.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ans.Imports = append(ans.Imports, rec)
+	}
+	return ans, rows.Err()
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizationProps configures normalization of lemmas. The same
+// normalization is applied on import and on query time so different
+// spellings of a lemma (e.g. `Team` and `team`) are counted and
+// searched together. As corpora contain the original lemmas, generated
+// CQL queries match known variants of lemmas and case folded lemmas
+// are matched case-insensitively (see LemmaForms).
+type NormalizationProps struct {
+
+	// NFC if true then lemmas are converted to the Unicode
+	// normalization form C
+	NFC bool `json:"nfc"`
+
+	// CaseFolding if true then lemmas are case folded
+	// (e.g. `Team` => `team`)
+	CaseFolding bool `json:"caseFolding"`
+
+	// VariantsPath is an optional path of a file mapping variants
+	// of lemmas to their canonical forms. Each line contains a variant
+	// and its canonical lemma separated by a tab. Empty lines and lines
+	// starting with `#` are ignored. The mapping is applied after
+	// the other normalization steps (which are applied to the file
+	// values too).
+	VariantsPath string `json:"variantsPath"`
+
+	variants map[string]string
+
+	// variantsOf maps canonical lemmas to their variants
+	variantsOf map[string][]string
+}
+
+// IsActive tells whether lemmas are changed by the normalization
+func (props *NormalizationProps) IsActive() bool {
+	return props.NFC || props.CaseFolding || props.VariantsPath != ""
+}
+
+// LemmaForms returns forms a normalized lemma may have in a corpus,
+// i.e. the lemma itself followed by its known variants (sorted).
+// In case CaseFolding is enabled, the forms are case folded so they
+// must be matched case-insensitively. The props must be validated first.
+func (props *NormalizationProps) LemmaForms(lemma string) []string {
+	return append([]string{lemma}, props.variantsOf[lemma]...)
+}
+
+// NewNormalizer creates a normalizer of lemmas. In case the variants
+// file is configured, the props must be validated first.
+func (props *NormalizationProps) NewNormalizer() *LemmaNormalizer {
+	return &LemmaNormalizer{props: props, caser: cases.Fold()}
+}
+
+// loadVariants reads the file of lemma variants (if configured)
+func (props *NormalizationProps) loadVariants(confContext string) error {
+	props.variants = make(map[string]string)
+	props.variantsOf = make(map[string][]string)
+	if props.VariantsPath == "" {
+		return nil
+	}
+	f, err := os.Open(props.VariantsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read `%s.normalization.variantsPath`: %w", confContext, err)
+	}
+	defer f.Close()
+	normalizer := props.NewNormalizer()
+	scanner := bufio.NewScanner(f)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		variant, lemma, ok := strings.Cut(line, "\t")
+		if !ok || strings.TrimSpace(variant) == "" || strings.TrimSpace(lemma) == "" {
+			return fmt.Errorf(
+				"invalid lemma variants file %s: expected `variant<TAB>lemma` on line %d",
+				props.VariantsPath, lineNum)
+		}
+		props.variants[normalizer.normalizeForm(strings.TrimSpace(variant))] =
+			normalizer.normalizeForm(strings.TrimSpace(lemma))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read lemma variants file %s: %w", props.VariantsPath, err)
+	}
+	for variant, lemma := range props.variants {
+		if variant != lemma {
+			props.variantsOf[lemma] = append(props.variantsOf[lemma], variant)
+		}
+	}
+	for _, variants := range props.variantsOf {
+		sort.Strings(variants)
+	}
+	return nil
+}
+
+// LemmaNormalizer applies a configured normalization (see
+// NormalizationProps) to lemmas. A normalizer must not be shared
+// by multiple goroutines.
+type LemmaNormalizer struct {
+	props *NormalizationProps
+	caser cases.Caser
+}
+
+// normalizeForm applies all the normalization steps
+// except for the variant mapping
+func (ln *LemmaNormalizer) normalizeForm(lemma string) string {
+	if ln.props.CaseFolding {
+		lemma = ln.caser.String(lemma)
+	}
+	if ln.props.NFC {
+		lemma = norm.NFC.String(lemma)
+	}
+	return lemma
+}
+
+// Normalize returns a normalized lemma
+func (ln *LemmaNormalizer) Normalize(lemma string) string {
+	lemma = ln.normalizeForm(lemma)
+	if canonical, ok := ln.props.variants[lemma]; ok {
+		return canonical
+	}
+	return lemma
+}
+
+// NormalizeMarker normalizes all the words of a marker
+// (e.g. `out of`, see caseMarkers)
+func (ln *LemmaNormalizer) NormalizeMarker(marker string) string {
+	if marker == "" {
+		return marker
+	}
+	words := strings.Split(marker, " ")
+	for i, w := range words {
+		words[i] = ln.Normalize(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// normalizedLemmas provides IDs of normalized lemmas. As the number
+// of distinct lemmas is limited, normalized values are cached
+// for IDs of the original ones.
+type normalizedLemmas struct {
+
+	// normalizer is nil in case the normalization is not active
+	normalizer *LemmaNormalizer
+	pool       *stringPool
+	cache      map[uint32]uint32
+}
+
+// ID returns an ID of a normalized lemma
+func (nl *normalizedLemmas) ID(lemma string) uint32 {
+	id := nl.pool.ID(lemma)
+	if nl.normalizer == nil {
+		return id
+	}
+	if ans, ok := nl.cache[id]; ok {
+		return ans
+	}
+	ans := nl.pool.ID(nl.normalizer.Normalize(lemma))
+	nl.cache[id] = ans
+	return ans
+}
+
+func newNormalizedLemmas(conf *SyntaxProps, pool *stringPool) *normalizedLemmas {
+	ans := &normalizedLemmas{pool: pool}
+	if conf.Normalization.IsActive() {
+		ans.normalizer = conf.Normalization.NewNormalizer()
+		ans.cache = make(map[uint32]uint32)
+	}
+	return ans
+}
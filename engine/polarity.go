@@ -0,0 +1,168 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+)
+
+const (
+	// PolarityNeg is a polarity of pairs with a negated verb
+	PolarityNeg = "neg"
+
+	// PolarityPos is a polarity of pairs with an affirmative verb
+	PolarityPos = "pos"
+
+	// polarityAny is a URL value matching pairs of any polarity
+	polarityAny = "any"
+
+	// polarityColumnSize is a size of database columns
+	// storing polarity of verbs
+	polarityColumnSize = 3
+
+	dfltNegRelation = "advmod"
+)
+
+// PolarityProps configures detection of negated verbs. Once any
+// of the rules is configured, each pair with a verb as the parent
+// (e.g. `nsubj`, `obj`, `obl`) is stored along with the verb's
+// polarity (see PolarityNeg, PolarityPos) so affirmative and
+// negated uses (e.g. `mít čas` vs. `nemít čas`) can be queried
+// separately. Other pairs have no polarity.
+type PolarityProps struct {
+
+	// NegFeature is a UD feature of negated verbs
+	// (e.g. `Polarity=Neg` in Czech). It requires `featsAttr`
+	// but it does not have to be listed in `features`.
+	NegFeature string `json:"negFeature"`
+
+	// NegLemmas lists lemmas of words negating verbs they
+	// are attached to (e.g. `not`, `never` in English)
+	NegLemmas []string `json:"negLemmas"`
+
+	// NegRelation is a relation attaching the NegLemmas words
+	// to verbs (default: `advmod`)
+	NegRelation string `json:"negRelation"`
+}
+
+// IsActive tells whether polarity of verbs is detected
+func (props *PolarityProps) IsActive() bool {
+	return props.NegFeature != "" || len(props.NegLemmas) > 0
+}
+
+func (props *PolarityProps) validateAndDefaults(confContext string, featsAttr PosAttrProps) error {
+	if props.NegFeature != "" {
+		if _, err := ParseFeatFilter(props.NegFeature); err != nil {
+			return fmt.Errorf("invalid `%s.polarity.negFeature`: %w", confContext, err)
+		}
+		if featsAttr.Name == "" {
+			return fmt.Errorf(
+				"`%s.polarity.negFeature` requires `%s.featsAttr`", confContext, confContext)
+		}
+	}
+	if props.NegRelation == "" {
+		props.NegRelation = dfltNegRelation
+	}
+	return nil
+}
+
+// ParsePolarityFilter validates a polarity filter (`neg`, `pos`
+// or `any`) and returns a respective CollFilter.Polarity value
+func ParsePolarityFilter(value string, conf *SyntaxProps) (string, error) {
+	switch value {
+	case "", polarityAny:
+		return "", nil
+	case PolarityNeg, PolarityPos:
+		if !conf.Polarity.IsActive() {
+			return "", fmt.Errorf("polarity of verbs not available")
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid polarity `%s`, expected one of `%s`, `%s`, `%s`",
+			value, PolarityNeg, PolarityPos, polarityAny)
+	}
+}
+
+// polarityDetector resolves polarity of verbs within sentences
+// (see PolarityProps). Strings are interned in a pool
+// of the respective processor.
+type polarityDetector struct {
+	props     *PolarityProps
+	pool      *stringPool
+	verb      uint32
+	neg       uint32
+	pos       uint32
+	negLemmas map[uint32]bool
+}
+
+// polarities returns IDs of polarity values for all the token
+// positions of a sentence (emptyStringID for tokens other than verbs).
+// A verb is negated in case it has the negative feature or in case
+// one of the negating words is attached to it.
+func (pd *polarityDetector) polarities(tokens []sentToken) []uint32 {
+	ans := make([]uint32, len(tokens))
+	for i, tk := range tokens {
+		if !tk.valid || tk.upos != pd.verb {
+			continue
+		}
+		if tk.negated {
+			ans[i] = pd.neg
+
+		} else {
+			ans[i] = pd.pos
+		}
+	}
+	if len(pd.negLemmas) == 0 {
+		return ans
+	}
+	for _, tk := range tokens {
+		if !tk.valid || tk.parent < 0 || !pd.negLemmas[tk.lemma] ||
+			ans[tk.parent] == emptyStringID ||
+			!isDeprelOf(pd.pool.Value(tk.deprel), pd.props.NegRelation) {
+			continue
+		}
+		ans[tk.parent] = pd.neg
+	}
+	return ans
+}
+
+// newPolarityDetector creates a detector of polarity of verbs
+// (nil in case the detection is not configured). The lemmas
+// must provide IDs of normalized lemmas the same way the processed
+// sentence tokens do.
+func newPolarityDetector(
+	conf *SyntaxProps,
+	pool *stringPool,
+	lemmas *normalizedLemmas,
+) *polarityDetector {
+	if !conf.Polarity.IsActive() {
+		return nil
+	}
+	ans := &polarityDetector{
+		props:     &conf.Polarity,
+		pool:      pool,
+		verb:      pool.ID(conf.VerbValue),
+		neg:       pool.ID(PolarityNeg),
+		pos:       pool.ID(PolarityPos),
+		negLemmas: make(map[uint32]bool),
+	}
+	for _, lemma := range conf.Polarity.NegLemmas {
+		ans.negLemmas[lemmas.ID(lemma)] = true
+	}
+	return ans
+}
@@ -21,10 +21,9 @@ import (
 	"database/sql"
 	"fmt"
 	"math"
-	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/czcorpus/cnc-gokit/collections"
 	"github.com/rs/zerolog/log"
 	"github.com/tomachalek/vertigo/v5"
 )
@@ -33,37 +32,107 @@ const (
 	bulkInsertChunkSize = 1000
 )
 
-type FyItem struct {
-	Lemma  string
-	Upos   string
-	Deprel string
-	Freq   int64
+// fyKey identifies a FyTable item by IDs of interned strings
+type fyKey struct {
+	lemma    uint32
+	upos     uint32
+	deprel   uint32
+	feats    uint32
+	marker   uint32
+	polarity uint32
 }
 
-type FyTable map[string]*FyItem
-
-func (table FyTable) mkKey(lemma, upos, deprel string) string {
-	return fmt.Sprintf("%s:%s:%s", lemma, upos, deprel)
+// FyTable contains frequencies of (lemma, upos, deprel, feats, marker,
+// polarity) items. Strings are interned in the table's pool.
+type FyTable struct {
+	pool  *stringPool
+	items map[fyKey]int64
 }
 
-func (table FyTable) Add(lemma, upos, deprel string, val int64) {
-	key := table.mkKey(lemma, upos, deprel)
-	v, ok := table[key]
+// add adds a value to a respective item and returns an estimated
+// number of bytes the table has grown by
+func (table FyTable) add(key fyKey, val int64) int {
+	v, ok := table.items[key]
+	table.items[key] = v + val
 	if !ok {
-		v = &FyItem{
-			Lemma:  lemma,
-			Upos:   upos,
-			Deprel: deprel,
-		}
-		table[key] = v
+		return approxTableEntryOverhead
 	}
-	v.Freq += val
+	return 0
 }
 
-func (table FyTable) Has(lemma, upos, deprel string) bool {
-	key := table.mkKey(lemma, upos, deprel)
-	_, ok := table[key]
-	return ok
+// Add adds a value to a respective item (without morphological
+// features and markers) and returns an estimated number of bytes
+// the table has grown by (excluding new strings in the pool)
+func (table FyTable) Add(lemma, upos, deprel string, val int64) int {
+	return table.add(
+		fyKey{
+			lemma:  table.pool.ID(lemma),
+			upos:   table.pool.ID(upos),
+			deprel: table.pool.ID(deprel),
+		},
+		val,
+	)
+}
+
+// Get returns a frequency of an item without morphological
+// features and markers (zero if not found)
+func (table FyTable) Get(lemma, upos, deprel string) int64 {
+	var key fyKey
+	var ok1, ok2, ok3 bool
+	key.lemma, ok1 = table.pool.lookup(lemma)
+	key.upos, ok2 = table.pool.lookup(upos)
+	key.deprel, ok3 = table.pool.lookup(deprel)
+	if !ok1 || !ok2 || !ok3 {
+		return 0
+	}
+	return table.items[key]
+}
+
+func (table FyTable) Len() int {
+	return len(table.items)
+}
+
+// Merge adds all the items of another table
+func (table FyTable) Merge(other FyTable) {
+	tr := table.pool.translator(other.pool)
+	for k, v := range other.items {
+		table.add(
+			fyKey{
+				lemma:    tr(k.lemma),
+				upos:     tr(k.upos),
+				deprel:   tr(k.deprel),
+				feats:    tr(k.feats),
+				marker:   tr(k.marker),
+				polarity: tr(k.polarity),
+			},
+			v,
+		)
+	}
+}
+
+func (table FyTable) records() []spillRecord {
+	ans := make([]spillRecord, 0, len(table.items))
+	for k, v := range table.items {
+		ans = append(
+			ans,
+			spillRecord{
+				Fields: []string{
+					table.pool.Value(k.lemma),
+					table.pool.Value(k.upos),
+					table.pool.Value(k.deprel),
+					table.pool.Value(k.feats),
+					table.pool.Value(k.marker),
+					table.pool.Value(k.polarity),
+				},
+				Freq: v,
+			},
+		)
+	}
+	return ans
+}
+
+func newFyTable(pool *stringPool) FyTable {
+	return FyTable{pool: pool, items: make(map[fyKey]int64)}
 }
 
 type CTItem struct {
@@ -72,129 +141,429 @@ type CTItem struct {
 	Deprel string
 	Upos   string
 	PUpos  string
-	Freq   int64
+
+	// Feats and PFeats contain selected morphological
+	// features of the child and the parent (see selectFeats)
+	Feats  string
+	PFeats string
+
+	// Marker is a lemma of a function word (typically
+	// a preposition) attached to the child (see caseMarkers)
+	Marker string
+
+	// Polarity is a polarity of the parent verb
+	// (empty for other parents, see PolarityProps)
+	Polarity string
+	Freq     int64
 }
 
-type CounterTable map[string]*CTItem
+// ctKey identifies a CounterTable item by IDs of interned strings
+type ctKey struct {
+	lemma    uint32
+	upos     uint32
+	pLemma   uint32
+	pUpos    uint32
+	deprel   uint32
+	feats    uint32
+	pFeats   uint32
+	marker   uint32
+	polarity uint32
+}
 
-func (table CounterTable) mkKey(lemma, upos, pLemma, pUpos, deprel string) string {
-	return fmt.Sprintf("%s:%s:%s:%s:%s", lemma, upos, deprel, pLemma, pUpos)
+// CounterTable contains frequencies of syntactic pairs.
+// Strings are interned in the table's pool.
+type CounterTable struct {
+	pool  *stringPool
+	items map[ctKey]int64
 }
 
-func (table CounterTable) Add(lemma, upos, pLemma, pUpos, deprel string, val int64) {
-	key := table.mkKey(lemma, upos, pLemma, pUpos, deprel)
-	v, ok := table[key]
+// add adds a value to a respective item and returns an estimated
+// number of bytes the table has grown by
+func (table CounterTable) add(key ctKey, val int64) int {
+	v, ok := table.items[key]
+	table.items[key] = v + val
 	if !ok {
-		v = &CTItem{
-			Lemma:  lemma,
-			Upos:   upos,
-			PLemma: pLemma,
-			PUpos:  pUpos,
-			Deprel: deprel,
-		}
-		table[key] = v
+		return approxTableEntryOverhead
+	}
+	return 0
+}
+
+// Add adds a value to a respective item and returns an estimated
+// number of bytes the table has grown by (excluding new strings
+// in the pool)
+func (table CounterTable) Add(
+	lemma, upos, pLemma, pUpos, deprel, feats, pFeats, marker, polarity string,
+	val int64,
+) int {
+	return table.add(
+		ctKey{
+			lemma:    table.pool.ID(lemma),
+			upos:     table.pool.ID(upos),
+			pLemma:   table.pool.ID(pLemma),
+			pUpos:    table.pool.ID(pUpos),
+			deprel:   table.pool.ID(deprel),
+			feats:    table.pool.ID(feats),
+			pFeats:   table.pool.ID(pFeats),
+			marker:   table.pool.ID(marker),
+			polarity: table.pool.ID(polarity),
+		},
+		val,
+	)
+}
+
+func (table CounterTable) Len() int {
+	return len(table.items)
+}
+
+// Merge adds all the items of another table
+func (table CounterTable) Merge(other CounterTable) {
+	tr := table.pool.translator(other.pool)
+	for k, v := range other.items {
+		table.add(
+			ctKey{
+				lemma:    tr(k.lemma),
+				upos:     tr(k.upos),
+				pLemma:   tr(k.pLemma),
+				pUpos:    tr(k.pUpos),
+				deprel:   tr(k.deprel),
+				feats:    tr(k.feats),
+				pFeats:   tr(k.pFeats),
+				marker:   tr(k.marker),
+				polarity: tr(k.polarity),
+			},
+			v,
+		)
 	}
-	v.Freq += val
 }
 
-type CoTItem struct {
-	Lemma   string
-	CoLemma string
-	Upos    string
-	CoUpos  string
-	Freq    int64
+// forEach calls `fn` for each item of the table
+func (table CounterTable) forEach(fn func(item CTItem)) {
+	for k, v := range table.items {
+		fn(CTItem{
+			Lemma:    table.pool.Value(k.lemma),
+			Upos:     table.pool.Value(k.upos),
+			PLemma:   table.pool.Value(k.pLemma),
+			PUpos:    table.pool.Value(k.pUpos),
+			Deprel:   table.pool.Value(k.deprel),
+			Feats:    table.pool.Value(k.feats),
+			PFeats:   table.pool.Value(k.pFeats),
+			Marker:   table.pool.Value(k.marker),
+			Polarity: table.pool.Value(k.polarity),
+			Freq:     v,
+		})
+	}
 }
 
-type CoOccTable map[string]*CoTItem
+// records exports table items with fields ordered as
+// lemma, upos, p_lemma, p_upos, deprel, feats, p_feats, marker, polarity so
+// the sorted output can be merge-joined with co-occurrences
+func (table CounterTable) records() []spillRecord {
+	ans := make([]spillRecord, 0, len(table.items))
+	table.forEach(func(v CTItem) {
+		ans = append(
+			ans,
+			spillRecord{
+				Fields: []string{
+					v.Lemma, v.Upos, v.PLemma, v.PUpos, v.Deprel, v.Feats, v.PFeats,
+					v.Marker, v.Polarity},
+				Freq: v.Freq,
+			},
+		)
+	})
+	return ans
+}
 
-func (table CoOccTable) mkKey(lemma, upos, coLemma, coUpos string) string {
-	return fmt.Sprintf("%s:%s::%s:%s", lemma, upos, coLemma, coUpos)
+func newCounterTable(pool *stringPool) CounterTable {
+	return CounterTable{pool: pool, items: make(map[ctKey]int64)}
 }
 
-func (table CoOccTable) Add(lemma, upos, coLemma, coUpos string, val int64) {
-	key := table.mkKey(lemma, upos, coLemma, coUpos)
-	v, ok := table[key]
+// coKey identifies a CoOccTable item by IDs of interned strings
+type coKey struct {
+	lemma   uint32
+	upos    uint32
+	coLemma uint32
+	coUpos  uint32
+}
+
+// CoOccTable contains frequencies of window co-occurrences.
+// Strings are interned in the table's pool.
+type CoOccTable struct {
+	pool  *stringPool
+	items map[coKey]int64
+}
+
+// add adds a value to a respective item and returns an estimated
+// number of bytes the table has grown by
+func (table CoOccTable) add(key coKey, val int64) int {
+	v, ok := table.items[key]
+	table.items[key] = v + val
 	if !ok {
-		v = &CoTItem{
-			Lemma:   lemma,
-			Upos:    upos,
-			CoLemma: coLemma,
-			CoUpos:  coUpos,
-		}
-		table[key] = v
+		return approxTableEntryOverhead
 	}
-	v.Freq += val
+	return 0
+}
+
+// Add adds a value to a respective item and returns an estimated
+// number of bytes the table has grown by (excluding new strings
+// in the pool)
+func (table CoOccTable) Add(lemma, upos, coLemma, coUpos string, val int64) int {
+	return table.add(
+		coKey{
+			lemma:   table.pool.ID(lemma),
+			upos:    table.pool.ID(upos),
+			coLemma: table.pool.ID(coLemma),
+			coUpos:  table.pool.ID(coUpos),
+		},
+		val,
+	)
 }
 
 func (table CoOccTable) Has(lemma, upos, coLemma, coUpos string) bool {
-	key := table.mkKey(lemma, upos, coLemma, coUpos)
-	_, ok := table[key]
+	var key coKey
+	var ok1, ok2, ok3, ok4 bool
+	key.lemma, ok1 = table.pool.lookup(lemma)
+	key.upos, ok2 = table.pool.lookup(upos)
+	key.coLemma, ok3 = table.pool.lookup(coLemma)
+	key.coUpos, ok4 = table.pool.lookup(coUpos)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return false
+	}
+	_, ok := table.items[key]
 	return ok
 }
 
-func expandDeprelMultivalue(value string) []string {
-	ans := make([]string, 0, 2)
-	tmp := strings.Split(value, "|")
-	if len(tmp) > 2 {
-		log.Warn().
-			Str("expression", value).
-			Msg("deprel expression not fully supported")
-		ans = append(ans, tmp...)
+func (table CoOccTable) Len() int {
+	return len(table.items)
+}
+
+// Merge adds all the items of another table
+func (table CoOccTable) Merge(other CoOccTable) {
+	tr := table.pool.translator(other.pool)
+	for k, v := range other.items {
+		table.add(
+			coKey{
+				lemma:   tr(k.lemma),
+				upos:    tr(k.upos),
+				coLemma: tr(k.coLemma),
+				coUpos:  tr(k.coUpos),
+			},
+			v,
+		)
 	}
-	// this along with individual items does not cover whole
-	// expression but it should be ok
-	ans = append(ans, value)
-	return ans
 }
 
-func expandDeprelMultivalues(values []string) []string {
-	ans := make([]string, 0, len(values)+2)
-	for _, v := range values {
-		ans = append(ans, expandDeprelMultivalue(v)...)
+func (table CoOccTable) records() []spillRecord {
+	ans := make([]spillRecord, 0, len(table.items))
+	for k, v := range table.items {
+		ans = append(
+			ans,
+			spillRecord{
+				Fields: []string{
+					table.pool.Value(k.lemma),
+					table.pool.Value(k.upos),
+					table.pool.Value(k.coLemma),
+					table.pool.Value(k.coUpos),
+				},
+				Freq: v,
+			},
+		)
 	}
 	return ans
 }
 
+func newCoOccTable(pool *stringPool) CoOccTable {
+	return CoOccTable{pool: pool, items: make(map[coKey]int64)}
+}
+
+// pairSet is a (possibly probabilistic) set of syntactic pairs
+// we are interested in when counting co-occurrences
+type pairSet interface {
+	Has(lemma, upos, coLemma, coUpos string) bool
+}
+
+// anyPair is a pairSet containing all the possible pairs
+type anyPair struct{}
+
+func (ap anyPair) Has(lemma, upos, coLemma, coUpos string) bool {
+	return true
+}
+
+// CoVertProcessor counts co-occurrences of candidate pairs within
+// a window of a configured span. In case of parallel processing,
+// each worker counts into its own tables.
 type CoVertProcessor struct {
-	Span        int
-	Window      [][2]string
-	conf        *SyntaxProps
-	CoOccTable  CoOccTable
+	Span int
+
+	// Window contains IDs of lemmas and PoS tags of
+	// the most recent tokens (see CoVertProcessor.pool)
+	Window [][2]uint32
+	conf   *SyntaxProps
+
+	// Candidates specifies pairs we want to count co-occurrences for
+	Candidates pairSet
+
+	CoOccTable CoOccTable
+
+	// TokenCounts contains frequencies of all the tokens. We need
+	// the complete vocabulary so it is possible to recompute
+	// co-occurrence scores once new data are appended.
+	// Please note that TokenCounts are never spilled to disk
+	// as their size is limited by the corpus vocabulary.
 	TokenCounts FyTable
+
+	// NumTokens is a number of processed tokens (including
+	// the malformed ones)
+	NumTokens int64
+
+	// NumSentences is a number of sentence structures
+	// (see SyntaxProps.SentenceStruct)
+	NumSentences int64
+
+	// NumDocs is a number of document structures
+	// (see SyntaxProps.DocStruct)
+	NumDocs int64
+
+	// pool contains strings of both the CoOccTable
+	// and the TokenCounts
+	pool       *stringPool
+	budget     *memoryBudget
+	coOccSpill *spillStore
+	numOverrun int
+
+	// onBudgetExceeded handles the exceeded memory budget
+	// (spilling the co-occurrence table by default)
+	onBudgetExceeded func() error
+
+	// validator is used only to skip malformed tokens the same
+	// way VertProcessor does (errors are reported by VertProcessor)
+	validator *vertValidator
+
+	// lemmas provides IDs of normalized lemmas
+	// (see SyntaxProps.Normalization)
+	lemmas *normalizedLemmas
 }
 
-func (cvp *CoVertProcessor) ProcToken(token *vertigo.Token, line int, err error) error {
-	if err != nil {
+func (cvp *CoVertProcessor) size() CorpusSize {
+	return CorpusSize{
+		NumTokens:    cvp.NumTokens,
+		NumSentences: cvp.NumSentences,
+		NumDocs:      cvp.NumDocs,
+	}
+}
+
+func (cvp *CoVertProcessor) tableSizes() map[string]int {
+	return map[string]int{
+		"coOccs":     cvp.CoOccTable.Len(),
+		"tokenFreqs": cvp.TokenCounts.Len(),
+	}
+}
+
+func (cvp *CoVertProcessor) spill() error {
+	log.Info().
+		Int("size", cvp.CoOccTable.Len()).
+		Msg("memory budget exceeded, spilling cooccurrence table to disk")
+	if err := cvp.coOccSpill.WriteRun(cvp.CoOccTable.records()); err != nil {
 		return err
 	}
-	if len(token.Attrs) < 12 {
-		log.Error().Msgf("Too few token columns on line %d", line)
-		return nil
+	// the pool is kept as it is shared with TokenCounts
+	// (its size is limited by the corpus vocabulary)
+	cvp.CoOccTable = newCoOccTable(cvp.pool)
+	cvp.budget.Reset()
+	return nil
+}
+
+// procToken adds a token to the window and counts co-occurrences
+// of the window middle. Token frequencies are counted only if
+// the token belongs to the processed chunk (i.e. it is not an overrun).
+// The method returns true if the token has been accepted.
+func (cvp *CoVertProcessor) procToken(token *vertigo.Token, line int, isOverrun bool) (bool, error) {
+	if !isOverrun {
+		cvp.NumTokens++
 	}
-	lemma := token.Attrs[cvp.conf.LemmaAttr.VerticalCol-1]
-	upos := token.Attrs[cvp.conf.PosAttr.VerticalCol-1]
-	if cvp.TokenCounts.Has(lemma, upos, "") {
-		cvp.TokenCounts.Add(lemma, upos, "", 1)
+	if category, _ := cvp.validator.checkColumns(token, cvp.conf.LemmaAttr.VerticalCol); category != "" {
+		return false, nil
+	}
+	lemma := cvp.lemmas.ID(token.Attrs[cvp.conf.LemmaAttr.VerticalCol-1])
+	upos := cvp.pool.ID(token.Attrs[cvp.conf.PosAttr.VerticalCol-1])
+	if !isOverrun {
+		cvp.TokenCounts.add(fyKey{lemma: lemma, upos: upos, deprel: emptyStringID}, 1)
 	}
 
 	if len(cvp.Window) == 2*cvp.Span+1 {
-		cvp.Window = append(cvp.Window[1:], [2]string{lemma, upos})
+		cvp.Window = append(cvp.Window[1:], [2]uint32{lemma, upos})
 	} else {
-		cvp.Window = append(cvp.Window, [2]string{lemma, upos})
+		cvp.Window = append(cvp.Window, [2]uint32{lemma, upos})
 	}
 
 	if len(cvp.Window) == 2*cvp.Span+1 {
 		middle := cvp.Window[cvp.Span]
 		for i, near := range cvp.Window {
-			if i != cvp.Span && cvp.CoOccTable.Has(middle[0], middle[1], near[0], near[1]) {
-				cvp.CoOccTable.Add(middle[0], middle[1], near[0], near[1], 1)
+			if i == cvp.Span {
+				continue
+			}
+			isCandidate := cvp.Candidates.Has(
+				cvp.pool.Value(middle[0]),
+				cvp.pool.Value(middle[1]),
+				cvp.pool.Value(near[0]),
+				cvp.pool.Value(near[1]),
+			)
+			if isCandidate {
+				key := coKey{lemma: middle[0], upos: middle[1], coLemma: near[0], coUpos: near[1]}
+				cvp.budget.Add(cvp.CoOccTable.add(key, 1))
 			}
 		}
 	}
-	return nil
+	cvp.budget.Add(cvp.pool.takeGrowth())
+	if cvp.budget.Exceeded() {
+		return true, cvp.onBudgetExceeded()
+	}
+	return true, nil
+}
+
+func (cvp *CoVertProcessor) ProcToken(token *vertigo.Token, line int, err error) error {
+	if err != nil {
+		return err
+	}
+	if err := cvp.validator.Err(); err != nil {
+		return err
+	}
+	_, err = cvp.procToken(token, line, false)
+	return err
+}
+
+// ProcOverrunToken reads up to 2 * span tokens after the end of a chunk.
+// As the window middle always lags `span` tokens behind the last token,
+// this makes the chunk count exactly the window middles from
+// <chunk start + span, chunk end + span) which is the same as
+// a sequential processing would do (the next chunk starts counting
+// from its start + span).
+func (cvp *CoVertProcessor) ProcOverrunToken(token *vertigo.Token, line int) (bool, error) {
+	if !cvp.acceptsOverrun() {
+		return false, nil
+	}
+	accepted, err := cvp.procToken(token, line, true)
+	if accepted {
+		cvp.numOverrun++
+	}
+	return cvp.acceptsOverrun(), err
+}
+
+// acceptsOverrun tells whether tokens after the end
+// of a chunk are still needed
+func (cvp *CoVertProcessor) acceptsOverrun() bool {
+	return cvp.numOverrun < 2*cvp.Span
 }
 
 func (cvp *CoVertProcessor) ProcStruct(strc *vertigo.Structure, line int, err error) error {
+	if strc == nil {
+		return nil
+	}
+	switch strc.Name {
+	case cvp.conf.SentenceStruct:
+		cvp.NumSentences++
+	case cvp.conf.DocStruct:
+		cvp.NumDocs++
+	}
 	return nil
 }
 
@@ -204,273 +573,1300 @@ func (cvp *CoVertProcessor) ProcStructClose(strc *vertigo.StructureClose, line i
 
 type VertProcessor struct {
 	DeprelCol    int
-	DeprelTypes  []string
 	conf         *SyntaxProps
 	Table        CounterTable
 	ParentCounts FyTable
 	ChildCounts  FyTable
+
+	// Triples contains head-centered triples (see TriplePattern)
+	Triples TripleTable
+
+	// pool contains strings of all the tables
+	pool        *stringPool
+	budget      *memoryBudget
+	tableSpill  *spillStore
+	parentSpill *spillStore
+	childSpill  *spillStore
+	tripleSpill *spillStore
+
+	validator *vertValidator
+	sentence  sentenceParents
+	sentBuf   sentenceBuffer
+
+	// relations selects counted relations of tokens
+	relations *relationCache
+
+	// coordinations selects relations of coordinated tokens
+	// (see SyntaxProps.ConjValue, it matches nothing if not configured)
+	coordinations *relationCache
+
+	// deepRelations resolves deep relations of verb arguments
+	// (nil if they are not counted, see SyntaxProps.DeepRelations)
+	deepRelations *deepRelationCache
+
+	// multiwords selects relations of multiword expressions
+	// (nil if they are not merged, see SyntaxProps.MergeMultiwords)
+	multiwords *relationCache
+
+	// heads is a reusable buffer of multiword expression heads
+	heads []int
+
+	// lemmas provides IDs of normalized lemmas
+	// (see SyntaxProps.Normalization)
+	lemmas *normalizedLemmas
+
+	// polarity resolves polarity of verbs (nil if not
+	// configured, see SyntaxProps.Polarity)
+	polarity *polarityDetector
+
+	tripleMatchers []tripleMatcher
+
+	// children is a reusable buffer of sentence trees
+	children [][]int
+}
+
+func (vp *VertProcessor) tableSizes() map[string]int {
+	return map[string]int{
+		"fcolls":     vp.Table.Len(),
+		"parentSums": vp.ParentCounts.Len(),
+		"childSums":  vp.ChildCounts.Len(),
+		"triples":    vp.Triples.Len(),
+	}
+}
+
+func (vp *VertProcessor) spill() error {
+	log.Info().
+		Int("size", vp.Table.Len()).
+		Msg("memory budget exceeded, spilling collocation tables to disk")
+	if err := vp.tableSpill.WriteRun(vp.Table.records()); err != nil {
+		return err
+	}
+	if err := vp.parentSpill.WriteRun(vp.ParentCounts.records()); err != nil {
+		return err
+	}
+	if err := vp.childSpill.WriteRun(vp.ChildCounts.records()); err != nil {
+		return err
+	}
+	if err := vp.tripleSpill.WriteRun(vp.Triples.records()); err != nil {
+		return err
+	}
+	// the pool is kept as its size is limited
+	// by the corpus vocabulary
+	vp.Table = newCounterTable(vp.pool)
+	vp.ParentCounts = newFyTable(vp.pool)
+	vp.ChildCounts = newFyTable(vp.pool)
+	vp.Triples = newTripleTable(vp.pool)
+	vp.budget.Reset()
+	return nil
 }
 
 func (vp *VertProcessor) ProcToken(token *vertigo.Token, line int, err error) error {
 	if err != nil {
 		return err
 	}
-	if len(token.Attrs) < 12 {
-		log.Error().Msgf("Too few token columns on line %d", line)
-		return nil
+	if err := vp.validator.Err(); err != nil {
+		return err
+	}
+	ok, err := vp.validator.validateToken(token, line, vp.conf, &vp.sentence)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return vp.sentBuf.skip(line)
 	}
+	return vp.addToken(token, line)
+}
+
+// addToken adds a token to the sentence buffer.
+// The token's columns must be already checked.
+func (vp *VertProcessor) addToken(token *vertigo.Token, line int) error {
 	// below, we index always [k-1] because `word` in Vertigo is separated
-	deprelTmp := token.Attrs[vp.conf.FuncAttr.VerticalCol-1]
-	lemma := token.Attrs[vp.conf.LemmaAttr.VerticalCol-1]
-	upos := token.Attrs[vp.conf.PosAttr.VerticalCol-1]
-	pLemma := token.Attrs[vp.conf.ParLemmaAttr.VerticalCol-1]
-	pUpos := token.Attrs[vp.conf.ParPosAttr.VerticalCol-1]
-	for _, deprel := range expandDeprelMultivalue(deprelTmp) {
-		if collections.SliceContains(vp.DeprelTypes, deprel) {
-			vp.Table.Add(lemma, upos, pLemma, pUpos, deprel, 1)
-			vp.ParentCounts.Add(pLemma, pUpos, deprel, 1)
-			vp.ChildCounts.Add(lemma, upos, deprel, 1)
+	return vp.sentBuf.add(
+		sentToken{
+			lemma:   vp.lemmas.ID(token.Attrs[vp.conf.LemmaAttr.VerticalCol-1]),
+			upos:    vp.pool.ID(token.Attrs[vp.conf.PosAttr.VerticalCol-1]),
+			pLemma:  vp.lemmas.ID(token.Attrs[vp.conf.ParLemmaAttr.VerticalCol-1]),
+			pUpos:   vp.pool.ID(token.Attrs[vp.conf.ParPosAttr.VerticalCol-1]),
+			deprel:  vp.pool.ID(token.Attrs[vp.conf.FuncAttr.VerticalCol-1]),
+			feats:   vp.pool.ID(vp.tokenFeats(token, vp.conf.FeatsAttr)),
+			pFeats:  vp.pool.ID(vp.tokenFeats(token, vp.conf.ParFeatsAttr)),
+			negated: vp.tokenHasFeat(token, vp.conf.Polarity.NegFeature),
+			passive: vp.conf.DeepRelations && vp.tokenHasFeat(token, vp.conf.PassiveFeature),
+		},
+		token.Attrs[vp.conf.ParentIdxAttr.VerticalCol-1],
+		line,
+	)
+}
+
+// procSentence counts syntactic pairs and triples
+// of a complete sentence
+func (vp *VertProcessor) procSentence(tokens []sentToken) error {
+	if vp.multiwords != nil {
+		vp.heads = mergeMultiwords(tokens, vp.pool, vp.multiwords, vp.heads)
+	}
+	markers := caseMarkers(tokens, vp.pool, vp.conf.CaseValue)
+	var polarities []uint32
+	if vp.polarity != nil {
+		polarities = vp.polarity.polarities(tokens)
+	}
+	var ccMarkers []uint32
+	for i, tk := range tokens {
+		if !tk.valid {
+			continue
+		}
+		var polarity uint32
+		if polarities != nil && tk.parent >= 0 {
+			polarity = polarities[tk.parent]
+		}
+		for _, deprel := range vp.relations.relations(tk.deprel) {
+			vp.countPair(
+				ctKey{
+					lemma:    tk.lemma,
+					upos:     tk.upos,
+					pLemma:   tk.pLemma,
+					pUpos:    tk.pUpos,
+					deprel:   deprel,
+					feats:    tk.feats,
+					pFeats:   tk.pFeats,
+					marker:   markers[i],
+					polarity: polarity,
+				},
+			)
+		}
+		if vp.deepRelations != nil {
+			if deprel := vp.deepRelations.tokenRelation(tokens, i); deprel != emptyStringID {
+				vp.countPair(
+					ctKey{
+						lemma:    tk.lemma,
+						upos:     tk.upos,
+						pLemma:   tk.pLemma,
+						pUpos:    tk.pUpos,
+						deprel:   deprel,
+						feats:    tk.feats,
+						pFeats:   tk.pFeats,
+						marker:   markers[i],
+						polarity: polarity,
+					},
+				)
+			}
+		}
+		if tk.upos != tk.pUpos {
+			continue
+		}
+		for _, deprel := range vp.coordinations.relations(tk.deprel) {
+			if ccMarkers == nil {
+				ccMarkers = caseMarkers(tokens, vp.pool, vp.conf.CcValue)
+			}
+			// coordination is symmetric so both word orders are counted
+			vp.countPair(
+				ctKey{
+					lemma:  tk.lemma,
+					upos:   tk.upos,
+					pLemma: tk.pLemma,
+					pUpos:  tk.pUpos,
+					deprel: deprel,
+					feats:  tk.feats,
+					pFeats: tk.pFeats,
+					marker: ccMarkers[i],
+				},
+			)
+			vp.countPair(
+				ctKey{
+					lemma:  tk.pLemma,
+					upos:   tk.pUpos,
+					pLemma: tk.lemma,
+					pUpos:  tk.upos,
+					deprel: deprel,
+					feats:  tk.pFeats,
+					pFeats: tk.feats,
+					marker: ccMarkers[i],
+				},
+			)
 		}
 	}
-	//useFirstNonWordPosAttr(tokenAttrs[0])
+	vp.procTriples(tokens, markers)
+	vp.budget.Add(vp.pool.takeGrowth())
+	if vp.budget.Exceeded() {
+		return vp.spill()
+	}
+	return nil
+}
 
+// countPair adds a single occurrence of a pair along with
+// its parent and child
+func (vp *VertProcessor) countPair(key ctKey) {
+	vp.budget.Add(vp.Table.add(key, 1))
+	vp.budget.Add(
+		vp.ParentCounts.add(
+			fyKey{
+				lemma:    key.pLemma,
+				upos:     key.pUpos,
+				deprel:   key.deprel,
+				feats:    key.pFeats,
+				marker:   key.marker,
+				polarity: key.polarity,
+			},
+			1,
+		),
+	)
+	vp.budget.Add(
+		vp.ChildCounts.add(
+			fyKey{
+				lemma:    key.lemma,
+				upos:     key.upos,
+				deprel:   key.deprel,
+				feats:    key.feats,
+				marker:   key.marker,
+				polarity: key.polarity,
+			},
+			1,
+		),
+	)
+}
+
+// tokenFeats returns configured features found in a token's
+// attribute (an empty string if the attribute is not configured)
+func (vp *VertProcessor) tokenFeats(token *vertigo.Token, attr PosAttrProps) string {
+	if attr.VerticalCol == 0 {
+		return ""
+	}
+	return selectFeats(token.Attrs[attr.VerticalCol-1], vp.conf.Features)
+}
+
+// tokenHasFeat tests whether a token's features contain a feature
+// (false if the feature or the features attribute is not configured)
+func (vp *VertProcessor) tokenHasFeat(token *vertigo.Token, feat string) bool {
+	if feat == "" || vp.conf.FeatsAttr.VerticalCol == 0 {
+		return false
+	}
+	return hasFeat(token.Attrs[vp.conf.FeatsAttr.VerticalCol-1], feat)
+}
+
+// ProcOverrunToken reads tokens after the end of a chunk
+// until the last sentence of the chunk is complete
+// (see sentenceBuffer)
+func (vp *VertProcessor) ProcOverrunToken(token *vertigo.Token, line int) (bool, error) {
+	more, err := vp.sentBuf.overrunLine(line, false)
+	if !more || err != nil {
+		return false, err
+	}
+	if category, _ := vp.validator.checkColumns(token, vp.conf.LemmaAttr.VerticalCol); category != "" {
+		err = vp.sentBuf.skip(line)
+
+	} else {
+		err = vp.addToken(token, line)
+	}
+	return err == nil, err
+}
+
+func (vp *VertProcessor) ProcOverrunStruct(strc *vertigo.Structure, line int) (bool, error) {
+	return vp.sentBuf.overrunLine(line, strc.Name == vp.conf.SentenceStruct && !strc.IsEmpty)
+}
+
+func (vp *VertProcessor) ProcOverrunStructClose(strc *vertigo.StructureClose, line int) (bool, error) {
+	return vp.sentBuf.overrunLine(line, strc.Name == vp.conf.SentenceStruct)
+}
+
+// StartChunk prepares the processor for a chunk of a vertical file
+// (see sentenceBuffer)
+func (vp *VertProcessor) StartChunk(chunk vertChunk) error {
+	vp.sentBuf.startChunk(chunk.start > 0)
 	return nil
 }
 
+func (vp *VertProcessor) FinishChunk() error {
+	return vp.sentBuf.finish()
+}
+
 func (vp *VertProcessor) ProcStruct(strc *vertigo.Structure, line int, err error) error {
+	if err := vp.sentBuf.checkSplit(line); err != nil {
+		return err
+	}
+	if strc != nil && strc.Name == vp.conf.SentenceStruct && !strc.IsEmpty {
+		vp.sentence.Reset(true)
+		return vp.sentBuf.open()
+	}
 	return nil
 }
 
 func (vp *VertProcessor) ProcStructClose(strc *vertigo.StructureClose, line int, err error) error {
+	if err := vp.sentBuf.checkSplit(line); err != nil {
+		return err
+	}
+	if strc != nil && strc.Name == vp.conf.SentenceStruct {
+		if err := vp.validator.closeSentence(&vp.sentence); err != nil {
+			return err
+		}
+		return vp.sentBuf.close()
+	}
 	return nil
 }
 
-func writeFxy(tx *sql.Tx, table CounterTable, coOccTable CoOccTable, tokenCounts FyTable, corpusID string) error {
-	var i int
-	args := make([]any, 0, bulkInsertChunkSize*7)
-	insertPlaceholders := make([]string, 0, bulkInsertChunkSize)
+// singlePassProcessor combines VertProcessor and CoVertProcessor
+// so both syntactic pairs and window co-occurrences can be
+// counted within a single pass over a vertical file.
+//
+// As co-occurrences have to be counted for all the pairs within
+// windows, they may easily outgrow the syntactic pairs. Pruning them
+// during the pass is not possible (a pair may be attested later) so
+// once the co-occurrences exceed the memory budget of any worker,
+// all the workers stop counting them and they are counted for
+// the attested pairs in a second pass instead (see countSinglePass).
+// Without a memory limit, the co-occurrences are kept in memory.
+type singlePassProcessor struct {
+	syntax *VertProcessor
+	coOcc  *CoVertProcessor
 
-	for _, v := range table {
-		if i == bulkInsertChunkSize {
-			sql := fmt.Sprintf(
-				"INSERT INTO %s_fcolls (lemma, upos, p_lemma, p_upos, deprel, freq, co_occurrence_score) VALUES %s",
-				corpusID, strings.Join(insertPlaceholders, ", "))
-			_, err := tx.Exec(sql, args...)
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-			args = make([]any, 0, bulkInsertChunkSize*7)
-			insertPlaceholders = make([]string, 0, bulkInsertChunkSize)
-			i = 0
-			log.Debug().Int("items", bulkInsertChunkSize).Msg("written Fxy bulk into database")
-		}
+	// overBudget is shared by all the workers
+	overBudget *atomic.Bool
+}
 
-		fxy := coOccTable[coOccTable.mkKey(v.Lemma, v.Upos, v.PLemma, v.PUpos)]
-		fx := tokenCounts[tokenCounts.mkKey(v.Lemma, v.Upos, "")]
-		fy := tokenCounts[tokenCounts.mkKey(v.PLemma, v.PUpos, "")]
-		logDice := 14 + math.Log2(2*float64(fxy.Freq)/float64(fx.Freq+fy.Freq))
+// countsCoOccs tells whether co-occurrences are still counted
+func (spp *singlePassProcessor) countsCoOccs() bool {
+	return !spp.overBudget.Load()
+}
 
-		// Replace SQL invalid float values
-		if math.IsInf(logDice, 1) {
-			logDice = 3.4e38 // Substitute Inf with max float
-		} else if math.IsInf(logDice, -1) {
-			logDice = -3.4e38 // Substitute -Inf with min float
-		} else if math.IsNaN(logDice) {
-			logDice = 0 // Substitute NaN with 0
-		}
+func (spp *singlePassProcessor) tableSizes() map[string]int {
+	ans := spp.syntax.tableSizes()
+	for k, v := range spp.coOcc.tableSizes() {
+		ans[k] = v
+	}
+	return ans
+}
+
+func (spp *singlePassProcessor) ProcToken(token *vertigo.Token, line int, err error) error {
+	if err := spp.syntax.ProcToken(token, line, err); err != nil {
+		return err
+	}
+	if !spp.countsCoOccs() {
+		return nil
+	}
+	return spp.coOcc.ProcToken(token, line, err)
+}
 
-		args = append(args, v.Lemma, v.Upos, v.PLemma, v.PUpos, v.Deprel, v.Freq, logDice)
-		insertPlaceholders = append(insertPlaceholders, "(?, ?, ?, ?, ?, ?, ?)")
-		i++
+func (spp *singlePassProcessor) ProcOverrunToken(token *vertigo.Token, line int) (bool, error) {
+	syntaxMore, err := spp.syntax.ProcOverrunToken(token, line)
+	if err != nil || !spp.countsCoOccs() {
+		return syntaxMore, err
 	}
+	coOccMore, err := spp.coOcc.ProcOverrunToken(token, line)
+	return syntaxMore || coOccMore, err
+}
 
-	if len(args) > 0 {
-		sql := fmt.Sprintf(
-			"INSERT INTO %s_fcolls (lemma, upos, p_lemma, p_upos, deprel, freq, co_occurrence_score) VALUES %s",
-			corpusID, strings.Join(insertPlaceholders, ", "))
-		_, err := tx.Exec(sql, args...)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		log.Debug().Int("items", len(insertPlaceholders)).Msg("written Fxy bulk into database")
+func (spp *singlePassProcessor) ProcOverrunStruct(strc *vertigo.Structure, line int) (bool, error) {
+	syntaxMore, err := spp.syntax.ProcOverrunStruct(strc, line)
+	return syntaxMore || spp.countsCoOccs() && spp.coOcc.acceptsOverrun(), err
+}
+
+func (spp *singlePassProcessor) ProcOverrunStructClose(strc *vertigo.StructureClose, line int) (bool, error) {
+	syntaxMore, err := spp.syntax.ProcOverrunStructClose(strc, line)
+	return syntaxMore || spp.countsCoOccs() && spp.coOcc.acceptsOverrun(), err
+}
+
+// stopCoOccs handles the exceeded memory budget of co-occurrences
+// by stopping their counting in all the workers
+func (spp *singlePassProcessor) stopCoOccs() error {
+	if !spp.overBudget.Swap(true) {
+		log.Info().
+			Int("size", spp.coOcc.CoOccTable.Len()).
+			Msg("memory budget exceeded by window co-occurrences, " +
+				"they will be counted for found pairs in a second pass")
 	}
+	spp.coOcc.CoOccTable = newCoOccTable(spp.coOcc.pool)
+	spp.coOcc.budget.Reset()
 	return nil
 }
 
-func writeParents(tx *sql.Tx, table FyTable, corpusID string) error {
-	var i int
-	args := make([]any, 0, bulkInsertChunkSize*4)
-	insertPlaceholders := make([]string, 0, bulkInsertChunkSize)
+func (spp *singlePassProcessor) StartChunk(chunk vertChunk) error {
+	return spp.syntax.StartChunk(chunk)
+}
 
-	for _, v := range table {
-		if i == bulkInsertChunkSize {
-			sql := fmt.Sprintf(
-				"INSERT INTO %s_parent_sums (p_lemma, p_upos, deprel, freq) VALUES %s",
-				corpusID, strings.Join(insertPlaceholders, ", "))
-			_, err := tx.Exec(sql, args...)
+func (spp *singlePassProcessor) FinishChunk() error {
+	return spp.syntax.FinishChunk()
+}
+
+func (spp *singlePassProcessor) ProcStruct(strc *vertigo.Structure, line int, err error) error {
+	if err := spp.syntax.ProcStruct(strc, line, err); err != nil {
+		return err
+	}
+	return spp.coOcc.ProcStruct(strc, line, err)
+}
+
+func (spp *singlePassProcessor) ProcStructClose(strc *vertigo.StructureClose, line int, err error) error {
+	if err := spp.syntax.ProcStructClose(strc, line, err); err != nil {
+		return err
+	}
+	return spp.coOcc.ProcStructClose(strc, line, err)
+}
+
+// bulkExecer is a target of bulk inserts (typically *sql.Tx)
+type bulkExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Rollback() error
+}
+
+var (
+	fcollsColumns = []string{
+		"lemma", "upos", "p_lemma", "p_upos", "deprel", "feats", "p_feats", "marker",
+		"polarity", "freq", "co_occurrence_freq", "co_occurrence_score",
+	}
+	childSumsColumns = []string{
+		"lemma", "upos", "deprel", "feats", "marker", "polarity", "freq"}
+	parentSumsColumns = []string{
+		"p_lemma", "p_upos", "deprel", "p_feats", "marker", "polarity", "freq"}
+	tokenFreqsColumns = []string{"lemma", "upos", "freq"}
+)
+
+// sqlFloat replaces float values invalid in SQL
+func sqlFloat(v float64) float64 {
+	if math.IsInf(v, 1) {
+		return 3.4e38 // Substitute Inf with max float
+
+	} else if math.IsInf(v, -1) {
+		return -3.4e38 // Substitute -Inf with min float
+
+	} else if math.IsNaN(v) {
+		return 0 // Substitute NaN with 0
+	}
+	return v
+}
+
+// writeFxy writes syntactic collocations along with their co-occurrence
+// scores. Both `pairs` and `coOccs` must be sorted the same way (see
+// `CounterTable.records()`) so they can be merge-joined. The sink
+// must have fcollsColumns. Pairs without known token frequencies
+// get NULL scores.
+func writeFxy(sink rowSink, pairs, coOccs recordIterator, tokenCounts FyTable) (int64, error) {
+	var coOcc *spillRecord
+	coOccDone := false
+
+	for {
+		rec, err := pairs.Next()
+		if err != nil {
+			sink.Abort()
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		v := CTItem{
+			Lemma:    rec.Fields[0],
+			Upos:     rec.Fields[1],
+			PLemma:   rec.Fields[2],
+			PUpos:    rec.Fields[3],
+			Deprel:   rec.Fields[4],
+			Feats:    rec.Fields[5],
+			PFeats:   rec.Fields[6],
+			Marker:   rec.Fields[7],
+			Polarity: rec.Fields[8],
+			Freq:     rec.Freq,
+		}
+
+		for !coOccDone && (coOcc == nil || compareFields(coOcc.Fields, rec.Fields[:4]) < 0) {
+			coOcc, err = coOccs.Next()
 			if err != nil {
-				tx.Rollback()
-				return err
+				sink.Abort()
+				return 0, err
 			}
-			args = make([]any, 0, bulkInsertChunkSize*4)
-			insertPlaceholders = make([]string, 0, bulkInsertChunkSize)
-			i = 0
-			log.Debug().Int("items", bulkInsertChunkSize).Msg("written parent Fy bulk into database")
+			coOccDone = coOcc == nil
+		}
+		var fxy int64
+		if coOcc != nil && compareFields(coOcc.Fields, rec.Fields[:4]) == 0 {
+			fxy = coOcc.Freq
+		}
+		fx := tokenCounts.Get(v.Lemma, v.Upos, "")
+		fy := tokenCounts.Get(v.PLemma, v.PUpos, "")
+		// Co-occurrences and token frequencies are counted for single
+		// tokens only so composite lemmas of multiword expressions
+		// (see mergeMultiwords) have no frequencies and their pairs
+		// are not scored (NULL is ignored by MAX() so such pairs never
+		// outrank scored ones).
+		var logDice any
+		if fx > 0 && fy > 0 {
+			logDice = sqlFloat(14 + math.Log2(2*float64(fxy)/float64(fx+fy)))
 		}
 
-		args = append(args, v.Lemma, v.Upos, v.Deprel, v.Freq)
-		insertPlaceholders = append(insertPlaceholders, "(?, ?, ?, ?)")
-		i++
+		err = sink.Add(
+			v.Lemma, v.Upos, v.PLemma, v.PUpos, v.Deprel, v.Feats, v.PFeats, v.Marker,
+			v.Polarity, v.Freq, fxy, logDice)
+		if err != nil {
+			return 0, err
+		}
 	}
+	return sink.Finish()
+}
 
-	if len(args) > 0 {
-		sql := fmt.Sprintf(
-			"INSERT INTO %s_parent_sums (p_lemma, p_upos, deprel, freq) VALUES %s",
-			corpusID, strings.Join(insertPlaceholders, ", "))
-		_, err := tx.Exec(sql, args...)
+// writeRecords writes records into a sink. The sink's columns correspond
+// to the record fields (excess fields are ignored) followed by
+// the `freq` column.
+func writeRecords(sink rowSink, records recordIterator) (int64, error) {
+	numFields := sink.numColumns() - 1
+	values := make([]any, numFields+1)
+	for {
+		rec, err := records.Next()
 		if err != nil {
-			tx.Rollback()
-			return err
+			sink.Abort()
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		for i, v := range rec.Fields[:numFields] {
+			values[i] = v
+		}
+		values[numFields] = rec.Freq
+		if err := sink.Add(values...); err != nil {
+			return 0, err
 		}
-		log.Debug().Int("items", len(insertPlaceholders)).Msg("written parent Fy bulk into database")
 	}
-	return nil
+	return sink.Finish()
 }
 
-func writeChildren(tx *sql.Tx, table FyTable, corpusID string) error {
-	var i int
-	args := make([]any, 0, bulkInsertChunkSize*4)
-	insertPlaceholders := make([]string, 0, bulkInsertChunkSize)
+// ImportOptions contains parameters of the import process
+// which are not part of the corpus configuration
+type ImportOptions struct {
+
+	// CoOccSpan defines window size for calculating co-occurrences
+	CoOccSpan int
+
+	// MemoryLimitMB is an approximate memory budget for counting
+	// tables. Once exceeded, partial counts are written to sorted
+	// run files in SpillDir and merged at the end of the import.
+	// Zero means no limit.
+	MemoryLimitMB int
+
+	// SpillDir is a directory for temporary run files. If empty,
+	// the system temporary directory is used.
+	SpillDir string
+
+	// NumWorkers specifies how many chunks of the vertical file
+	// are processed in parallel. The memory budget is shared
+	// among the workers.
+	NumWorkers int
+
+	// SinglePass if true then syntactic pairs, token frequencies
+	// and window co-occurrences are counted in a single sweep
+	// over the vertical file. This is faster but co-occurrences
+	// have to be counted for all the pairs within windows (they
+	// are pruned once all the syntactic pairs are known) which
+	// requires much more memory. In case MemoryLimitMB is set
+	// and the co-occurrences exceed it, the import falls back
+	// to counting them in a second pass (see singlePassProcessor).
+	SinglePass bool
+
+	// Append if true then counts of the vertical file are added
+	// to the existing live tables instead of replacing them
+	// (see runAppend for details).
+	Append bool
+
+	// Location is a time zone used for timestamps stored
+	// in import metadata. If nil, the local time zone is used.
+	Location *time.Location
+
+	// AppVersion is a version of scollex stored in import metadata
+	AppVersion string
+
+	// MaxNumErrors specifies how many malformed lines of a vertical
+	// file are tolerated before the import is aborted. A negative
+	// value means no limit.
+	MaxNumErrors int
+
+	// ValidationReport is a path of a JSON file a summary of
+	// found errors is written to. If empty, the summary is only logged.
+	ValidationReport string
+
+	// StatusFile is a path of a JSON file the import status
+	// (see ImportStatus) is periodically written to. If empty,
+	// the status is only logged.
+	StatusFile string
+
+	// ProgressInterval specifies how often the import progress
+	// is reported (default: 10s)
+	ProgressInterval time.Duration
+
+	// CheckpointDir is a directory where results of finished phases
+	// of a full import are stored. If empty, a `scollex-checkpoint-[corpus]`
+	// directory in SpillDir (or in the system temporary directory)
+	// is used.
+	CheckpointDir string
+
+	// Resume if true then a full import continues from the last
+	// checkpoint (if found) instead of starting from scratch.
+	Resume bool
+
+	// BulkLoad if true then table rows are written into temporary TSV
+	// files (in SpillDir) and loaded using LOAD DATA LOCAL INFILE instead
+	// of INSERT statements. Secondary indexes of staging tables are created
+	// once the data are loaded. The database server must allow loading
+	// of local files.
+	BulkLoad bool
+}
+
+// importSpills groups spill stores of all the counting tables
+type importSpills struct {
+	table    *spillStore
+	parents  *spillStore
+	children *spillStore
+	triples  *spillStore
+	coOccs   *spillStore
+}
+
+func (is *importSpills) Close() {
+	for _, store := range []*spillStore{is.table, is.parents, is.children, is.triples, is.coOccs} {
+		if err := store.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to remove spilled data")
+		}
+	}
+}
+
+func newImportSpills(dir, corpusID string) *importSpills {
+	return &importSpills{
+		table:    newSpillStore(dir, corpusID+"-fcolls"),
+		parents:  newSpillStore(dir, corpusID+"-parents"),
+		children: newSpillStore(dir, corpusID+"-children"),
+		triples:  newSpillStore(dir, corpusID+"-triples"),
+		coOccs:   newSpillStore(dir, corpusID+"-cooccs"),
+	}
+}
+
+func newVertProcessor(
+	conf *SyntaxProps,
+	spills *importSpills,
+	budget *memoryBudget,
+	validator *vertValidator,
+) *VertProcessor {
+	pool := newStringPool()
+	ans := &VertProcessor{
+		conf:         conf,
+		Table:        newCounterTable(pool),
+		ParentCounts: newFyTable(pool),
+		ChildCounts:  newFyTable(pool),
+		Triples:      newTripleTable(pool),
+		pool:         pool,
+		budget:       budget,
+		tableSpill:   spills.table,
+		parentSpill:  spills.parents,
+		childSpill:   spills.children,
+		tripleSpill:  spills.triples,
+		validator:    validator,
+		relations: newRelationCache(
+			newRelationMatcher(
+				[]string{
+					conf.NounModifiedValue,
+					conf.NounSubjectValue,
+					conf.NounObjectValue,
+					conf.ObliqueValue,
+				},
+				conf.CollapseSubtypes,
+			),
+			pool,
+		),
+		coordinations: newRelationCache(
+			newRelationMatcher([]string{conf.ConjValue}, conf.CollapseSubtypes),
+			pool,
+		),
+		tripleMatchers: newTripleMatchers(conf, pool),
+		lemmas:         newNormalizedLemmas(conf, pool),
+	}
+	if conf.MergeMultiwords {
+		// subtypes do not matter here
+		ans.multiwords = newRelationCache(
+			newRelationMatcher([]string{conf.MultiwordValue}, true), pool)
+	}
+	if conf.DeepRelations {
+		ans.deepRelations = newDeepRelationCache(conf, pool)
+	}
+	ans.polarity = newPolarityDetector(conf, pool, ans.lemmas)
+	ans.sentBuf.onSentence = ans.procSentence
+	return ans
+}
+
+func newCoVertProcessor(
+	conf *SyntaxProps,
+	span int,
+	candidates pairSet,
+	spills *importSpills,
+	budget *memoryBudget,
+	validator *vertValidator,
+) *CoVertProcessor {
+	pool := newStringPool()
+	ans := &CoVertProcessor{
+		Span:        span,
+		conf:        conf,
+		Candidates:  candidates,
+		CoOccTable:  newCoOccTable(pool),
+		TokenCounts: newFyTable(pool),
+		Window:      make([][2]uint32, 0, 2*span+1),
+		pool:        pool,
+		budget:      budget,
+		coOccSpill:  spills.coOccs,
+		validator:   validator,
+		lemmas:      newNormalizedLemmas(conf, pool),
+	}
+	ans.onBudgetExceeded = ans.spill
+	return ans
+}
+
+// mergeVertProcessors merges results of parallel workers into
+// the first one. In case the tables have been spilled to disk,
+// remaining items of all the workers are spilled too.
+func mergeVertProcessors(procs []*VertProcessor) (*VertProcessor, error) {
+	ans := procs[0]
+	if ans.tableSpill.HasRuns() {
+		for _, p := range procs {
+			if err := p.spill(); err != nil {
+				return nil, err
+			}
+		}
+		return ans, nil
+	}
+	for _, p := range procs[1:] {
+		ans.Table.Merge(p.Table)
+		ans.ParentCounts.Merge(p.ParentCounts)
+		ans.ChildCounts.Merge(p.ChildCounts)
+		ans.Triples.Merge(p.Triples)
+	}
+	return ans, nil
+}
+
+// mergeCoVertProcessors merges results of parallel workers into
+// the first one. In case the co-occurrence tables have been spilled
+// to disk, remaining items of all the workers are spilled too.
+// Token counts are always merged in memory.
+func mergeCoVertProcessors(procs []*CoVertProcessor) (*CoVertProcessor, error) {
+	ans := procs[0]
+	spilled := ans.coOccSpill.HasRuns()
+	for i, p := range procs {
+		if spilled {
+			if err := p.spill(); err != nil {
+				return nil, err
+			}
+
+		} else if i > 0 {
+			ans.CoOccTable.Merge(p.CoOccTable)
+		}
+		if i > 0 {
+			ans.TokenCounts.Merge(p.TokenCounts)
+			ans.NumTokens += p.NumTokens
+			ans.NumSentences += p.NumSentences
+			ans.NumDocs += p.NumDocs
+		}
+	}
+	return ans, nil
+}
+
+// countPairs counts syntactic pairs (the first pass
+// of the two-pass processing)
+func countPairs(
+	vertPath string,
+	conf *SyntaxProps,
+	spills *importSpills,
+	opts ImportOptions,
+	validator *vertValidator,
+	progress *importProgress,
+) (*VertProcessor, error) {
+	progress.startPass(ImportPhaseCountingPairs)
+	procs, err := parseVerticalParallel(
+		vertPath,
+		opts.NumWorkers,
+		progress,
+		func() *VertProcessor {
+			return newVertProcessor(
+				conf, spills, newMemoryBudget(opts.MemoryLimitMB, opts.NumWorkers), validator)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := mergeVertProcessors(procs)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Int("size", proc.Table.Len()).Msg("collocation table done")
+	return proc, nil
+}
+
+// countCoOccs counts window co-occurrences of candidate pairs
+// (the second pass of the two-pass processing)
+func countCoOccs(
+	vertPath string,
+	conf *SyntaxProps,
+	spills *importSpills,
+	opts ImportOptions,
+	validator *vertValidator,
+	candidates pairSet,
+	progress *importProgress,
+) (*CoVertProcessor, error) {
+	progress.startPass(ImportPhaseCountingCoOccs)
+	coProcs, err := parseVerticalParallel(
+		vertPath,
+		opts.NumWorkers,
+		progress,
+		func() *CoVertProcessor {
+			return newCoVertProcessor(
+				conf,
+				opts.CoOccSpan,
+				candidates,
+				spills,
+				newMemoryBudget(opts.MemoryLimitMB, opts.NumWorkers),
+				validator,
+			)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	coProc, err := mergeCoVertProcessors(coProcs)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Int("size", coProc.CoOccTable.Len()).Msg("cooccurrence table done")
+	return coProc, nil
+}
+
+// countTwoPass counts syntactic pairs in the first pass
+// and then co-occurrences of the found pairs in the second pass.
+// In case `existing` is provided, co-occurrences are counted also
+// for the pairs already stored in its live tables (this is needed
+// when appending data).
+func countTwoPass(
+	vertPath string,
+	conf *SyntaxProps,
+	spills *importSpills,
+	opts ImportOptions,
+	validator *vertValidator,
+	existing *CollDatabase,
+	progress *importProgress,
+) (*VertProcessor, *CoVertProcessor, error) {
+	proc, err := countPairs(vertPath, conf, spills, opts, validator, progress)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for _, v := range table {
-		if i == bulkInsertChunkSize {
-			sql := fmt.Sprintf(
-				"INSERT INTO %s_child_sums (lemma, upos, deprel, freq) VALUES %s",
-				corpusID, strings.Join(insertPlaceholders, ", "))
-			_, err := tx.Exec(sql, args...)
+	// prepare only pairs found for syntactic collocations
+	// we don't need to know co-occurrences for every possible pair
+	var candidates pairSet
+	if spills.table.HasRuns() || existing != nil {
+		// the collocation table does not fit into memory (or we have
+		// to consider also all the pairs stored in the database) so we
+		// use a Bloom filter to select co-occurrence candidates
+		// (false positives will be removed once merged with the pairs)
+		numItems := spills.table.NumRecords() + proc.Table.Len()
+		var existingPairs recordIterator
+		if existing != nil {
+			var numExisting int
+			existingPairs, numExisting, err = existing.livePairs()
 			if err != nil {
-				tx.Rollback()
-				return err
+				return nil, nil, err
+			}
+			numItems += numExisting
+		}
+		bf := newPairBloomFilter(numItems)
+		pairs, err := spills.table.Iterator()
+		if err != nil {
+			if existingPairs != nil {
+				existingPairs.Close()
+			}
+			return nil, nil, err
+		}
+		sources := []recordIterator{pairs}
+		if existingPairs != nil {
+			sources = append(sources, existingPairs)
+		}
+		for _, src := range sources {
+			if err := addToBloomFilter(bf, src); err != nil {
+				return nil, nil, err
 			}
-			args = make([]any, 0, bulkInsertChunkSize*4)
-			insertPlaceholders = make([]string, 0, bulkInsertChunkSize)
-			i = 0
-			log.Debug().Int("items", bulkInsertChunkSize).Msg("written child Fy bulk into database")
 		}
+		proc.Table.forEach(func(v CTItem) {
+			bf.Add(v.Lemma, v.Upos, v.PLemma, v.PUpos)
+		})
+		candidates = bf
+		log.Info().Msg("cooccurrence candidates filter done")
 
-		args = append(args, v.Lemma, v.Upos, v.Deprel, v.Freq)
-		insertPlaceholders = append(insertPlaceholders, "(?, ?, ?, ?)")
-		i++
+	} else {
+		coOccCandidates := newCoOccTable(newStringPool())
+		proc.Table.forEach(func(v CTItem) {
+			coOccCandidates.Add(v.Lemma, v.Upos, v.PLemma, v.PUpos, 0)
+		})
+		candidates = coOccCandidates
+	}
+	coProc, err := countCoOccs(vertPath, conf, spills, opts, validator, candidates, progress)
+	if err != nil {
+		return nil, nil, err
 	}
+	return proc, coProc, nil
+}
 
-	if len(args) > 0 {
-		sql := fmt.Sprintf(
-			"INSERT INTO %s_child_sums (lemma, upos, deprel, freq) VALUES %s",
-			corpusID, strings.Join(insertPlaceholders, ", "))
-		_, err := tx.Exec(sql, args...)
+// addToBloomFilter adds all the pairs provided by `records`
+// (using their first four fields) and closes the iterator
+func addToBloomFilter(bf *pairBloomFilter, records recordIterator) error {
+	defer records.Close()
+	for {
+		rec, err := records.Next()
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-		log.Debug().Int("items", len(insertPlaceholders)).Msg("written child Fy bulk into database")
+		if rec == nil {
+			return nil
+		}
+		bf.Add(rec.Fields[0], rec.Fields[1], rec.Fields[2], rec.Fields[3])
 	}
-	return nil
 }
 
-func runForDeprel(corpusID, vertPath string, coOccSpan int, conf *SyntaxProps, db *sql.DB) error {
-	pc := &vertigo.ParserConf{
-		InputFilePath:         vertPath,
-		Encoding:              "utf-8",
-		StructAttrAccumulator: "comb",
-	}
-	table := make(CounterTable)
-	parentSumTable := make(FyTable)
-	childSumTable := make(FyTable)
-	proc := &VertProcessor{
-		DeprelTypes: expandDeprelMultivalues(
-			[]string{
-				conf.NounModifiedValue,
-				conf.NounSubjectValue,
-				conf.NounObjectValue,
-			},
-		),
-		conf:         conf,
-		Table:        table,
-		ParentCounts: parentSumTable,
-		ChildCounts:  childSumTable,
+// countSinglePass counts syntactic pairs, token frequencies and
+// window co-occurrences of all the pairs in a single sweep. In case
+// the co-occurrences exceed the memory budget (see singlePassProcessor),
+// only the syntactic pairs are returned (along with a nil CoVertProcessor)
+// and the co-occurrences must be counted in a second pass.
+func countSinglePass(
+	vertPath string,
+	conf *SyntaxProps,
+	spills *importSpills,
+	opts ImportOptions,
+	validator *vertValidator,
+	progress *importProgress,
+) (*VertProcessor, *CoVertProcessor, error) {
+	progress.startPass(ImportPhaseCounting)
+	overBudget := new(atomic.Bool)
+	procs, err := parseVerticalParallel(
+		vertPath,
+		opts.NumWorkers,
+		progress,
+		func() *singlePassProcessor {
+			// each worker has two independently spilled parts
+			// so the budget is split accordingly
+			ans := &singlePassProcessor{
+				syntax: newVertProcessor(
+					conf,
+					spills,
+					newMemoryBudget(opts.MemoryLimitMB, 2*opts.NumWorkers),
+					validator,
+				),
+				coOcc: newCoVertProcessor(
+					conf,
+					opts.CoOccSpan,
+					anyPair{},
+					spills,
+					newMemoryBudget(opts.MemoryLimitMB, 2*opts.NumWorkers),
+					validator,
+				),
+				overBudget: overBudget,
+			}
+			ans.coOcc.onBudgetExceeded = ans.stopCoOccs
+			return ans
+		},
+	)
+	if err != nil {
+		return nil, nil, err
 	}
-	err := vertigo.ParseVerticalFile(pc, proc)
+	vertProcs := make([]*VertProcessor, len(procs))
+	coVertProcs := make([]*CoVertProcessor, len(procs))
+	for i, p := range procs {
+		vertProcs[i] = p.syntax
+		coVertProcs[i] = p.coOcc
+	}
+	proc, err := mergeVertProcessors(vertProcs)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	log.Info().Int("size", proc.Table.Len()).Msg("collocation table done")
+	if overBudget.Load() {
+		progress.addPass()
+		return proc, nil, nil
 	}
+	coProc, err := mergeCoVertProcessors(coVertProcs)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Info().Int("size", coProc.CoOccTable.Len()).Msg("cooccurrence table done")
+	return proc, coProc, nil
+}
 
-	log.Info().Int("size", len(table)).Msg("collocation table done")
+// countWithCheckpoint runs all the counting phases not
+// finished yet and stores their results in the checkpoint
+func countWithCheckpoint(
+	vertPath string,
+	conf *SyntaxProps,
+	opts ImportOptions,
+	checkpoint *importCheckpoint,
+	progress *importProgress,
+) error {
+	spills := newImportSpills(opts.SpillDir, checkpoint.manifest.CorpusID)
+	defer spills.Close()
+	validator := newVertValidator(vertPath, conf, opts.MaxNumErrors)
+	if checkpoint.manifest.PairsDone {
+		log.Info().Msg("using syntactic pairs from checkpoint")
 
+	} else if opts.SinglePass {
+		proc, coProc, err := countSinglePass(vertPath, conf, spills, opts, validator, progress)
+		if err := validator.Finish(opts.ValidationReport); err != nil {
+			log.Error().Err(err).Msg("failed to finish vertical file validation")
+		}
+		if err != nil {
+			return err
+		}
+		// Please note that in case of the single pass processing,
+		// the merge-join within writeFxy also prunes co-occurrences
+		// of pairs without syntactic attestation.
+		if err := checkpoint.savePairs(proc, spills); err != nil {
+			return err
+		}
+		if coProc != nil {
+			return checkpoint.saveCoOccs(coProc, spills)
+		}
+		// co-occurrences exceeded the memory budget so they
+		// are counted in the second pass below
+
+	} else {
+		proc, err := countPairs(vertPath, conf, spills, opts, validator, progress)
+		if err := validator.Finish(opts.ValidationReport); err != nil {
+			log.Error().Err(err).Msg("failed to finish vertical file validation")
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkpoint.savePairs(proc, spills); err != nil {
+			return err
+		}
+	}
+
+	if checkpoint.manifest.CoOccsDone {
+		log.Info().Msg("using co-occurrences from checkpoint")
+		return nil
+	}
 	// prepare only pairs found for syntactic collocations
 	// we don't need to know co-occurrences for every possible pair
-	coOccTable := make(CoOccTable)
-	tokenCounts := make(FyTable)
-	for _, v := range table {
-		coOccTable.Add(v.Lemma, v.Upos, v.PLemma, v.PUpos, 0)
-		tokenCounts.Add(v.Lemma, v.Upos, "", 0)
-		tokenCounts.Add(v.PLemma, v.PUpos, "", 0)
-	}
-	coProc := &CoVertProcessor{
-		Span:        coOccSpan,
-		conf:        conf,
-		CoOccTable:  coOccTable,
-		TokenCounts: tokenCounts,
-		Window:      make([][2]string, 0, 2*coOccSpan+1),
+	candidates, err := checkpoint.coOccCandidates(opts)
+	if err != nil {
+		return err
 	}
-	err = vertigo.ParseVerticalFile(pc, coProc)
+	coProc, err := countCoOccs(vertPath, conf, spills, opts, validator, candidates, progress)
 	if err != nil {
 		return err
 	}
+	return checkpoint.saveCoOccs(coProc, spills)
+}
 
-	log.Info().Int("size", len(coOccTable)).Msg("cooccurrence table done")
-
-	ctx := context.Background()
-
-	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+// runForDeprel performs a full import into staging tables and
+// swaps them with the live ones. Results of finished phases are
+// checkpointed to a local disk so an interrupted import can be
+// resumed (see ImportOptions.Resume).
+//
+// The counted data are committed chunk by chunk (see chunkCommitter)
+// and the source and import metadata (including the corpus size)
+// are committed afterwards. The atomicity comes from the swap:
+// as everything is written into the staging tables, the data and
+// their metadata replace the live ones at once (see SwapStagingTables).
+func runForDeprel(
+	corpusID, vertPath string,
+	conf *SyntaxProps,
+	db *sql.DB,
+	opts ImportOptions,
+	progress *importProgress,
+) error {
+	sourceID, err := vertSourceID(vertPath)
 	if err != nil {
 		return err
 	}
+	checkpoint, err := openImportCheckpoint(corpusID, sourceID, conf, opts)
+	if err != nil {
+		return err
+	}
+	if err := countWithCheckpoint(vertPath, conf, opts, checkpoint, progress); err != nil {
+		return err
+	}
 
-	_, err = db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s_fcolls", corpusID))
+	progress.setPhase(ImportPhaseWriting)
+	cdb := NewCollDatabase(db, corpusID)
+	tables := cdb.stagingTables()
+	if !checkpoint.manifest.WritingStarted {
+		if err := cdb.createStagingTables(!opts.BulkLoad); err != nil {
+			return fmt.Errorf("failed to initialize database tables: %w", err)
+		}
+		log.Info().Msgf("Testing whether the table %s is ready", cdb.StagingTableName())
+		if err := cdb.TestTableReady(); err != nil {
+			return fmt.Errorf("target db table %s NOT READY: %w", cdb.StagingTableName(), err)
+		}
+		log.Info().Msg("... table READY")
+		checkpoint.manifest.WritingStarted = true
+		checkpoint.manifest.WrittenRows = make(map[string]int64)
+		if err := checkpoint.save(); err != nil {
+			return err
+		}
+	}
+	tokenCounts, err := checkpoint.tokenCounts()
 	if err != nil {
 		return err
 	}
 
 	t0 := time.Now()
-
-	if err := writeFxy(tx, table, coOccTable, tokenCounts, corpusID); err != nil {
+	log.Info().Msg("writing fxy data into database")
+	var counts importCounts
+	counts.fcolls, err = checkpoint.writeTable(
+		cdb,
+		tables.fcolls,
+		func(target bulkExecer, numSkip int64) (int64, error) {
+			pairs, err := checkpoint.records(checkpointPairs)
+			if err != nil {
+				return 0, err
+			}
+			defer pairs.Close()
+			coOccs, err := checkpoint.records(checkpointCoOccs)
+			if err != nil {
+				return 0, err
+			}
+			defer coOccs.Close()
+			sink, err := newRowSink(target, tables.fcolls, fcollsColumns, opts)
+			if err != nil {
+				return 0, err
+			}
+			return writeFxy(
+				sink,
+				&skipIterator{records: pairs, numSkip: numSkip},
+				coOccs,
+				tokenCounts,
+			)
+		},
+	)
+	if err != nil {
 		return err
 	}
-	if err := writeChildren(tx, childSumTable, corpusID); err != nil {
+	for _, item := range []struct {
+		name      string
+		tableName string
+		columns   []string
+		numRows   *int64
+	}{
+		{
+			name:      checkpointChildren,
+			tableName: tables.childSums,
+			columns:   childSumsColumns,
+			numRows:   &counts.childSums,
+		},
+		{
+			name:      checkpointParents,
+			tableName: tables.parentSums,
+			columns:   parentSumsColumns,
+			numRows:   &counts.parentSums,
+		},
+		{
+			name:      checkpointTokenFreqs,
+			tableName: tables.tokenFreqs,
+			columns:   tokenFreqsColumns,
+			numRows:   &counts.tokenFreqs,
+		},
+	} {
+		*item.numRows, err = checkpoint.writeTable(
+			cdb,
+			item.tableName,
+			func(target bulkExecer, numSkip int64) (int64, error) {
+				records, err := checkpoint.records(item.name)
+				if err != nil {
+					return 0, err
+				}
+				defer records.Close()
+				sink, err := newRowSink(target, item.tableName, item.columns, opts)
+				if err != nil {
+					return 0, err
+				}
+				return writeRecords(sink, &skipIterator{records: records, numSkip: numSkip})
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	counts.triples, err = checkpoint.writeTable(
+		cdb,
+		tables.triples,
+		func(target bulkExecer, numSkip int64) (int64, error) {
+			records, err := checkpoint.records(checkpointTriples)
+			if err != nil {
+				return 0, err
+			}
+			defer records.Close()
+			sink, err := newRowSink(target, tables.triples, triplesColumns, opts)
+			if err != nil {
+				return 0, err
+			}
+			return writeTriples(
+				sink, &skipIterator{records: records, numSkip: numSkip}, tokenCounts)
+		},
+	)
+	if err != nil {
 		return err
 	}
-	if err := writeParents(tx, parentSumTable, corpusID); err != nil {
+	// in case of bulk loading, secondary indexes are created
+	// once all the data are loaded (this is also a no-op in case
+	// the indexes already exist)
+	if err := cdb.createMissingIndexes(tables); err != nil {
 		return err
 	}
 
-	log.Info().Msg("writing fxy data into database")
-	err = tx.Commit()
+	// source and import metadata are written at once so we
+	// can tell whether they have been written already (they become
+	// visible along with the data once the tables are swapped)
+	numSources, err := cdb.countRows(tables.sources)
 	if err != nil {
 		return err
 	}
+	if numSources == 0 {
+		tx, err := db.BeginTx(context.Background(), &sql.TxOptions{})
+		if err != nil {
+			return err
+		}
+		// a no-op once the transaction is committed
+		defer tx.Rollback()
+		err = writeSource(
+			tx, tables.sources, sourceID, vertPath, checkpoint.manifest.Size, opts.Location)
+		if err != nil {
+			return err
+		}
+		importRec := newImportRecord(
+			ImportTypeFull, vertPath, sourceID, opts, checkpoint.manifest.StartedAt)
+		importRec.TableRows = ImportTableRows{
+			Fcolls:     counts.fcolls,
+			ChildSums:  counts.childSums,
+			ParentSums: counts.parentSums,
+			TokenFreqs: counts.tokenFreqs,
+			Triples:    counts.triples,
+		}
+		if err := writeImportRecord(tx, tables.imports, importRec, opts.Location); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	counts.sources = 1
+	counts.imports = 1
 	log.Info().Float64("durationSec", time.Since(t0).Seconds()).Msg("...writing done")
 
+	if err := cdb.ValidateStagingTables(counts); err != nil {
+		return err
+	}
+	if err := cdb.SwapStagingTables(); err != nil {
+		return err
+	}
+	log.Info().Msg("staging tables swapped with live ones")
+	checkpoint.Remove()
 	return nil
 }
 
-func RunPg(corpusID, vertPath string, coOccSpan int, conf *SyntaxProps, db *sql.DB) error {
-	return runForDeprel(
-		corpusID,
-		vertPath,
-		coOccSpan,
-		conf,
-		db,
-	)
+func RunPg(corpusID, vertPath string, conf *SyntaxProps, db *sql.DB, opts ImportOptions) error {
+	if opts.Append && opts.Resume {
+		return fmt.Errorf("resuming is not supported when appending data")
+	}
+	progress := newImportProgress(corpusID, vertPath, opts)
+	progress.Start()
+	var err error
+	if opts.Append {
+		err = runAppend(corpusID, vertPath, conf, db, opts, progress)
+
+	} else {
+		err = runForDeprel(corpusID, vertPath, conf, db, opts, progress)
+	}
+	progress.Finish(err)
+	return err
 }
@@ -0,0 +1,76 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+// sliceSink collects written rows
+type sliceSink struct {
+	columns []string
+	rows    [][]any
+}
+
+func (sink *sliceSink) Add(values ...any) error {
+	sink.rows = append(sink.rows, values)
+	return nil
+}
+
+func (sink *sliceSink) Finish() (int64, error) {
+	return int64(len(sink.rows)), nil
+}
+
+func (sink *sliceSink) Abort() {}
+
+func (sink *sliceSink) numColumns() int {
+	return len(sink.columns)
+}
+
+// TestWriteFxyUnscoredPairs tests that pairs with composite lemmas
+// of multiword expressions (which have no token frequencies)
+// get NULL scores
+func TestWriteFxyUnscoredPairs(t *testing.T) {
+	pool := newStringPool()
+	tokenCounts := newFyTable(pool)
+	tokenCounts.add(fyKey{lemma: pool.ID("city"), upos: pool.ID("NOUN"), deprel: emptyStringID}, 10)
+	tokenCounts.add(fyKey{lemma: pool.ID("visit"), upos: pool.ID("VERB"), deprel: emptyStringID}, 30)
+	pairs := []spillRecord{
+		{Fields: []string{"New York", "PROPN", "visit", "VERB", "obj", "", "", "", ""}, Freq: 4},
+		{Fields: []string{"city", "NOUN", "visit", "VERB", "obj", "", "", "", ""}, Freq: 5},
+	}
+	coOccs := []spillRecord{
+		{Fields: []string{"city", "NOUN", "visit", "VERB"}, Freq: 8},
+	}
+	sink := &sliceSink{columns: fcollsColumns}
+	numRows, err := writeFxy(
+		sink, &sliceIterator{records: pairs}, &sliceIterator{records: coOccs}, tokenCounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numRows != 2 {
+		t.Fatalf("expected 2 rows, found %d", numRows)
+	}
+	if score := sink.rows[0][11]; score != nil {
+		t.Errorf("expected NULL score of a composite lemma, found %v", score)
+	}
+	expected := 14 + math.Log2(2*8.0/(10+30))
+	if score, ok := sink.rows[1][11].(float64); !ok || score != expected {
+		t.Errorf("expected score %v, found %v", expected, sink.rows[1][11])
+	}
+}
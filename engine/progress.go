@@ -0,0 +1,387 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tomachalek/vertigo/v5"
+)
+
+const (
+	ImportPhaseCountingPairs  = "countingPairs"
+	ImportPhaseCountingCoOccs = "countingCoOccurrences"
+	ImportPhaseCounting       = "counting"
+	ImportPhaseWriting        = "writing"
+	ImportPhaseDone           = "done"
+	ImportPhaseFailed         = "failed"
+
+	// progressFlushNumLines specifies how often (in lines) workers
+	// publish their progress
+	progressFlushNumLines = 10000
+
+	dfltProgressInterval = 10 * time.Second
+)
+
+// tableSizer is implemented by processors able to
+// report sizes of their counting tables
+type tableSizer interface {
+	tableSizes() map[string]int
+}
+
+// ImportStatus describes the state of a running import
+type ImportStatus struct {
+	CorpusID     string `json:"corpusId"`
+	VerticalPath string `json:"verticalPath"`
+	Phase        string `json:"phase"`
+
+	// PassNum is a number of the current pass over the vertical file
+	PassNum   int `json:"passNum"`
+	NumPasses int `json:"numPasses"`
+
+	StartedAt string `json:"startedAt"`
+	UpdatedAt string `json:"updatedAt"`
+
+	// FileSize is zero in case it cannot be determined
+	// (compressed file, command output)
+	FileSize int64 `json:"fileSize"`
+
+	// BytesProcessed, LinesProcessed and TokensProcessed
+	// refer to the current pass
+	BytesProcessed  int64   `json:"bytesProcessed"`
+	LinesProcessed  int64   `json:"linesProcessed"`
+	TokensProcessed int64   `json:"tokensProcessed"`
+	TokensPerSec    float64 `json:"tokensPerSec"`
+
+	// TableSizes contains sums of counting tables sizes of all
+	// the workers (i.e. before the tables are merged)
+	TableSizes map[string]int `json:"tableSizes"`
+
+	HeapAllocMB uint64 `json:"heapAllocMB"`
+
+	// ETASecs is an estimated number of seconds remaining until
+	// the vertical file is processed (database writes are not
+	// included). It is nil in case it cannot be estimated.
+	ETASecs *float64 `json:"etaSecs"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// importProgress collects progress of workers processing
+// a vertical file and periodically reports it
+type importProgress struct {
+	corpusID   string
+	vertPath   string
+	statusFile string
+	loc        *time.Location
+	interval   time.Duration
+	fileSize   int64
+	numPasses  int
+	started    time.Time
+
+	mutex       sync.Mutex
+	phase       string
+	passNum     int
+	passStarted time.Time
+
+	// passEnded is set once vertical file processing is finished
+	passEnded time.Time
+	bytes     int64
+	lines     int64
+	tokens    int64
+
+	// finishedBytes is a number of bytes processed
+	// by the already finished passes
+	finishedBytes int64
+	workerSizes   map[int]map[string]int
+	errMsg        string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start starts periodic progress reporting
+func (ip *importProgress) Start() {
+	go func() {
+		defer close(ip.done)
+		ticker := time.NewTicker(ip.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ip.stop:
+				return
+			case <-ticker.C:
+				ip.report()
+			}
+		}
+	}()
+}
+
+// Finish stops periodic reporting and reports
+// the final state of the import
+func (ip *importProgress) Finish(err error) {
+	close(ip.stop)
+	<-ip.done
+	ip.mutex.Lock()
+	if err != nil {
+		ip.phase = ImportPhaseFailed
+		ip.errMsg = err.Error()
+
+	} else {
+		ip.phase = ImportPhaseDone
+	}
+	ip.mutex.Unlock()
+	ip.report()
+}
+
+// startPass starts a new pass over the vertical file
+func (ip *importProgress) startPass(phase string) {
+	ip.mutex.Lock()
+	if ip.passNum > 0 {
+		ip.finishedBytes += ip.bytes
+	}
+	ip.phase = phase
+	ip.passNum++
+	ip.passStarted = time.Now()
+	ip.passEnded = time.Time{}
+	ip.bytes = 0
+	ip.lines = 0
+	ip.tokens = 0
+	ip.workerSizes = make(map[int]map[string]int)
+	ip.mutex.Unlock()
+	ip.report()
+}
+
+// addPass increases the expected number of passes over the vertical
+// file (e.g. in case the single pass processing falls back to two passes)
+func (ip *importProgress) addPass() {
+	ip.mutex.Lock()
+	ip.numPasses++
+	ip.mutex.Unlock()
+}
+
+// setPhase sets a phase not related to vertical file processing
+func (ip *importProgress) setPhase(phase string) {
+	ip.mutex.Lock()
+	if ip.passEnded.IsZero() {
+		ip.passEnded = time.Now()
+	}
+	ip.phase = phase
+	ip.mutex.Unlock()
+	ip.report()
+}
+
+// update adds progress of a worker. In case the processor provides
+// sizes of its tables, they are recorded too. The method must be called
+// from the worker's goroutine so the tables are not accessed concurrently.
+func (ip *importProgress) update(worker int, bytes, lines, tokens int64, proc any) {
+	var sizes map[string]int
+	if sizer, ok := proc.(tableSizer); ok {
+		sizes = sizer.tableSizes()
+	}
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	ip.bytes += bytes
+	ip.lines += lines
+	ip.tokens += tokens
+	if sizes != nil {
+		ip.workerSizes[worker] = sizes
+	}
+}
+
+func (ip *importProgress) isCounting() bool {
+	return ip.phase == ImportPhaseCountingPairs ||
+		ip.phase == ImportPhaseCountingCoOccs ||
+		ip.phase == ImportPhaseCounting
+}
+
+func (ip *importProgress) status() ImportStatus {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	now := time.Now()
+	ans := ImportStatus{
+		CorpusID:        ip.corpusID,
+		VerticalPath:    ip.vertPath,
+		Phase:           ip.phase,
+		PassNum:         ip.passNum,
+		NumPasses:       ip.numPasses,
+		StartedAt:       ip.started.In(ip.loc).Format(time.RFC3339),
+		UpdatedAt:       now.In(ip.loc).Format(time.RFC3339),
+		FileSize:        ip.fileSize,
+		BytesProcessed:  ip.bytes,
+		LinesProcessed:  ip.lines,
+		TokensProcessed: ip.tokens,
+		TableSizes:      make(map[string]int),
+		Error:           ip.errMsg,
+	}
+	passEnd := now
+	if !ip.passEnded.IsZero() {
+		passEnd = ip.passEnded
+	}
+	if elapsed := passEnd.Sub(ip.passStarted).Seconds(); elapsed > 0 && ip.passNum > 0 {
+		ans.TokensPerSec = float64(ip.tokens) / elapsed
+	}
+	for _, sizes := range ip.workerSizes {
+		for table, size := range sizes {
+			ans.TableSizes[table] += size
+		}
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	ans.HeapAllocMB = mem.HeapAlloc / (1024 * 1024)
+
+	doneWork := ip.finishedBytes + ip.bytes
+	if ip.isCounting() && ip.fileSize > 0 && doneWork > 0 {
+		totalWork := ip.fileSize * int64(ip.numPasses)
+		eta := now.Sub(ip.started).Seconds() * float64(totalWork-doneWork) / float64(doneWork)
+		ans.ETASecs = &eta
+	}
+	return ans
+}
+
+// report logs the current status and writes it to the status
+// file (if configured)
+func (ip *importProgress) report() {
+	status := ip.status()
+	evt := log.Info().
+		Str("phase", status.Phase).
+		Int("passNum", status.PassNum).
+		Int64("lines", status.LinesProcessed).
+		Int64("tokens", status.TokensProcessed).
+		Float64("tokensPerSec", status.TokensPerSec).
+		Uint64("heapAllocMB", status.HeapAllocMB)
+	for table, size := range status.TableSizes {
+		evt = evt.Int(table, size)
+	}
+	if status.FileSize > 0 && status.PassNum > 0 {
+		evt = evt.Float64(
+			"percentDone", float64(status.BytesProcessed)/float64(status.FileSize)*100)
+	}
+	if status.ETASecs != nil {
+		evt = evt.Float64("etaSecs", *status.ETASecs)
+	}
+	evt.Msg("import progress")
+	if ip.statusFile != "" {
+		if err := writeJSONFile(ip.statusFile, status); err != nil {
+			log.Error().Err(err).Msg("failed to write import status file")
+		}
+	}
+}
+
+// writeJSONFile writes a value atomically so readers
+// never see a partially written file
+func writeJSONFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func newImportProgress(corpusID, vertPath string, opts ImportOptions) *importProgress {
+	ans := &importProgress{
+		corpusID:    corpusID,
+		vertPath:    vertPath,
+		statusFile:  opts.StatusFile,
+		loc:         opts.Location,
+		interval:    opts.ProgressInterval,
+		numPasses:   2,
+		started:     time.Now(),
+		workerSizes: make(map[int]map[string]int),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if ans.loc == nil {
+		ans.loc = time.Local
+	}
+	if ans.interval <= 0 {
+		ans.interval = dfltProgressInterval
+	}
+	if opts.SinglePass && !opts.Append {
+		ans.numPasses = 1
+	}
+	// for compressed files or command outputs, we are not able
+	// to relate the processed data to the file size
+	if isSplittableVertical(vertPath) {
+		if finfo, err := os.Stat(vertPath); err == nil {
+			ans.fileSize = finfo.Size()
+		}
+	}
+	return ans
+}
+
+// ------------------------------
+
+// trackedProcessor reports progress of a processor used with
+// vertigo's parser (i.e. in case the vertical file cannot be
+// processed in chunks)
+type trackedProcessor[T chunkProcessor] struct {
+	proc     T
+	progress *importProgress
+	lines    int64
+	tokens   int64
+}
+
+func (tp *trackedProcessor[T]) tick(isToken bool) {
+	tp.lines++
+	if isToken {
+		tp.tokens++
+	}
+	if tp.lines == progressFlushNumLines {
+		tp.flush()
+	}
+}
+
+func (tp *trackedProcessor[T]) flush() {
+	tp.progress.update(0, 0, tp.lines, tp.tokens, tp.proc)
+	tp.lines = 0
+	tp.tokens = 0
+}
+
+func (tp *trackedProcessor[T]) ProcToken(token *vertigo.Token, line int, err error) error {
+	tp.tick(true)
+	return tp.proc.ProcToken(token, line, err)
+}
+
+func (tp *trackedProcessor[T]) ProcStruct(strc *vertigo.Structure, line int, err error) error {
+	tp.tick(false)
+	return tp.proc.ProcStruct(strc, line, err)
+}
+
+func (tp *trackedProcessor[T]) ProcStructClose(strc *vertigo.StructureClose, line int, err error) error {
+	tp.tick(false)
+	return tp.proc.ProcStructClose(strc, line, err)
+}
@@ -26,12 +26,106 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// CollFilter contains additional constraints of collocations
+type CollFilter struct {
+
+	// ChildFeats contains required morphological features
+	// of the child (e.g. `Case=Gen`)
+	ChildFeats []string
+
+	// ParentFeats contains required morphological
+	// features of the parent
+	ParentFeats []string
+
+	// Marker is a required lemma of a function word attached
+	// to the child (typically a preposition, see SyntaxProps.CaseValue)
+	Marker string
+
+	// Subtype restricts queried relations to their subtype
+	// (e.g. `poss` for `nmod:poss`, see ValidateSubtype). If empty,
+	// relations are matched along with all their subtypes.
+	Subtype string
+
+	// Polarity is a required polarity of the parent verb
+	// (see PolarityNeg, PolarityPos). If empty, pairs of any
+	// polarity are matched.
+	Polarity string
+}
+
+// withMarker adds a marker condition (if any)
+// to provided conditions
+func (cf CollFilter) withMarker(conds []string, args []any) ([]string, []any) {
+	if cf.Marker == "" {
+		return conds, args
+	}
+	return append(conds, "marker = ?"), append(args, cf.Marker)
+}
+
+// withPolarity adds a polarity condition (if any)
+// to provided conditions
+func (cf CollFilter) withPolarity(conds []string, args []any) ([]string, []any) {
+	if cf.Polarity == "" {
+		return conds, args
+	}
+	return append(conds, "polarity = ?"), append(args, cf.Polarity)
+}
+
+// fcollsSQL creates SQL conditions (along with their arguments)
+// of the filter applicable to the collocations table
+func (cf CollFilter) fcollsSQL() ([]string, []any) {
+	conds, args := featsSQL("feats", cf.ChildFeats)
+	pConds, pArgs := featsSQL("p_feats", cf.ParentFeats)
+	return cf.withPolarity(cf.withMarker(append(conds, pConds...), append(args, pArgs...)))
+}
+
+// childSumsSQL creates SQL conditions (along with their arguments)
+// of the filter applicable to the child sums table
+func (cf CollFilter) childSumsSQL() ([]string, []any) {
+	return cf.withPolarity(cf.withMarker(featsSQL("feats", cf.ChildFeats)))
+}
+
+// parentSumsSQL creates SQL conditions (along with their arguments)
+// of the filter applicable to the parent sums table
+func (cf CollFilter) parentSumsSQL() ([]string, []any) {
+	return cf.withPolarity(cf.withMarker(featsSQL("p_feats", cf.ParentFeats)))
+}
+
+// MarkerFreq is a marker of collocations (e.g. a preposition)
+// along with a total frequency of the marked pairs
+type MarkerFreq struct {
+	Marker string
+	Freq   int64
+}
+
+// Triple is a head-centered triple (see TriplePattern)
+// along with its frequency and score
+type Triple struct {
+	Pattern string
+	Lemma   string
+	Upos    string
+	Lemma1  string
+	Upos1   string
+	Lemma2  string
+	Upos2   string
+	Marker  string
+	Freq    int64
+	Score   float64
+}
+
 type Candidate struct {
-	Lemma      string
-	Upos       string
-	FreqXY     int64
-	FreqY      int64
-	CoOccScore float64
+	Lemma  string
+	Upos   string
+	FreqXY int64
+	FreqY  int64
+
+	// CoOccScore is nil for pairs without a score
+	// (composite lemmas of multiword expressions, see writeFxy)
+	CoOccScore *float64
+
+	// FreqNeg and FreqPos split FreqXY by polarity
+	// of the parent verb (see PolarityProps)
+	FreqNeg int64
+	FreqPos int64
 }
 
 // CollDatabase
@@ -47,40 +141,42 @@ func (cdb *CollDatabase) TableName() string {
 	return fmt.Sprintf("%s_fcolls", cdb.corpusID)
 }
 
+// StagingTableName returns a name of the staging table
+// an import writes collocations into
+func (cdb *CollDatabase) StagingTableName() string {
+	return cdb.stagingTables().fcolls
+}
+
+// TestTableReady tests whether it is possible to write
+// into the staging collocations table
 func (cdb *CollDatabase) TestTableReady() error {
-	tx, err := cdb.db.BeginTx(cdb.ctx, &sql.TxOptions{})
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	_, err = cdb.db.ExecContext(
-		cdb.ctx, fmt.Sprintf("INSERT IGNORE INTO %s_fcolls (id) VALUES (-1)", cdb.corpusID))
+	tableName := cdb.StagingTableName()
+	_, err := cdb.db.ExecContext(
+		cdb.ctx, fmt.Sprintf("INSERT IGNORE INTO %s (id) VALUES (-1)", tableName))
 	if err != nil {
 		return err
 	}
 	row := cdb.db.QueryRowContext(
-		cdb.ctx, fmt.Sprintf("SELECT id FROM %s_fcolls where id = ?", cdb.corpusID), -1)
+		cdb.ctx, fmt.Sprintf("SELECT id FROM %s where id = ?", tableName), -1)
 	var v sql.NullInt64
 	err = row.Scan(&v)
-	if err == sql.ErrNoRows {
-		return nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
 	}
+	_, err = cdb.db.ExecContext(
+		cdb.ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ?", tableName), -1)
 	return err
 }
 
-func (cdb *CollDatabase) GetFreq(lemma, upos, pLemma, pUpos, deprel string) (int64, error) {
-
+// pairsSQL creates SQL conditions (along with their arguments)
+// matching pairs of the collocations table. Empty arguments
+// are not used as conditions.
+func pairsSQL(lemma, upos, pLemma, pUpos, deprel string, filter CollFilter) ([]string, []any) {
 	whereSQL := make([]string, 0, 4)
 	whereArgs := make([]any, 0, 10)
 	if deprel != "" {
-		deprelParsed := strings.Split(deprel, "|")
-		deprelArgs := make([]any, len(deprelParsed))
-		deprelSql := make([]string, len(deprelParsed))
-		for i, dp := range deprelParsed {
-			deprelSql[i] = "deprel = ?"
-			deprelArgs[i] = dp
-		}
-		whereSQL = append(whereSQL, fmt.Sprintf("(%s)", strings.Join(deprelSql, " OR ")))
+		deprelCond, deprelArgs := deprelSQL(DeprelWithSubtype(deprel, filter.Subtype))
+		whereSQL = append(whereSQL, deprelCond)
 		whereArgs = append(whereArgs, deprelArgs...)
 	}
 	if lemma != "" {
@@ -99,7 +195,12 @@ func (cdb *CollDatabase) GetFreq(lemma, upos, pLemma, pUpos, deprel string) (int
 		whereSQL = append(whereSQL, "p_upos = ?")
 		whereArgs = append(whereArgs, pUpos)
 	}
+	filterSQL, filterArgs := filter.fcollsSQL()
+	return append(whereSQL, filterSQL...), append(whereArgs, filterArgs...)
+}
 
+func (cdb *CollDatabase) GetFreq(lemma, upos, pLemma, pUpos, deprel string, filter CollFilter) (int64, error) {
+	whereSQL, whereArgs := pairsSQL(lemma, upos, pLemma, pUpos, deprel, filter)
 	sql := fmt.Sprintf("SELECT COALESCE(SUM(freq), 0) FROM %s_fcolls WHERE %s", cdb.corpusID, strings.Join(whereSQL, " AND "))
 	log.Debug().Str("sql", sql).Any("args", whereArgs).Msg("going to SELECT cumulative freq.")
 	t0 := time.Now()
@@ -113,52 +214,113 @@ func (cdb *CollDatabase) GetFreq(lemma, upos, pLemma, pUpos, deprel string) (int
 	return ans, nil
 }
 
+// GetMarkers provides the most frequent markers (e.g. prepositions)
+// of pairs matching the arguments (see GetFreq), ordered by
+// the total frequency of the marked pairs
+func (cdb *CollDatabase) GetMarkers(
+	lemma, upos, pLemma, pUpos, deprel string,
+	filter CollFilter,
+	maxItems int,
+) ([]MarkerFreq, error) {
+	mkerr := func(err error) error { return fmt.Errorf("failed to get markers: %w", err) }
+	whereSQL, whereArgs := pairsSQL(lemma, upos, pLemma, pUpos, deprel, filter)
+	whereSQL = append(whereSQL, "marker <> ''")
+	sql := fmt.Sprintf(
+		"SELECT marker, SUM(freq) "+
+			"FROM %s_fcolls "+
+			"WHERE %s "+
+			"GROUP BY marker "+
+			"ORDER BY SUM(freq) DESC, marker "+
+			"LIMIT ?",
+		cdb.corpusID, strings.Join(whereSQL, " AND "),
+	)
+	whereArgs = append(whereArgs, maxItems)
+	log.Debug().Str("sql", sql).Any("args", whereArgs).Msg("going to SELECT markers")
+	t0 := time.Now()
+	rows, err := cdb.db.QueryContext(cdb.ctx, sql, whereArgs...)
+	if err != nil {
+		return []MarkerFreq{}, mkerr(err)
+	}
+	defer rows.Close()
+	ans := make([]MarkerFreq, 0, maxItems)
+	for rows.Next() {
+		var item MarkerFreq
+		if err := rows.Scan(&item.Marker, &item.Freq); err != nil {
+			return ans, mkerr(err)
+		}
+		ans = append(ans, item)
+	}
+	if err := rows.Err(); err != nil {
+		return ans, mkerr(err)
+	}
+	log.Debug().Float64("proctime", time.Since(t0).Seconds()).Msg(".... DONE (SELECT markers)")
+	return ans, nil
+}
+
+// polarityFreqsSQL selects frequencies of collocation
+// candidates split by polarity (see Candidate)
+var polarityFreqsSQL = fmt.Sprintf(
+	"SUM(CASE WHEN polarity = '%s' THEN freq ELSE 0 END), "+
+		"SUM(CASE WHEN polarity = '%s' THEN freq ELSE 0 END)",
+	PolarityNeg, PolarityPos,
+)
+
 // GetCollCandidatesOfChild provides collocation candidates of a child
-func (cdb *CollDatabase) GetCollCandidatesOfChild(lemma, upos, deprel string, minFreq int) ([]*Candidate, error) {
+func (cdb *CollDatabase) GetCollCandidatesOfChild(
+	lemma, upos, deprel string,
+	filter CollFilter,
+	minFreq int,
+) ([]*Candidate, error) {
 	mkerr := func(err error) error { return fmt.Errorf("failed to get coll candidates of child: %w", err) }
 	whereSQL := make([]string, 0, 4)
-	whereSQL = append(whereSQL, "lemma = ?", "freq >= ?")
+	whereSQL = append(whereSQL, "lemma = ?")
 	whereArgs := make([]any, 0, 4)
-	whereArgs = append(whereArgs, lemma, minFreq)
-	var deprelSQL []string
+	whereArgs = append(whereArgs, lemma)
+	deprelCond := "1 = 1"
 	var deprelArgs []any
 
 	if deprel != "" {
-		deprelParsed := strings.Split(deprel, "|")
-		deprelArgs = make([]any, len(deprelParsed))
-		deprelSQL = make([]string, len(deprelParsed))
-		for i, dp := range deprelParsed {
-			deprelSQL[i] = "deprel = ?"
-			deprelArgs[i] = dp
-		}
-		whereSQL = append(whereSQL, fmt.Sprintf("(%s)", strings.Join(deprelSQL, " OR ")))
+		deprelCond, deprelArgs = deprelSQL(DeprelWithSubtype(deprel, filter.Subtype))
+		whereSQL = append(whereSQL, deprelCond)
 		whereArgs = append(whereArgs, deprelArgs...)
-
-	} else {
-		deprelSQL = []string{"1 = 1"}
 	}
 
 	if upos != "" {
 		whereSQL = append(whereSQL, "upos = ?")
 		whereArgs = append(whereArgs, upos)
 	}
+	filterSQL, filterArgs := filter.fcollsSQL()
+	whereSQL = append(whereSQL, filterSQL...)
+	whereArgs = append(whereArgs, filterArgs...)
 
+	// pairs may be split into multiple rows (by deprels, morphological
+	// features, markers and polarity) so we have to sum them up
 	sql1 := fmt.Sprintf(
-		"SELECT p_lemma, p_upos, freq, co_occurrence_score "+
+		"SELECT p_lemma, p_upos, SUM(freq), MAX(co_occurrence_score), "+
+			polarityFreqsSQL+" "+
 			"FROM %s_fcolls "+
-			"WHERE %s ",
+			"WHERE %s "+
+			"GROUP BY p_lemma, p_upos "+
+			"HAVING SUM(freq) >= ? ",
 		cdb.corpusID, strings.Join(whereSQL, " AND "),
 	)
+	whereArgs = append(whereArgs, minFreq)
 	log.Debug().Str("sql", sql1).Any("args", whereArgs).Msg("going to SELECT child candidates")
 	t0 := time.Now()
 	rows, err := cdb.db.QueryContext(cdb.ctx, sql1, whereArgs...)
 	if err != nil {
 		return []*Candidate{}, mkerr(err)
 	}
+	defer rows.Close()
+	parentSQL, parentArgs := filter.parentSumsSQL()
+	parentSQL = append(parentSQL, deprelCond)
+	parentArgs = append(parentArgs, deprelArgs...)
 	ans := make([]*Candidate, 0, 100)
 	for rows.Next() {
 		item := &Candidate{}
-		err := rows.Scan(&item.Lemma, &item.Upos, &item.FreqXY, &item.CoOccScore)
+		err := rows.Scan(
+			&item.Lemma, &item.Upos, &item.FreqXY, &item.CoOccScore,
+			&item.FreqNeg, &item.FreqPos)
 		if err != nil {
 			return ans, mkerr(err)
 		}
@@ -166,9 +328,9 @@ func (cdb *CollDatabase) GetCollCandidatesOfChild(lemma, upos, deprel string, mi
 		sql2 := fmt.Sprintf(
 			"SELECT COALESCE(SUM(freq), 0) "+
 				"FROM %s_parent_sums "+
-				"WHERE p_lemma = ? AND p_upos = ? AND (%s) ",
-			cdb.corpusID, strings.Join(deprelSQL, " OR "))
-		whereArgs := append([]any{item.Lemma, item.Upos}, deprelArgs...)
+				"WHERE p_lemma = ? AND p_upos = ? AND %s ",
+			cdb.corpusID, strings.Join(parentSQL, " AND "))
+		whereArgs := append([]any{item.Lemma, item.Upos}, parentArgs...)
 		rows2 := cdb.db.QueryRowContext(
 			cdb.ctx, sql2, whereArgs...)
 		var fy int64
@@ -184,49 +346,60 @@ func (cdb *CollDatabase) GetCollCandidatesOfChild(lemma, upos, deprel string, mi
 }
 
 // GetCollCandidatesOfParent provides collocation candidates of a parent
-func (cdb *CollDatabase) GetCollCandidatesOfParent(lemma, upos, deprel string, minFreq int) ([]*Candidate, error) {
+func (cdb *CollDatabase) GetCollCandidatesOfParent(
+	lemma, upos, deprel string,
+	filter CollFilter,
+	minFreq int,
+) ([]*Candidate, error) {
 	mkerr := func(err error) error { return fmt.Errorf("failed to get coll candidates of parent: %w", err) }
 	whereSQL := make([]string, 0, 4)
-	whereSQL = append(whereSQL, "p_lemma = ?", "freq >= ?")
+	whereSQL = append(whereSQL, "p_lemma = ?")
 	whereArgs := make([]any, 0, 4)
-	whereArgs = append(whereArgs, lemma, minFreq)
-	var deprelSQL []string
+	whereArgs = append(whereArgs, lemma)
+	deprelCond := "1 = 1"
 	var deprelArgs []any
 
 	if deprel != "" {
-		deprelParsed := strings.Split(deprel, "|")
-		deprelArgs = make([]any, len(deprelParsed))
-		deprelSQL = make([]string, len(deprelParsed))
-		for i, dp := range deprelParsed {
-			deprelSQL[i] = "deprel = ?"
-			deprelArgs[i] = dp
-		}
-		whereSQL = append(whereSQL, fmt.Sprintf("(%s)", strings.Join(deprelSQL, " OR ")))
+		deprelCond, deprelArgs = deprelSQL(DeprelWithSubtype(deprel, filter.Subtype))
+		whereSQL = append(whereSQL, deprelCond)
 		whereArgs = append(whereArgs, deprelArgs...)
-
-	} else {
-		deprelSQL = []string{"1 = 1"}
 	}
 	if upos != "" {
 		whereSQL = append(whereSQL, "p_upos = ?")
 		whereArgs = append(whereArgs, upos)
 	}
+	filterSQL, filterArgs := filter.fcollsSQL()
+	whereSQL = append(whereSQL, filterSQL...)
+	whereArgs = append(whereArgs, filterArgs...)
+
+	// pairs may be split into multiple rows (by deprels, morphological
+	// features, markers and polarity) so we have to sum them up
 	sql1 := fmt.Sprintf(
-		"SELECT lemma, upos, freq, co_occurrence_score "+
+		"SELECT lemma, upos, SUM(freq), MAX(co_occurrence_score), "+
+			polarityFreqsSQL+" "+
 			"FROM %s_fcolls "+
-			"WHERE %s ",
+			"WHERE %s "+
+			"GROUP BY lemma, upos "+
+			"HAVING SUM(freq) >= ? ",
 		cdb.corpusID, strings.Join(whereSQL, " AND "),
 	)
+	whereArgs = append(whereArgs, minFreq)
 	log.Debug().Str("sql", sql1).Any("args", whereArgs).Msg("going to SELECT child candidates")
 	t0 := time.Now()
 	rows, err := cdb.db.QueryContext(cdb.ctx, sql1, whereArgs...)
 	if err != nil {
 		return []*Candidate{}, mkerr(err)
 	}
+	defer rows.Close()
+	childSQL, childArgs := filter.childSumsSQL()
+	childSQL = append(childSQL, deprelCond)
+	childArgs = append(childArgs, deprelArgs...)
 	ans := make([]*Candidate, 0, 100)
 	for rows.Next() {
 		item := &Candidate{}
-		err := rows.Scan(&item.Lemma, &item.Upos, &item.FreqXY, &item.CoOccScore)
+		err := rows.Scan(
+			&item.Lemma, &item.Upos, &item.FreqXY, &item.CoOccScore,
+			&item.FreqNeg, &item.FreqPos)
 		if err != nil {
 			return ans, mkerr(err)
 		}
@@ -234,8 +407,8 @@ func (cdb *CollDatabase) GetCollCandidatesOfParent(lemma, upos, deprel string, m
 			"SELECT COALESCE(SUM(freq), 0) "+
 				"FROM %s_child_sums "+
 				"WHERE lemma = ? AND upos = ? AND %s ",
-			cdb.corpusID, strings.Join(deprelSQL, " OR "))
-		whereArgs := append([]any{item.Lemma, item.Upos}, deprelArgs...)
+			cdb.corpusID, strings.Join(childSQL, " AND "))
+		whereArgs := append([]any{item.Lemma, item.Upos}, childArgs...)
 		rows2 := cdb.db.QueryRowContext(
 			cdb.ctx, sql2, whereArgs...)
 		var fy int64
@@ -251,6 +424,58 @@ func (cdb *CollDatabase) GetCollCandidatesOfParent(lemma, upos, deprel string, m
 	return ans, nil
 }
 
+// GetTriples provides triples of a head ordered by their score.
+// The upos and the pattern are optional.
+func (cdb *CollDatabase) GetTriples(
+	lemma, upos, pattern string,
+	minFreq, maxItems int,
+) ([]*Triple, error) {
+	mkerr := func(err error) error { return fmt.Errorf("failed to get triples: %w", err) }
+	whereSQL := []string{"lemma = ?", "freq >= ?"}
+	whereArgs := []any{lemma, minFreq}
+	if upos != "" {
+		whereSQL = append(whereSQL, "upos = ?")
+		whereArgs = append(whereArgs, upos)
+	}
+	if pattern != "" {
+		whereSQL = append(whereSQL, "pattern = ?")
+		whereArgs = append(whereArgs, pattern)
+	}
+	sql := fmt.Sprintf(
+		"SELECT pattern, lemma, upos, lemma1, upos1, lemma2, upos2, marker, freq, score "+
+			"FROM %s_triples "+
+			"WHERE %s "+
+			"ORDER BY score DESC, freq DESC "+
+			"LIMIT ?",
+		cdb.corpusID, strings.Join(whereSQL, " AND "),
+	)
+	whereArgs = append(whereArgs, maxItems)
+	log.Debug().Str("sql", sql).Any("args", whereArgs).Msg("going to SELECT triples")
+	t0 := time.Now()
+	rows, err := cdb.db.QueryContext(cdb.ctx, sql, whereArgs...)
+	if err != nil {
+		return []*Triple{}, mkerr(err)
+	}
+	defer rows.Close()
+	ans := make([]*Triple, 0, maxItems)
+	for rows.Next() {
+		item := &Triple{}
+		err := rows.Scan(
+			&item.Pattern, &item.Lemma, &item.Upos, &item.Lemma1, &item.Upos1,
+			&item.Lemma2, &item.Upos2, &item.Marker, &item.Freq, &item.Score,
+		)
+		if err != nil {
+			return ans, mkerr(err)
+		}
+		ans = append(ans, item)
+	}
+	if err := rows.Err(); err != nil {
+		return ans, mkerr(err)
+	}
+	log.Debug().Float64("proctime", time.Since(t0).Seconds()).Msg(".... DONE (SELECT triples)")
+	return ans, nil
+}
+
 func NewCollDatabase(db *sql.DB, corpusID string) *CollDatabase {
 	return &CollDatabase{
 		db:       db,
@@ -0,0 +1,191 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// registryPosAttr is a positional attribute as
+// defined in a Manatee registry file
+type registryPosAttr struct {
+	name    string
+	dynamic bool
+	line    int
+}
+
+// splitRegistryLine splits a registry line into words
+// while respecting quoted values
+func splitRegistryLine(line string) []string {
+	ans := make([]string, 0, 3)
+	var curr strings.Builder
+	inQuotes := false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if curr.Len() > 0 {
+				ans = append(ans, curr.String())
+				curr.Reset()
+			}
+		case c == '#' && !inQuotes:
+			if curr.Len() > 0 {
+				ans = append(ans, curr.String())
+			}
+			return ans
+		default:
+			curr.WriteRune(c)
+		}
+	}
+	if curr.Len() > 0 {
+		ans = append(ans, curr.String())
+	}
+	return ans
+}
+
+// parseRegistryPosAttrs reads positional attributes from a Manatee
+// registry file in the order they are defined. Attributes of structures
+// are ignored.
+func parseRegistryPosAttrs(path string) ([]registryPosAttr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return []registryPosAttr{}, fmt.Errorf("failed to read registry file: %w", err)
+	}
+	defer f.Close()
+	ans := make([]registryPosAttr, 0, 15)
+	var depth int
+	// pending is an index of a positional attribute whose block
+	// may still follow, block is an index of a positional attribute
+	// whose block is currently being read (-1 = none)
+	pending, block := -1, -1
+	scanner := bufio.NewScanner(f)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		words := splitRegistryLine(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+		switch {
+		case words[0] == "ATTRIBUTE" && depth == 0:
+			if len(words) < 2 {
+				return []registryPosAttr{}, fmt.Errorf(
+					"invalid registry file %s: missing attribute name on line %d", path, lineNum)
+			}
+			ans = append(ans, registryPosAttr{name: words[1], line: lineNum})
+			pending = len(ans) - 1
+		case words[0] == "DYNAMIC" && depth == 1 && block >= 0:
+			ans[block].dynamic = true
+		case words[0] != "{" && depth == 0:
+			pending = -1
+		}
+		for _, word := range words {
+			switch word {
+			case "{":
+				if depth == 0 {
+					block = pending
+				}
+				pending = -1
+				depth++
+			case "}":
+				depth--
+				if depth < 0 {
+					return []registryPosAttr{}, fmt.Errorf(
+						"invalid registry file %s: unexpected `}` on line %d", path, lineNum)
+				}
+				if depth == 0 {
+					block = -1
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return []registryPosAttr{}, fmt.Errorf("failed to read registry file: %w", err)
+	}
+	return ans, nil
+}
+
+// registryColumns maps names of positional attributes stored in
+// a vertical file to their vertical columns (`word` = 0). Dynamic
+// attributes are not part of vertical files so they are skipped.
+func registryColumns(path string) (map[string]int, error) {
+	attrs, err := parseRegistryPosAttrs(path)
+	if err != nil {
+		return map[string]int{}, err
+	}
+	ans := make(map[string]int)
+	seen := make(map[string]bool)
+	var col int
+	for _, attr := range attrs {
+		if seen[attr.name] {
+			return map[string]int{}, fmt.Errorf(
+				"duplicate attribute `%s` in registry file %s (line %d)", attr.name, path, attr.line)
+		}
+		seen[attr.name] = true
+		if attr.dynamic {
+			continue
+		}
+		ans[attr.name] = col
+		col++
+	}
+	return ans, nil
+}
+
+// applyRegistry sets vertical columns of all the configured attributes
+// based on a registry file. Attributes not found in the registry and
+// columns conflicting with explicitly configured ones are reported
+// as errors.
+func (conf *SyntaxProps) applyRegistry(registryPath, confContext string) error {
+	columns, err := registryColumns(registryPath)
+	if err != nil {
+		return err
+	}
+	usedBy := make(map[string]string)
+	for _, item := range conf.columnAttrs() {
+		if item.attr.Name == "" {
+			return fmt.Errorf("missing `%s.%s`", confContext, item.key)
+		}
+		col, ok := columns[item.attr.Name]
+		if !ok {
+			return fmt.Errorf(
+				"`%s.%s`: attribute `%s` not found in registry file %s",
+				confContext, item.key, item.attr.Name, registryPath)
+		}
+		if other, ok := usedBy[item.attr.Name]; ok {
+			return fmt.Errorf(
+				"`%s.%s`: attribute `%s` already used by `%s`",
+				confContext, item.key, item.attr.Name, other)
+		}
+		usedBy[item.attr.Name] = item.key
+		if col == 0 {
+			return fmt.Errorf(
+				"`%s.%s`: attribute `%s` is the first (word) column which cannot be used",
+				confContext, item.key, item.attr.Name)
+		}
+		if item.attr.VerticalCol != 0 && item.attr.VerticalCol != col {
+			return fmt.Errorf(
+				"`%s.%s`: configured verticalCol %d conflicts with column %d from registry file",
+				confContext, item.key, item.attr.VerticalCol, col)
+		}
+		item.attr.VerticalCol = col
+	}
+	return nil
+}
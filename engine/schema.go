@@ -0,0 +1,513 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// CurrentSchemaVersion is a version of corpus tables
+	// created by this release of scollex. Any change of the tables
+	// must be accompanied by a respective schemaMigration.
+	CurrentSchemaVersion = 7
+
+	schemaVersionsTable = "scollex_schema_versions"
+)
+
+var (
+	ErrCorpusNotImported = errors.New("corpus data not imported")
+)
+
+// schemaMigration upgrades tables of a single generation of corpus
+// data from the previous version to `version`. Migrations must be
+// idempotent so an interrupted migration can be run again.
+type schemaMigration struct {
+	version     int
+	description string
+	apply       func(cdb *CollDatabase, tables importTables) error
+}
+
+// schemaMigrations contains all the migrations ordered by version.
+// Version 0 stands for tables created by scripts/schema.sql of
+// early releases.
+var schemaMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "add co-occurrence scores and lemma indexes",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			err := cdb.addColumnIfMissing(tables.fcolls, "co_occurrence_score", "FLOAT")
+			if err != nil {
+				return err
+			}
+			return cdb.addIndexesIfMissing(tables)
+		},
+	},
+	{
+		version:     2,
+		description: "add co-occurrence frequencies, token frequencies and sources required for appending data",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			err := cdb.addColumnIfMissing(
+				tables.fcolls, "co_occurrence_freq", "int(11) NOT NULL DEFAULT 0")
+			if err != nil {
+				return err
+			}
+			return cdb.createTablesIfMissing(
+				tableCreator{
+					tableName: tables.tokenFreqs,
+					create: func(tx *sql.Tx) error {
+						return cdb.createTokenFreqsTable(tx, tables.tokenFreqs, defaultWordColumnSize)
+					},
+				},
+				tableCreator{
+					tableName: tables.sources,
+					create: func(tx *sql.Tx) error {
+						return cdb.createSourcesTable(tx, tables.sources)
+					},
+				},
+			)
+		},
+	},
+	{
+		version:     3,
+		description: "add import metadata",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			return cdb.createTablesIfMissing(
+				tableCreator{
+					tableName: tables.imports,
+					create: func(tx *sql.Tx) error {
+						return cdb.createImportsTable(tx, tables.imports)
+					},
+				},
+			)
+		},
+	},
+	{
+		version:     4,
+		description: "add morphological features of collocations",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			featsDef := fmt.Sprintf("varchar(%d) NOT NULL DEFAULT ''", featsColumnSize)
+			for _, item := range [][2]string{
+				{tables.fcolls, "feats"},
+				{tables.fcolls, "p_feats"},
+				{tables.childSums, "feats"},
+				{tables.parentSums, "p_feats"},
+			} {
+				if err := cdb.addColumnIfMissing(item[0], item[1], featsDef); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "add markers (e.g. prepositions) of collocations",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			markerDef := fmt.Sprintf("varchar(%d) NOT NULL DEFAULT ''", markerColumnSize)
+			for _, tableName := range []string{tables.fcolls, tables.childSums, tables.parentSums} {
+				if err := cdb.addColumnIfMissing(tableName, "marker", markerDef); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     6,
+		description: "add multi-word collocation triples",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			err := cdb.addColumnIfMissing(
+				tables.imports, "triples_rows", "bigint NOT NULL DEFAULT 0")
+			if err != nil {
+				return err
+			}
+			err = cdb.createTablesIfMissing(
+				tableCreator{
+					tableName: tables.triples,
+					create: func(tx *sql.Tx) error {
+						return cdb.createTriplesTable(tx, tables.triples, defaultWordColumnSize)
+					},
+				},
+			)
+			if err != nil {
+				return err
+			}
+			return cdb.addIndexesIfMissing(tables)
+		},
+	},
+	{
+		version:     7,
+		description: "add polarity of verbs",
+		apply: func(cdb *CollDatabase, tables importTables) error {
+			polarityDef := fmt.Sprintf("varchar(%d) NOT NULL DEFAULT ''", polarityColumnSize)
+			for _, tableName := range []string{tables.fcolls, tables.childSums, tables.parentSums} {
+				if err := cdb.addColumnIfMissing(tableName, "polarity", polarityDef); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+func (cdb *CollDatabase) columnExists(tableName, column string) (bool, error) {
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		"SELECT COUNT(*) FROM information_schema.columns "+
+			"WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?",
+		tableName, column,
+	)
+	var ans int
+	if err := row.Scan(&ans); err != nil {
+		return false, err
+	}
+	return ans > 0, nil
+}
+
+func (cdb *CollDatabase) addColumnIfMissing(tableName, column, colDef string) error {
+	exists, err := cdb.columnExists(tableName, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = cdb.db.ExecContext(
+		cdb.ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, column, colDef))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s to table %s: %w", column, tableName, err)
+	}
+	log.Info().Str("table", tableName).Str("column", column).Msg("column added")
+	return nil
+}
+
+// addIndexesIfMissing creates indexes of the tables unless their
+// columns are already indexed (tables created by older versions
+// may use different names of indexes)
+func (cdb *CollDatabase) addIndexesIfMissing(tables importTables) error {
+	for _, idx := range tables.indexes() {
+		exists, err := cdb.tableExists(idx.tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		row := cdb.db.QueryRowContext(
+			cdb.ctx,
+			"SELECT COUNT(*) FROM information_schema.statistics "+
+				"WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?",
+			idx.tableName, idx.column,
+		)
+		var numIndexes int
+		if err := row.Scan(&numIndexes); err != nil {
+			return err
+		}
+		if numIndexes > 0 {
+			continue
+		}
+		_, err = cdb.db.ExecContext(
+			cdb.ctx,
+			fmt.Sprintf("CREATE INDEX %s ON %s(%s)", idx.name(), idx.tableName, idx.column),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to CREATE index %s: %w", idx.name(), err)
+		}
+		log.Info().Str("index", idx.name()).Msg("index added")
+	}
+	return nil
+}
+
+// tableCreator creates a table added by a migration
+type tableCreator struct {
+	tableName string
+	create    func(tx *sql.Tx) error
+}
+
+func (cdb *CollDatabase) createTablesIfMissing(creators ...tableCreator) error {
+	for _, creator := range creators {
+		exists, err := cdb.tableExists(creator.tableName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		tx, err := cdb.db.BeginTx(cdb.ctx, &sql.TxOptions{})
+		if err != nil {
+			return err
+		}
+		if err := creator.create(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Info().Str("table", creator.tableName).Msg("table added")
+	}
+	return nil
+}
+
+// ------------------------------
+
+func (cdb *CollDatabase) ensureSchemaVersionsTable() error {
+	_, err := cdb.db.ExecContext(
+		cdb.ctx,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			corpus_id varchar(100) NOT NULL,
+			version int(11) NOT NULL,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (corpus_id)
+		)`, schemaVersionsTable),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to CREATE table %s: %w", schemaVersionsTable, err)
+	}
+	return nil
+}
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// setSchemaVersion records a schema version of the corpus tables.
+// The schema versions table must exist (see ensureSchemaVersionsTable).
+func (cdb *CollDatabase) setSchemaVersion(execer sqlExecer, version int) error {
+	_, err := execer.ExecContext(
+		cdb.ctx,
+		fmt.Sprintf(
+			"REPLACE INTO %s (corpus_id, version, updated_at) VALUES (?, ?, NOW())",
+			schemaVersionsTable,
+		),
+		cdb.corpusID, version,
+	)
+	return err
+}
+
+// storedSchemaVersion returns a recorded schema version
+// of the corpus tables (-1 if not recorded)
+func (cdb *CollDatabase) storedSchemaVersion() (int, error) {
+	exists, err := cdb.tableExists(schemaVersionsTable)
+	if err != nil || !exists {
+		return -1, err
+	}
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		fmt.Sprintf("SELECT version FROM %s WHERE corpus_id = ?", schemaVersionsTable),
+		cdb.corpusID,
+	)
+	var ans int
+	err = row.Scan(&ans)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	return ans, err
+}
+
+// detectSchemaVersion infers a schema version from existing tables.
+// This is used for installations created before schema versions
+// have been recorded.
+func (cdb *CollDatabase) detectSchemaVersion(tables importTables) (int, error) {
+	exists, err := cdb.tableExists(tables.fcolls)
+	if err != nil {
+		return -1, err
+	}
+	if !exists {
+		return -1, ErrCorpusNotImported
+	}
+	hasScores, err := cdb.columnExists(tables.fcolls, "co_occurrence_score")
+	if err != nil {
+		return -1, err
+	}
+	if !hasScores {
+		return 0, nil
+	}
+	hasCoOccFreqs, err := cdb.columnExists(tables.fcolls, "co_occurrence_freq")
+	if err != nil {
+		return -1, err
+	}
+	exist, err := cdb.tablesExist(tables)
+	if err != nil {
+		return -1, err
+	}
+	// exist is ordered as tables.all()
+	hasTokenFreqs, hasSources, hasImports := exist[3], exist[4], exist[5]
+	if !hasCoOccFreqs || !hasTokenFreqs || !hasSources {
+		return 1, nil
+	}
+	if !hasImports {
+		return 2, nil
+	}
+	hasFeats, err := cdb.columnExists(tables.fcolls, "feats")
+	if err != nil {
+		return -1, err
+	}
+	if !hasFeats {
+		return 3, nil
+	}
+	hasMarkers, err := cdb.columnExists(tables.fcolls, "marker")
+	if err != nil {
+		return -1, err
+	}
+	if !hasMarkers {
+		return 4, nil
+	}
+	if hasTriples := exist[6]; !hasTriples {
+		return 5, nil
+	}
+	hasPolarity, err := cdb.columnExists(tables.fcolls, "polarity")
+	if err != nil {
+		return -1, err
+	}
+	if !hasPolarity {
+		return 6, nil
+	}
+	return 7, nil
+}
+
+// SchemaVersion returns a schema version of the live corpus tables.
+// In case the corpus has not been imported yet, ErrCorpusNotImported
+// is returned.
+func (cdb *CollDatabase) SchemaVersion() (int, error) {
+	exists, err := cdb.tableExists(cdb.liveTables().fcolls)
+	if err != nil {
+		return -1, err
+	}
+	if !exists {
+		return -1, ErrCorpusNotImported
+	}
+	version, err := cdb.storedSchemaVersion()
+	if err != nil || version >= 0 {
+		return version, err
+	}
+	return cdb.detectSchemaVersion(cdb.liveTables())
+}
+
+// CheckSchemaVersion returns an error with instructions in case
+// the live corpus tables are too old to be used by this release
+func (cdb *CollDatabase) CheckSchemaVersion() error {
+	version, err := cdb.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version < CurrentSchemaVersion {
+		return fmt.Errorf(
+			"tables of corpus %s use schema version %d while version %d is required "+
+				"- please run `scollex migrate [config.json] %s` (or re-import the corpus)",
+			cdb.corpusID, version, CurrentSchemaVersion, cdb.corpusID)
+	}
+	if version > CurrentSchemaVersion {
+		return fmt.Errorf(
+			"tables of corpus %s use schema version %d which is newer than supported version %d "+
+				"- please upgrade scollex",
+			cdb.corpusID, version, CurrentSchemaVersion)
+	}
+	return nil
+}
+
+// migrateGeneration applies all the migrations newer
+// than `version` to a single generation of tables
+func (cdb *CollDatabase) migrateGeneration(tables importTables, version int) error {
+	for _, migration := range schemaMigrations {
+		if migration.version <= version {
+			continue
+		}
+		log.Info().
+			Str("corpusId", cdb.corpusID).
+			Str("fcolls", tables.fcolls).
+			Int("version", migration.version).
+			Str("description", migration.description).
+			Msg("applying schema migration")
+		if err := migration.apply(cdb, tables); err != nil {
+			return fmt.Errorf("schema migration to version %d failed: %w", migration.version, err)
+		}
+	}
+	return nil
+}
+
+// Migrate upgrades the live tables (and the previous generation, if
+// present, so it can be still rolled back to) to CurrentSchemaVersion.
+// Data missing in older versions (e.g. co-occurrence frequencies
+// required for appending data) cannot be recomputed so a full
+// re-import is still needed to use the respective features.
+func (cdb *CollDatabase) Migrate() error {
+	version, err := cdb.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > CurrentSchemaVersion {
+		return fmt.Errorf(
+			"schema version %d is newer than supported version %d", version, CurrentSchemaVersion)
+	}
+	if err := cdb.ensureSchemaVersionsTable(); err != nil {
+		return err
+	}
+	if version == CurrentSchemaVersion {
+		log.Info().
+			Str("corpusId", cdb.corpusID).
+			Int("version", version).
+			Msg("schema is up to date")
+		return cdb.setSchemaVersion(cdb.db, version)
+	}
+	prev := cdb.prevTables()
+	prevVersion, err := cdb.detectSchemaVersion(prev)
+	if err != nil && err != ErrCorpusNotImported {
+		return err
+	}
+	if err == nil && prevVersion < CurrentSchemaVersion {
+		if err := cdb.migrateGeneration(prev, prevVersion); err != nil {
+			return err
+		}
+	}
+	if err := cdb.migrateGeneration(cdb.liveTables(), version); err != nil {
+		return err
+	}
+	if err := cdb.setSchemaVersion(cdb.db, CurrentSchemaVersion); err != nil {
+		return err
+	}
+	log.Info().
+		Str("corpusId", cdb.corpusID).
+		Int("fromVersion", version).
+		Int("toVersion", CurrentSchemaVersion).
+		Msg("schema migrated")
+	if version < 2 {
+		log.Warn().
+			Str("corpusId", cdb.corpusID).
+			Msg("migrated data lack co-occurrence and token frequencies, appending data requires a full re-import")
+	}
+	if version < 5 {
+		log.Warn().
+			Str("corpusId", cdb.corpusID).
+			Msg("migrated data lack markers of collocations (e.g. prepositions), a full re-import is recommended")
+	}
+	if version < 6 {
+		log.Warn().
+			Str("corpusId", cdb.corpusID).
+			Msg("migrated data lack collocation triples, a full re-import is required to query them")
+	}
+	if version < 7 {
+		log.Warn().
+			Str("corpusId", cdb.corpusID).
+			Msg("migrated data lack polarity of verbs, a full re-import is required to query it")
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// markerColumnSize is a size of database columns
+	// storing lemmas of function words marking a relation
+	markerColumnSize = 100
+
+	// maxSentenceTokens limits the number of buffered tokens. Longer
+	// sentences (or sequences of tokens outside sentences) are split
+	// (see sentenceBuffer).
+	maxSentenceTokens = 2000
+)
+
+// sentToken is a token buffered until its sentence is complete.
+// Strings are interned in a pool of the respective processor.
+type sentToken struct {
+	lemma  uint32
+	upos   uint32
+	pLemma uint32
+	pUpos  uint32
+	deprel uint32
+	feats  uint32
+	pFeats uint32
+
+	// negated is true if the token's features mark negation
+	// (see PolarityProps.NegFeature)
+	negated bool
+
+	// passive is true if the token's features mark passive
+	// voice (see SyntaxProps.PassiveFeature)
+	passive bool
+
+	// parent is a position of the parent token within
+	// the sentence (-1 for root or an invalid reference)
+	parent int
+
+	// valid is false for malformed tokens (or tokens merged
+	// into multiword expressions, see mergeMultiwords) which are
+	// kept only so positions of other tokens are preserved
+	valid bool
+}
+
+// sentenceBuffer collects tokens of a sentence so relations between
+// tokens not adjacent in the dependency tree (e.g. a preposition
+// attached to a noun modifying another noun) can be resolved once
+// the whole sentence is known.
+//
+// Sentences longer than maxSentenceTokens (or long sequences of tokens
+// outside sentences) are split at lines (of any kind) with indices
+// divisible by maxSentenceTokens so the groups of processed tokens
+// do not depend on how a vertical file is divided into chunks. As
+// a chunk may start or end in the middle of such a group, a chunk
+// processor completes its last group using overrun lines (up to
+// the next sentence boundary or split line) and ignores tokens
+// preceding its first boundary or split line (they are processed
+// by a previous chunk). This way, each group of tokens is handled
+// by exactly one worker and the results are the same for any
+// number of workers.
+type sentenceBuffer struct {
+	tokens []sentToken
+
+	// leading is true until the first sentence boundary or split
+	// line of a chunk starting in the middle of a file
+	leading     bool
+	overrunDone bool
+
+	// onSentence is called for each complete sentence (or a group
+	// of tokens located outside sentences). The tokens are valid
+	// only during the call.
+	onSentence func(tokens []sentToken) error
+}
+
+// isSplitLine tells whether a long sentence is split
+// before the line with the provided index
+func isSplitLine(line int) bool {
+	return line%maxSentenceTokens == 0
+}
+
+// startChunk prepares the buffer for a chunk of a vertical file
+func (sb *sentenceBuffer) startChunk(midFile bool) {
+	sb.leading = midFile
+}
+
+// add buffers a token located at the `line`. The parentRef
+// is a relative position of the token's parent (zero for root).
+func (sb *sentenceBuffer) add(tk sentToken, parentRef string, line int) error {
+	if err := sb.checkSplit(line); err != nil {
+		return err
+	}
+	tk.parent = -1
+	tk.valid = true
+	if offset, err := strconv.Atoi(parentRef); err == nil && offset != 0 {
+		tk.parent = len(sb.tokens) + offset
+	}
+	sb.tokens = append(sb.tokens, tk)
+	return nil
+}
+
+// skip buffers a placeholder of a malformed token
+func (sb *sentenceBuffer) skip(line int) error {
+	if err := sb.checkSplit(line); err != nil {
+		return err
+	}
+	sb.tokens = append(sb.tokens, sentToken{parent: -1})
+	return nil
+}
+
+// checkSplit ends the current group of tokens in case
+// a long sentence is split before the line. It must be
+// called for all the lines of a chunk.
+func (sb *sentenceBuffer) checkSplit(line int) error {
+	if !isSplitLine(line) {
+		return nil
+	}
+	return sb.boundary()
+}
+
+// boundary ends the current group of tokens. Leading tokens
+// of a chunk are dropped as they have been processed
+// by a previous chunk.
+func (sb *sentenceBuffer) boundary() error {
+	if sb.leading {
+		sb.leading = false
+		sb.tokens = sb.tokens[:0]
+		return nil
+	}
+	return sb.flush()
+}
+
+func (sb *sentenceBuffer) flush() error {
+	if len(sb.tokens) == 0 {
+		return nil
+	}
+	// references pointing out of the sentence are invalid
+	// (they are reported by the validator)
+	for i, tk := range sb.tokens {
+		if tk.parent >= len(sb.tokens) || tk.parent < 0 {
+			sb.tokens[i].parent = -1
+		}
+	}
+	err := sb.onSentence(sb.tokens)
+	sb.tokens = sb.tokens[:0]
+	return err
+}
+
+// open handles a start of a sentence
+func (sb *sentenceBuffer) open() error {
+	return sb.boundary()
+}
+
+// close handles an end of a sentence
+func (sb *sentenceBuffer) close() error {
+	return sb.boundary()
+}
+
+// acceptsOverrun tells whether lines after the end
+// of a chunk are still needed
+func (sb *sentenceBuffer) acceptsOverrun() bool {
+	return !sb.overrunDone && !sb.leading
+}
+
+// overrunLine handles a line after the end of a chunk. The isBoundary
+// argument tells whether the line starts or ends a sentence. The method
+// returns true if the line belongs to the last group of tokens of the chunk
+// (i.e. a token on the line must be added and more overrun lines are needed).
+func (sb *sentenceBuffer) overrunLine(line int, isBoundary bool) (bool, error) {
+	if !sb.acceptsOverrun() {
+		return false, nil
+	}
+	if isBoundary || isSplitLine(line) {
+		sb.overrunDone = true
+		return false, sb.flush()
+	}
+	return true, nil
+}
+
+// finish handles remaining tokens once all the lines
+// (including overrun ones) have been read
+func (sb *sentenceBuffer) finish() error {
+	sb.overrunDone = true
+	return sb.boundary()
+}
+
+// isDeprelOf tests whether a (possibly multi-value) deprel
+// is of the provided base relation (subtypes included)
+func isDeprelOf(value, relation string) bool {
+	for _, deprel := range strings.Split(value, "|") {
+		if base, _, _ := strings.Cut(deprel, ":"); base == relation {
+			return true
+		}
+	}
+	return false
+}
+
+// caseMarkers finds function words (typically prepositions) attached
+// to sentence tokens by the `relation` (e.g. `case`) and returns IDs
+// of their lemmas for all the token positions (emptyStringID if there
+// is no marker). Multiple markers of a single token (e.g. `out of`)
+// are joined by a space in order of their appearance.
+func caseMarkers(tokens []sentToken, pool *stringPool, relation string) []uint32 {
+	ans := make([]uint32, len(tokens))
+	for _, tk := range tokens {
+		if !tk.valid || tk.parent < 0 || !isDeprelOf(pool.Value(tk.deprel), relation) {
+			continue
+		}
+		if ans[tk.parent] == emptyStringID {
+			ans[tk.parent] = tk.lemma
+
+		} else {
+			ans[tk.parent] = pool.ID(pool.Value(ans[tk.parent]) + " " + pool.Value(tk.lemma))
+		}
+	}
+	return ans
+}
+
+// mergeMultiwords merges subtrees of multiword expressions (tokens
+// attached by one of the `multiwords` relations, e.g. `New York`)
+// into their heads. The heads get composite lemmas (words joined
+// by a space in the sentence order), the other tokens of the
+// expressions are invalidated and tokens attached to the expressions
+// get the composite lemmas as their parent lemmas. The `buf`
+// is reused (if large enough) to prevent allocations.
+func mergeMultiwords(
+	tokens []sentToken,
+	pool *stringPool,
+	multiwords *relationCache,
+	buf []int,
+) []int {
+	heads := buf[:0]
+	merged := false
+	for i := range tokens {
+		h := i
+		// the number of steps is limited in case of cyclic references
+		for step := 0; step < len(tokens); step++ {
+			tk := tokens[h]
+			if !tk.valid || tk.parent < 0 || len(multiwords.relations(tk.deprel)) == 0 {
+				break
+			}
+			h = tk.parent
+		}
+		heads = append(heads, h)
+		merged = merged || h != i
+	}
+	if !merged {
+		return heads
+	}
+	composite := make([]bool, len(tokens))
+	for i, h := range heads {
+		if h != i && tokens[i].valid {
+			composite[h] = true
+		}
+	}
+	var words []string
+	for h := range tokens {
+		if !composite[h] {
+			continue
+		}
+		words = words[:0]
+		for i, head := range heads {
+			if head == h && tokens[i].valid {
+				words = append(words, pool.Value(tokens[i].lemma))
+			}
+		}
+		tokens[h].lemma = pool.ID(strings.Join(words, " "))
+	}
+	for i, tk := range tokens {
+		if !tk.valid || tk.parent < 0 || heads[i] != i {
+			continue
+		}
+		p := heads[tk.parent]
+		if !composite[p] {
+			continue
+		}
+		tokens[i].parent = p
+		tokens[i].pLemma = tokens[p].lemma
+		tokens[i].pUpos = tokens[p].upos
+	}
+	for i, h := range heads {
+		if h != i {
+			tokens[i].valid = false
+		}
+	}
+	return heads
+}
+
+// sentenceChildren returns positions of children for all the sentence
+// tokens. The `buf` is reused (if large enough) to prevent allocations.
+func sentenceChildren(tokens []sentToken, buf [][]int) [][]int {
+	for len(buf) < len(tokens) {
+		buf = append(buf, nil)
+	}
+	buf = buf[:len(tokens)]
+	for i := range buf {
+		buf[i] = buf[i][:0]
+	}
+	for i, tk := range tokens {
+		if tk.valid && tk.parent >= 0 {
+			buf[tk.parent] = append(buf[tk.parent], i)
+		}
+	}
+	return buf
+}
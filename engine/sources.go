@@ -0,0 +1,142 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// sqlDateTimeLayout formats times stored in DATETIME columns.
+	// The times are formatted on our side as the database driver
+	// would convert time.Time values into its own time zone.
+	sqlDateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// vertSourceID creates an identifier of imported data. For regular
+// files, the checksum of the file contents is used so the same data
+// are recognized even if moved. For other sources (e.g. command
+// output), only the path is considered.
+func vertSourceID(vertPath string) (string, error) {
+	h := sha256.New()
+	if !isRegularVertical(vertPath) {
+		h.Write([]byte(vertPath))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	f, err := os.Open(vertPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate source checksum: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to calculate source checksum: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isRegularVertical(vertPath string) bool {
+	finfo, err := os.Stat(vertPath)
+	if err != nil {
+		return false
+	}
+	return finfo.Mode().IsRegular()
+}
+
+// CorpusSize contains numbers of positions and basic
+// structures of a corpus (or its imported part)
+type CorpusSize struct {
+	NumTokens    int64 `json:"numTokens"`
+	NumSentences int64 `json:"numSentences"`
+	NumDocs      int64 `json:"numDocs"`
+}
+
+// writeSource registers an imported vertical file. The import
+// time is stored in the provided time zone (the local one if nil).
+func writeSource(
+	tx *sql.Tx,
+	tableName, sourceID, vertPath string,
+	size CorpusSize,
+	loc *time.Location,
+) error {
+	if loc == nil {
+		loc = time.Local
+	}
+	_, err := tx.Exec(
+		fmt.Sprintf(
+			"INSERT INTO %s (source_id, path, num_tokens, num_sentences, num_docs, imported_at) "+
+				"VALUES (?, ?, ?, ?, ?, ?)",
+			tableName,
+		),
+		sourceID, vertPath, size.NumTokens, size.NumSentences, size.NumDocs,
+		time.Now().In(loc).Format(sqlDateTimeLayout),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register imported source: %w", err)
+	}
+	return nil
+}
+
+// IsSourceImported tests whether data with the provided source ID
+// are already included in the live tables
+func (cdb *CollDatabase) IsSourceImported(sourceID string) (bool, error) {
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE source_id = ?", cdb.liveTables().sources),
+		sourceID,
+	)
+	var ans int
+	if err := row.Scan(&ans); err != nil {
+		return false, err
+	}
+	return ans > 0, nil
+}
+
+// GetCorpusSize returns the total size of all the imported sources.
+// In case the data have been imported by an older version which
+// does not record imported sources, ErrNoImportMetadata is returned.
+func (cdb *CollDatabase) GetCorpusSize() (CorpusSize, error) {
+	var ans CorpusSize
+	tableName := cdb.liveTables().sources
+	exists, err := cdb.tableExists(tableName)
+	if err != nil {
+		return ans, err
+	}
+	if !exists {
+		return ans, ErrNoImportMetadata
+	}
+	row := cdb.db.QueryRowContext(
+		cdb.ctx,
+		fmt.Sprintf(
+			"SELECT COUNT(*), COALESCE(SUM(num_tokens), 0), COALESCE(SUM(num_sentences), 0), "+
+				"COALESCE(SUM(num_docs), 0) FROM %s",
+			tableName,
+		),
+	)
+	var numSources int
+	err = row.Scan(&numSources, &ans.NumTokens, &ans.NumSentences, &ans.NumDocs)
+	if err == nil && numSources == 0 {
+		// tables migrated from an older version
+		return ans, ErrNoImportMetadata
+	}
+	return ans, err
+}
@@ -0,0 +1,397 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// approxTableEntryOverhead is a rough estimate of memory occupied
+	// by a single counting table entry (map bucket share, key of interned
+	// string IDs, counter). Strings are accounted for by their pool.
+	approxTableEntryOverhead = 48
+
+	spillFileBufferSize = 1 << 16
+
+	// spillMaxRuns specifies max. number of run files per table.
+	// Once reached, all the runs are merged into a single one
+	// so we do not run out of file descriptors during the final merge.
+	spillMaxRuns = 64
+)
+
+// memoryBudget tracks an estimated amount of memory occupied
+// by counting tables
+type memoryBudget struct {
+	limit int64
+	used  int64
+}
+
+func (mb *memoryBudget) Add(size int) {
+	mb.used += int64(size)
+}
+
+// Exceeded tells whether the tables should be spilled to disk.
+// A budget with zero limit is never exceeded.
+func (mb *memoryBudget) Exceeded() bool {
+	return mb.limit > 0 && mb.used > mb.limit
+}
+
+func (mb *memoryBudget) Reset() {
+	mb.used = 0
+}
+
+// newMemoryBudget creates a budget representing an equal share
+// of a total limit among numWorkers parallel workers
+func newMemoryBudget(limitMB, numWorkers int) *memoryBudget {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	return &memoryBudget{limit: int64(limitMB) * 1024 * 1024 / int64(numWorkers)}
+}
+
+// ------------------------------
+
+// spillRecord is a generic representation of a counting table
+// item as written to (and read from) sorted run files
+type spillRecord struct {
+	Fields []string
+	Freq   int64
+}
+
+func compareFields(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func sortRecords(records []spillRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return compareFields(records[i].Fields, records[j].Fields) < 0
+	})
+}
+
+// recordIterator provides records sorted by their fields with
+// each combination of fields occurring only once
+type recordIterator interface {
+
+	// Next returns a next record or nil if there are no more records
+	Next() (*spillRecord, error)
+
+	Close() error
+}
+
+// sliceIterator iterates over in-memory sorted records
+type sliceIterator struct {
+	records []spillRecord
+	idx     int
+}
+
+func (si *sliceIterator) Next() (*spillRecord, error) {
+	if si.idx >= len(si.records) {
+		return nil, nil
+	}
+	si.idx++
+	return &si.records[si.idx-1], nil
+}
+
+func (si *sliceIterator) Close() error {
+	return nil
+}
+
+// ------------------------------
+
+type runReader struct {
+	file *os.File
+	rd   *bufio.Reader
+	curr *spillRecord
+}
+
+func (rr *runReader) advance() error {
+	line, err := rr.rd.ReadString('\n')
+	if err == io.EOF && line == "" {
+		rr.curr = nil
+		return nil
+
+	} else if err != nil && err != io.EOF {
+		return err
+	}
+	items := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+	freq, err := strconv.ParseInt(items[len(items)-1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid run file %s: %w", rr.file.Name(), err)
+	}
+	rr.curr = &spillRecord{Fields: items[:len(items)-1], Freq: freq}
+	return nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	return compareFields(h[i].curr.Fields, h[j].curr.Fields) < 0
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x any) { *h = append(*h, x.(*runReader)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// mergeIterator performs k-way merge of sorted run files
+// summing frequencies of records with the same fields.
+type mergeIterator struct {
+	readers []*runReader
+	heap    runHeap
+}
+
+func (mi *mergeIterator) Next() (*spillRecord, error) {
+	if len(mi.heap) == 0 {
+		return nil, nil
+	}
+	ans := &spillRecord{Fields: mi.heap[0].curr.Fields}
+	for len(mi.heap) > 0 && compareFields(mi.heap[0].curr.Fields, ans.Fields) == 0 {
+		rr := mi.heap[0]
+		ans.Freq += rr.curr.Freq
+		if err := rr.advance(); err != nil {
+			return nil, err
+		}
+		if rr.curr == nil {
+			heap.Pop(&mi.heap)
+
+		} else {
+			heap.Fix(&mi.heap, 0)
+		}
+	}
+	return ans, nil
+}
+
+func (mi *mergeIterator) Close() error {
+	var ans error
+	for _, rr := range mi.readers {
+		if err := rr.file.Close(); err != nil {
+			ans = err
+		}
+	}
+	return ans
+}
+
+// ------------------------------
+
+// spillStore keeps sorted partial counts of a table
+// in local run files. Runs can be written concurrently by
+// multiple workers.
+type spillStore struct {
+	dir        string
+	prefix     string
+	runs       []string
+	numRecords int
+	mutex      sync.Mutex
+}
+
+func (ss *spillStore) HasRuns() bool {
+	return len(ss.runs) > 0
+}
+
+// NumRecords returns total number of records written to all
+// the runs. As the same record may occur in multiple runs,
+// this is an upper bound of the merged result size.
+func (ss *spillStore) NumRecords() int {
+	return ss.numRecords
+}
+
+func writeRecord(bw *bufio.Writer, rec *spillRecord) {
+	bw.WriteString(strings.Join(rec.Fields, "\t"))
+	bw.WriteByte('\t')
+	bw.WriteString(strconv.FormatInt(rec.Freq, 10))
+	bw.WriteByte('\n')
+}
+
+// WriteRun sorts provided records and writes them into a new run file
+func (ss *spillStore) WriteRun(records []spillRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	sortRecords(records)
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	f, err := os.CreateTemp(ss.dir, ss.prefix+"-*.run")
+	if err != nil {
+		return fmt.Errorf("failed to create run file: %w", err)
+	}
+	ss.runs = append(ss.runs, f.Name())
+	bw := bufio.NewWriterSize(f, spillFileBufferSize)
+	for i := range records {
+		writeRecord(bw, &records[i])
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write run file: %w", err)
+	}
+	ss.numRecords += len(records)
+	log.Debug().
+		Str("file", f.Name()).
+		Int("records", len(records)).
+		Msg("written run file")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if len(ss.runs) >= spillMaxRuns {
+		return ss.compact()
+	}
+	return nil
+}
+
+// compact merges all the current runs into a single one
+func (ss *spillStore) compact() error {
+	iter, err := ss.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	f, err := os.CreateTemp(ss.dir, ss.prefix+"-*.run")
+	if err != nil {
+		return fmt.Errorf("failed to create run file: %w", err)
+	}
+	bw := bufio.NewWriterSize(f, spillFileBufferSize)
+	var numRecords int
+	for {
+		rec, err := iter.Next()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if rec == nil {
+			break
+		}
+		writeRecord(bw, rec)
+		numRecords++
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write run file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if err := ss.Close(); err != nil {
+		return err
+	}
+	ss.runs = append(ss.runs, f.Name())
+	ss.numRecords = numRecords
+	log.Debug().
+		Str("file", f.Name()).
+		Int("records", numRecords).
+		Msg("compacted run files")
+	return nil
+}
+
+// Iterator provides merged records of all the runs.
+// The returned iterator must be closed by the caller.
+func (ss *spillStore) Iterator() (*mergeIterator, error) {
+	return newMergeIterator(ss.runs)
+}
+
+// newMergeIterator creates an iterator merging
+// provided sorted run files
+func newMergeIterator(paths []string) (*mergeIterator, error) {
+	ans := &mergeIterator{
+		readers: make([]*runReader, 0, len(paths)),
+		heap:    make(runHeap, 0, len(paths)),
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			ans.Close()
+			return nil, fmt.Errorf("failed to open run file: %w", err)
+		}
+		rr := &runReader{file: f, rd: bufio.NewReaderSize(f, spillFileBufferSize)}
+		ans.readers = append(ans.readers, rr)
+		if err := rr.advance(); err != nil {
+			ans.Close()
+			return nil, err
+		}
+		if rr.curr != nil {
+			ans.heap = append(ans.heap, rr)
+		}
+	}
+	heap.Init(&ans.heap)
+	return ans, nil
+}
+
+// Close removes all the run files
+func (ss *spillStore) Close() error {
+	var ans error
+	for _, path := range ss.runs {
+		if err := os.Remove(path); err != nil {
+			ans = err
+		}
+	}
+	ss.runs = []string{}
+	return ans
+}
+
+// finishSpilling provides a sorted iterator over all the records counted
+// so far. In case nothing has been spilled yet, the remaining records
+// are iterated directly in memory. Otherwise they are written as a new
+// run and all the runs are merged.
+func finishSpilling(store *spillStore, remaining []spillRecord) (recordIterator, error) {
+	if !store.HasRuns() {
+		sortRecords(remaining)
+		return &sliceIterator{records: remaining}, nil
+	}
+	if len(remaining) > 0 {
+		if err := store.WriteRun(remaining); err != nil {
+			return nil, err
+		}
+	}
+	return store.Iterator()
+}
+
+func newSpillStore(dir, prefix string) *spillStore {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &spillStore{
+		dir:    dir,
+		prefix: prefix,
+		runs:   make([]string, 0, 10),
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestSpillStoreCompaction tests that runs are merged once there are
+// spillMaxRuns of them and that records of the compacted run are
+// merged with the later runs
+func TestSpillStoreCompaction(t *testing.T) {
+	store := newSpillStore(t.TempDir(), "test")
+	defer store.Close()
+	numRuns := 2*spillMaxRuns + 5
+	for i := 0; i < numRuns; i++ {
+		run := []spillRecord{
+			{Fields: []string{"common", "NOUN"}, Freq: 1},
+			{Fields: []string{fmt.Sprintf("w%03d", i), "VERB"}, Freq: int64(i)},
+			// the same fields within a single run are
+			// not expected so each run has its own value
+			{Fields: []string{fmt.Sprintf("w%03d", numRuns-i-1), "ADJ"}, Freq: 2},
+		}
+		if err := store.WriteRun(run); err != nil {
+			t.Fatal(err)
+		}
+		if len(store.runs) >= spillMaxRuns {
+			t.Fatalf("expected less than %d runs, found %d", spillMaxRuns, len(store.runs))
+		}
+	}
+	// each compaction replaces spillMaxRuns runs with a single one
+	if expected := numRuns - 2*(spillMaxRuns-1); len(store.runs) != expected {
+		t.Errorf("expected %d runs after two compactions, found %d", expected, len(store.runs))
+	}
+	iter, err := finishSpilling(store, []spillRecord{{Fields: []string{"common", "NOUN"}, Freq: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	var prev *spillRecord
+	var numRecords int
+	for {
+		rec, err := iter.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec == nil {
+			break
+		}
+		numRecords++
+		if prev != nil && compareFields(prev.Fields, rec.Fields) >= 0 {
+			t.Errorf("records %v and %v are not sorted or unique", prev.Fields, rec.Fields)
+		}
+		prev = rec
+		var expected int64
+		switch rec.Fields[1] {
+		case "NOUN":
+			expected = int64(numRuns) + 10
+		case "ADJ":
+			expected = 2
+		case "VERB":
+			fmt.Sscanf(rec.Fields[0], "w%d", &expected)
+		}
+		if rec.Freq != expected {
+			t.Errorf("expected freq %d of %v, found %d", expected, rec.Fields, rec.Freq)
+		}
+	}
+	if numRecords != 2*numRuns+1 {
+		t.Errorf("expected %d records, found %d", 2*numRuns+1, numRecords)
+	}
+	if store.NumRecords() < numRecords {
+		t.Errorf("expected at least %d stored records, found %d", numRecords, store.NumRecords())
+	}
+}
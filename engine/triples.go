@@ -0,0 +1,294 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"math"
+)
+
+const (
+	// TriplePatternVerbObjObl is a verb along with its object
+	// and an oblique argument (e.g. `take part in (discussion)`)
+	TriplePatternVerbObjObl = "verb-obj-obl"
+
+	// TriplePatternVerbObjNmod is a verb along with its object
+	// and a nominal modifier of the object (e.g. `make decision
+	// about (tax)`)
+	TriplePatternVerbObjNmod = "verb-obj-nmod"
+
+	// TriplePatternNounAmodNmod is a noun along with its adjectival
+	// and nominal modifiers (e.g. `good house with (garden)`)
+	TriplePatternNounAmodNmod = "noun-amod-nmod"
+)
+
+// TriplePattern describes a kind of head-centered triples,
+// i.e. a head along with two of its dependents
+type TriplePattern struct {
+	Name string
+
+	// HeadPos is a PoS of the head
+	HeadPos string
+
+	// FirstRel is a relation (a deprel expression)
+	// of the first dependent to the head
+	FirstRel string
+
+	// SecondRel is a relation (a deprel expression)
+	// of the second dependent
+	SecondRel string
+
+	// Nested if true then the second dependent is attached
+	// to the first dependent instead of the head
+	Nested bool
+}
+
+// TriplePatterns returns patterns of triples counted for the corpus.
+// Patterns with relations which are not configured are omitted.
+func (conf *SyntaxProps) TriplePatterns() []TriplePattern {
+	ans := make([]TriplePattern, 0, 3)
+	if conf.ObliqueValue != "" {
+		ans = append(ans, TriplePattern{
+			Name:      TriplePatternVerbObjObl,
+			HeadPos:   conf.VerbValue,
+			FirstRel:  conf.NounObjectValue,
+			SecondRel: conf.ObliqueValue,
+		})
+	}
+	return append(
+		ans,
+		TriplePattern{
+			Name:      TriplePatternVerbObjNmod,
+			HeadPos:   conf.VerbValue,
+			FirstRel:  conf.NounObjectValue,
+			SecondRel: conf.NounModifiedValue,
+			Nested:    true,
+		},
+		TriplePattern{
+			Name:      TriplePatternNounAmodNmod,
+			HeadPos:   conf.NounValue,
+			FirstRel:  conf.AdjModifierValue,
+			SecondRel: conf.NounModifiedValue,
+		},
+	)
+}
+
+// TriplePattern returns a triple pattern of the provided name
+func (conf *SyntaxProps) TriplePattern(name string) (TriplePattern, bool) {
+	for _, pattern := range conf.TriplePatterns() {
+		if pattern.Name == name {
+			return pattern, true
+		}
+	}
+	return TriplePattern{}, false
+}
+
+// tripleKey identifies a TripleTable item by IDs of interned strings
+type tripleKey struct {
+	pattern uint32
+	lemma   uint32
+	upos    uint32
+	lemma1  uint32
+	upos1   uint32
+	lemma2  uint32
+	upos2   uint32
+	marker  uint32
+}
+
+// TripleTable contains frequencies of head-centered triples
+// (see TriplePattern). Strings are interned in the table's pool.
+type TripleTable struct {
+	pool  *stringPool
+	items map[tripleKey]int64
+}
+
+// add adds a value to a respective item and returns an estimated
+// number of bytes the table has grown by
+func (table TripleTable) add(key tripleKey, val int64) int {
+	v, ok := table.items[key]
+	table.items[key] = v + val
+	if !ok {
+		return approxTableEntryOverhead
+	}
+	return 0
+}
+
+func (table TripleTable) Len() int {
+	return len(table.items)
+}
+
+// Merge adds all the items of another table
+func (table TripleTable) Merge(other TripleTable) {
+	tr := table.pool.translator(other.pool)
+	for k, v := range other.items {
+		table.add(
+			tripleKey{
+				pattern: tr(k.pattern),
+				lemma:   tr(k.lemma),
+				upos:    tr(k.upos),
+				lemma1:  tr(k.lemma1),
+				upos1:   tr(k.upos1),
+				lemma2:  tr(k.lemma2),
+				upos2:   tr(k.upos2),
+				marker:  tr(k.marker),
+			},
+			v,
+		)
+	}
+}
+
+// records exports table items with fields ordered
+// as the key columns of triplesColumns
+func (table TripleTable) records() []spillRecord {
+	ans := make([]spillRecord, 0, len(table.items))
+	for k, v := range table.items {
+		ans = append(
+			ans,
+			spillRecord{
+				Fields: []string{
+					table.pool.Value(k.pattern),
+					table.pool.Value(k.lemma),
+					table.pool.Value(k.upos),
+					table.pool.Value(k.lemma1),
+					table.pool.Value(k.upos1),
+					table.pool.Value(k.lemma2),
+					table.pool.Value(k.upos2),
+					table.pool.Value(k.marker),
+				},
+				Freq: v,
+			},
+		)
+	}
+	return ans
+}
+
+func newTripleTable(pool *stringPool) TripleTable {
+	return TripleTable{pool: pool, items: make(map[tripleKey]int64)}
+}
+
+// tripleMatcher finds triples of a single pattern within sentences
+type tripleMatcher struct {
+	pattern uint32
+	headPos uint32
+	first   *relationCache
+	second  *relationCache
+	nested  bool
+}
+
+func newTripleMatchers(conf *SyntaxProps, pool *stringPool) []tripleMatcher {
+	// triples are not split by relation subtypes
+	patterns := conf.TriplePatterns()
+	ans := make([]tripleMatcher, len(patterns))
+	for i, pattern := range patterns {
+		ans[i] = tripleMatcher{
+			pattern: pool.ID(pattern.Name),
+			headPos: pool.ID(pattern.HeadPos),
+			first:   newRelationCache(newRelationMatcher([]string{pattern.FirstRel}, true), pool),
+			second:  newRelationCache(newRelationMatcher([]string{pattern.SecondRel}, true), pool),
+			nested:  pattern.Nested,
+		}
+	}
+	return ans
+}
+
+// procTriples counts head-centered triples of a complete sentence.
+// The `markers` contain markers of the sentence tokens (see caseMarkers).
+func (vp *VertProcessor) procTriples(tokens []sentToken, markers []uint32) {
+	vp.children = sentenceChildren(tokens, vp.children)
+	for _, tm := range vp.tripleMatchers {
+		for h, head := range tokens {
+			if !head.valid || head.upos != tm.headPos {
+				continue
+			}
+			for _, d1 := range vp.children[h] {
+				if len(tm.first.relations(tokens[d1].deprel)) == 0 {
+					continue
+				}
+				seconds := vp.children[h]
+				if tm.nested {
+					seconds = vp.children[d1]
+				}
+				for _, d2 := range seconds {
+					if d2 == d1 || len(tm.second.relations(tokens[d2].deprel)) == 0 {
+						continue
+					}
+					key := tripleKey{
+						pattern: tm.pattern,
+						lemma:   head.lemma,
+						upos:    head.upos,
+						lemma1:  tokens[d1].lemma,
+						upos1:   tokens[d1].upos,
+						lemma2:  tokens[d2].lemma,
+						upos2:   tokens[d2].upos,
+						marker:  markers[d2],
+					}
+					vp.budget.Add(vp.Triples.add(key, 1))
+				}
+			}
+		}
+	}
+}
+
+var (
+	triplesColumns = []string{
+		"pattern", "lemma", "upos", "lemma1", "upos1", "lemma2", "upos2", "marker",
+		"freq", "score",
+	}
+)
+
+// tripleScore calculates a generalized logDice of a triple, i.e.
+// 14 + log2(3 * f(x, y, z) / (f(x) + f(y) + f(z))). In case any of the
+// token frequencies is unknown (composite lemmas of multiword expressions,
+// see writeFxy), the score is invalid (-Inf) so such triples are not
+// ranked above attested ones.
+func tripleScore(fxyz, fx, fy, fz int64) float64 {
+	if fx == 0 || fy == 0 || fz == 0 {
+		return math.Inf(-1)
+	}
+	return 14 + math.Log2(3*float64(fxyz)/float64(fx+fy+fz))
+}
+
+// writeTriples writes triples along with their scores. The sink
+// must have triplesColumns.
+func writeTriples(sink rowSink, records recordIterator, tokenCounts FyTable) (int64, error) {
+	values := make([]any, len(triplesColumns))
+	for {
+		rec, err := records.Next()
+		if err != nil {
+			sink.Abort()
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		for i, v := range rec.Fields {
+			values[i] = v
+		}
+		values[8] = rec.Freq
+		values[9] = sqlFloat(
+			tripleScore(
+				rec.Freq,
+				tokenCounts.Get(rec.Fields[1], rec.Fields[2], ""),
+				tokenCounts.Get(rec.Fields[3], rec.Fields[4], ""),
+				tokenCounts.Get(rec.Fields[5], rec.Fields[6], ""),
+			),
+		)
+		if err := sink.Add(values...); err != nil {
+			return 0, err
+		}
+	}
+	return sink.Finish()
+}
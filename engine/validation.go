@@ -0,0 +1,324 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tomachalek/vertigo/v5"
+)
+
+const (
+	ErrCategoryTooFewColumns = "tooFewColumns"
+	ErrCategoryEmptyLemma    = "emptyLemma"
+	ErrCategoryUnknownDeprel = "unknownDeprel"
+	ErrCategoryBrokenParent  = "brokenParent"
+
+	// maxNumErrorExamples specifies how many erroneous lines
+	// of each category are listed in the validation report
+	maxNumErrorExamples = 20
+)
+
+// udRelations contains universal dependency relations (including
+// the ones from UD v1 which can still be found in older corpora).
+// Language specific subtypes (e.g. `nmod:poss`) are validated
+// by their base relation. As corpora may use other tagsets (their
+// relations are configured in SyntaxProps), unknown relations are
+// reported only as warnings.
+var udRelations = map[string]bool{
+	"acl": true, "advcl": true, "advmod": true, "amod": true, "appos": true,
+	"aux": true, "case": true, "cc": true, "ccomp": true, "clf": true,
+	"compound": true, "conj": true, "cop": true, "csubj": true, "dep": true,
+	"det": true, "discourse": true, "dislocated": true, "expl": true,
+	"fixed": true, "flat": true, "goeswith": true, "iobj": true, "list": true,
+	"mark": true, "nmod": true, "nsubj": true, "nummod": true, "obj": true,
+	"obl": true, "orphan": true, "parataxis": true, "punct": true,
+	"reparandum": true, "root": true, "vocative": true, "xcomp": true,
+	// UD v1
+	"dobj": true, "nsubjpass": true, "csubjpass": true, "auxpass": true,
+	"name": true, "mwe": true, "neg": true, "remnant": true, "foreign": true,
+}
+
+func isKnownDeprel(value string) bool {
+	for _, deprel := range strings.Split(value, "|") {
+		base, _, _ := strings.Cut(deprel, ":")
+		if !udRelations[base] {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------------------------
+
+// ErrorExample is an erroneous vertical line listed
+// in a validation report
+type ErrorExample struct {
+	Line    int    `json:"line"`
+	Details string `json:"details"`
+}
+
+// ErrorCategoryReport summarizes errors of a single category
+type ErrorCategoryReport struct {
+	Count int `json:"count"`
+
+	// Warning is true for categories not counted
+	// toward the error budget
+	Warning  bool           `json:"warning"`
+	Examples []ErrorExample `json:"examples"`
+}
+
+// ValidationReport summarizes errors found in a vertical file
+type ValidationReport struct {
+	VerticalPath string                          `json:"verticalPath"`
+	NumErrors    int                             `json:"numErrors"`
+	NumWarnings  int                             `json:"numWarnings"`
+	MaxNumErrors int                             `json:"maxNumErrors"`
+	Aborted      bool                            `json:"aborted"`
+	Categories   map[string]*ErrorCategoryReport `json:"categories"`
+}
+
+// vertValidator collects errors found in a vertical file by (possibly
+// multiple concurrent) processors. Once the number of errors exceeds
+// the configured limit, all the processors are stopped.
+type vertValidator struct {
+	report     ValidationReport
+	numColumns int
+	mutex      sync.Mutex
+	failed     atomic.Bool
+}
+
+// Err returns an error in case the error budget has been
+// exceeded (by any of the processors)
+func (vv *vertValidator) Err() error {
+	if vv.failed.Load() {
+		return fmt.Errorf(
+			"too many errors in vertical file (limit %d exceeded)", vv.report.MaxNumErrors)
+	}
+	return nil
+}
+
+// register adds an error (or a warning) to the report.
+// The mutex must be locked by the caller.
+func (vv *vertValidator) register(category string, line int, details string, warning bool) {
+	cat, ok := vv.report.Categories[category]
+	if !ok {
+		cat = &ErrorCategoryReport{
+			Warning:  warning,
+			Examples: make([]ErrorExample, 0, maxNumErrorExamples),
+		}
+		vv.report.Categories[category] = cat
+	}
+	cat.Count++
+	if len(cat.Examples) < maxNumErrorExamples {
+		cat.Examples = append(cat.Examples, ErrorExample{Line: line, Details: details})
+	}
+}
+
+// Warn registers a suspicious line which does not prevent the data
+// from being processed. Warnings do not count toward the error budget.
+func (vv *vertValidator) Warn(category string, line int, details string) {
+	vv.mutex.Lock()
+	defer vv.mutex.Unlock()
+	vv.register(category, line, details, true)
+	vv.report.NumWarnings++
+}
+
+// Report registers an error. It returns an error in case
+// the error budget has been exceeded.
+func (vv *vertValidator) Report(category string, line int, details string) error {
+	vv.mutex.Lock()
+	defer vv.mutex.Unlock()
+	vv.register(category, line, details, false)
+	vv.report.NumErrors++
+	if vv.report.MaxNumErrors >= 0 && vv.report.NumErrors > vv.report.MaxNumErrors {
+		vv.report.Aborted = true
+		vv.failed.Store(true)
+	}
+	return vv.Err()
+}
+
+// checkColumns tests whether a token provides all the columns required
+// by the configuration and whether its lemma is not empty. It does not
+// report anything so it can be used also by processors not responsible
+// for validation.
+func (vv *vertValidator) checkColumns(token *vertigo.Token, lemmaCol int) (string, string) {
+	if len(token.Attrs) < vv.numColumns {
+		return ErrCategoryTooFewColumns, fmt.Sprintf(
+			"expected %d columns, found %d", vv.numColumns+1, len(token.Attrs)+1)
+	}
+	if strings.TrimSpace(token.Attrs[lemmaCol-1]) == "" {
+		return ErrCategoryEmptyLemma, "empty lemma"
+	}
+	return "", ""
+}
+
+// validateToken checks a token and reports found errors. It returns
+// false in case the token cannot be processed (missing columns etc.).
+// Parent references are registered within the provided sentence.
+func (vv *vertValidator) validateToken(
+	token *vertigo.Token,
+	line int,
+	conf *SyntaxProps,
+	sentence *sentenceParents,
+) (bool, error) {
+	if category, details := vv.checkColumns(token, conf.LemmaAttr.VerticalCol); category != "" {
+		sentence.Skip()
+		return false, vv.Report(category, line, details)
+	}
+	deprel := token.Attrs[conf.FuncAttr.VerticalCol-1]
+	if !isKnownDeprel(deprel) {
+		vv.Warn(ErrCategoryUnknownDeprel, line, fmt.Sprintf("unknown deprel '%s'", deprel))
+	}
+	parentRef := token.Attrs[conf.ParentIdxAttr.VerticalCol-1]
+	if details := sentence.Add(parentRef, line); details != "" {
+		if err := vv.Report(ErrCategoryBrokenParent, line, details); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// closeSentence reports invalid parent references
+// of a finished sentence
+func (vv *vertValidator) closeSentence(sentence *sentenceParents) error {
+	for _, brokenRef := range sentence.Close() {
+		if err := vv.Report(ErrCategoryBrokenParent, brokenRef.Line, brokenRef.Details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish logs a summary of found errors and (if reportPath is
+// not empty) writes the complete report as a JSON file
+func (vv *vertValidator) Finish(reportPath string) error {
+	vv.mutex.Lock()
+	defer vv.mutex.Unlock()
+	evt := log.Info()
+	if vv.report.NumErrors > 0 || vv.report.NumWarnings > 0 {
+		evt = log.Warn()
+	}
+	for category, cat := range vv.report.Categories {
+		evt = evt.Int(category, cat.Count)
+		// with multiple workers, examples are not registered in order
+		sort.Slice(cat.Examples, func(i, j int) bool {
+			return cat.Examples[i].Line < cat.Examples[j].Line
+		})
+	}
+	evt.
+		Int("numErrors", vv.report.NumErrors).
+		Int("numWarnings", vv.report.NumWarnings).
+		Bool("aborted", vv.report.Aborted).
+		Msg("vertical file validation summary")
+	if reportPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(vv.report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to write validation report: %w", err)
+	}
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write validation report: %w", err)
+	}
+	log.Info().Str("path", reportPath).Msg("validation report written")
+	return nil
+}
+
+func newVertValidator(vertPath string, conf *SyntaxProps, maxNumErrors int) *vertValidator {
+	return &vertValidator{
+		report: ValidationReport{
+			VerticalPath: vertPath,
+			MaxNumErrors: maxNumErrors,
+			Categories:   make(map[string]*ErrorCategoryReport),
+		},
+		numColumns: conf.RequiredNumColumns(),
+	}
+}
+
+// ------------------------------
+
+// sentenceParents checks parent references of tokens within
+// a sentence. As a chunk of a vertical file processed by a worker
+// may start or end in the middle of a sentence, only the format
+// of references is checked for such sentences.
+type sentenceParents struct {
+
+	// isComplete is true if the sentence start has been seen
+	isComplete bool
+
+	numTokens int
+
+	// targets contains positions of parents of all the sentence
+	// tokens along with respective line numbers
+	targets [][2]int
+}
+
+// Add registers a token's parent reference. It returns
+// error details in case the reference is invalid.
+func (sp *sentenceParents) Add(parentRef string, line int) string {
+	pos := sp.numTokens
+	sp.numTokens++
+	offset, err := strconv.Atoi(parentRef)
+	if err != nil {
+		return fmt.Sprintf("invalid parent reference '%s'", parentRef)
+	}
+	if offset == 0 || !sp.isComplete {
+		return ""
+	}
+	if pos+offset < 0 {
+		return fmt.Sprintf("parent reference %d points before sentence start", offset)
+	}
+	sp.targets = append(sp.targets, [2]int{pos + offset, line})
+	return ""
+}
+
+// Close validates references pointing forward once the sentence
+// length is known. It returns line numbers and details of
+// invalid references.
+func (sp *sentenceParents) Close() []ErrorExample {
+	ans := make([]ErrorExample, 0, 1)
+	for _, target := range sp.targets {
+		if target[0] >= sp.numTokens {
+			ans = append(ans, ErrorExample{
+				Line:    target[1],
+				Details: "parent reference points after sentence end",
+			})
+		}
+	}
+	sp.Reset(true)
+	return ans
+}
+
+// Skip registers a token without a parent reference
+func (sp *sentenceParents) Skip() {
+	sp.numTokens++
+}
+
+func (sp *sentenceParents) Reset(isComplete bool) {
+	sp.isComplete = isComplete
+	sp.numTokens = 0
+	sp.targets = sp.targets[:0]
+}
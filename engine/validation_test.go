@@ -0,0 +1,73 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tomachalek/vertigo/v5"
+)
+
+func testToken(t *testing.T, lemma, deprel string, parentOffset int) *vertigo.Token {
+	value, err := parseVertLine(fmt.Sprintf(
+		"%s\t%s\tx\t%s\tNOUN\tx\t_\t_\t_\t%s\t%d\tp\tVERB",
+		lemma, lemma, lemma, deprel, parentOffset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value.(*vertigo.Token)
+}
+
+// TestValidatorUnknownDeprelsAreWarnings tests that relations of
+// tagsets other than UD (here PDT) do not exhaust the error budget
+func TestValidatorUnknownDeprelsAreWarnings(t *testing.T) {
+	conf := testSyntaxProps(t)
+	validator := newVertValidator("test.vert", conf, 2)
+	var sentence sentenceParents
+	sentence.Reset(true)
+	for i := 0; i < 100; i++ {
+		ok, err := validator.validateToken(testToken(t, "pes", "Sb", 0), i+1, conf, &sentence)
+		if err != nil {
+			t.Fatalf("unexpected error on line %d: %s", i+1, err)
+		}
+		if !ok {
+			t.Fatalf("expected token on line %d to be accepted", i+1)
+		}
+	}
+	if validator.report.NumErrors != 0 {
+		t.Errorf("expected no errors, found %d", validator.report.NumErrors)
+	}
+	if validator.report.NumWarnings != 100 {
+		t.Errorf("expected 100 warnings, found %d", validator.report.NumWarnings)
+	}
+	cat := validator.report.Categories[ErrCategoryUnknownDeprel]
+	if cat == nil || !cat.Warning || cat.Count != 100 {
+		t.Errorf("expected 100 warnings of category %s, found %+v", ErrCategoryUnknownDeprel, cat)
+	}
+
+	// errors still count toward the budget
+	for i := 0; i < 3; i++ {
+		_, err := validator.validateToken(testToken(t, " ", "nsubj", 0), 101+i, conf, &sentence)
+		if i < 2 && err != nil {
+			t.Fatalf("unexpected error on line %d: %s", 101+i, err)
+		}
+		if i == 2 && err == nil {
+			t.Error("expected exceeded error budget")
+		}
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tomachalek/vertigo/v5"
+)
+
+const (
+	chunkReaderBufferSize = 1 << 20
+)
+
+var (
+	structTagRegexp  = regexp.MustCompile(`^<([\w\d\p{Po}]+)(\s+.*?|)/?>$`)
+	structAttrRegexp = regexp.MustCompile(`(\w+)="([^"]+)"`)
+)
+
+// chunkProcessor is a vertigo.LineProcessor which is also able
+// to handle tokens located after the end of its chunk (e.g. to
+// complete co-occurrence windows crossing chunk boundaries).
+type chunkProcessor interface {
+	vertigo.LineProcessor
+
+	// ProcOverrunToken handles a token located after the end
+	// of the processed chunk. It returns true if the processor
+	// needs more such tokens.
+	ProcOverrunToken(token *vertigo.Token, line int) (bool, error)
+}
+
+// structOverrunProcessor is a chunkProcessor which also needs
+// structures located after the end of its chunk (e.g. to complete
+// a sentence crossing the chunk boundary)
+type structOverrunProcessor interface {
+
+	// ProcOverrunStruct handles a structure located after the end
+	// of the processed chunk. It returns true if the processor
+	// needs more overrun lines.
+	ProcOverrunStruct(strc *vertigo.Structure, line int) (bool, error)
+
+	// ProcOverrunStructClose handles a closing structure located
+	// after the end of the processed chunk. It returns true if
+	// the processor needs more overrun lines.
+	ProcOverrunStructClose(strc *vertigo.StructureClose, line int) (bool, error)
+}
+
+// chunkStarter is a chunkProcessor which has to be notified
+// before it starts to process its chunk (e.g. to find out whether
+// leading lines may belong to a sentence started within
+// a previous chunk)
+type chunkStarter interface {
+	StartChunk(chunk vertChunk) error
+}
+
+// startChunk notifies a processor about its chunk
+// (if the processor is interested)
+func startChunk(proc chunkProcessor, chunk vertChunk) error {
+	if st, ok := proc.(chunkStarter); ok {
+		return st.StartChunk(chunk)
+	}
+	return nil
+}
+
+// chunkFinisher is a chunkProcessor which has to be notified
+// once all the lines of its chunk (including the overrun ones)
+// have been processed
+type chunkFinisher interface {
+	FinishChunk() error
+}
+
+// finishChunk notifies a processor about the end of its chunk
+// (if the processor is interested)
+func finishChunk(proc chunkProcessor) error {
+	if fin, ok := proc.(chunkFinisher); ok {
+		return fin.FinishChunk()
+	}
+	return nil
+}
+
+// vertChunk is a byte range of a vertical file. Both
+// `start` and `end` are located at line beginnings.
+type vertChunk struct {
+	start int64
+	end   int64
+
+	// firstLine is an index of the first line of the chunk
+	// within the whole file
+	firstLine int
+}
+
+// isSplittableVertical tells whether a vertical file can be
+// processed in chunks (i.e. it is a plain regular file)
+func isSplittableVertical(path string) bool {
+	if strings.HasPrefix(path, "|") || strings.HasSuffix(path, ".gz") {
+		return false
+	}
+	return isRegularVertical(path)
+}
+
+// splitVertical splits a vertical file into (at most) numChunks
+// chunks of approximately the same size. Chunk boundaries are
+// always aligned to line beginnings.
+func splitVertical(path string, numChunks int) ([]vertChunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return []vertChunk{}, err
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return []vertChunk{}, err
+	}
+	size := finfo.Size()
+	bounds := make([]int64, 0, numChunks+1)
+	bounds = append(bounds, 0)
+	for i := 1; i < numChunks; i++ {
+		offset := size * int64(i) / int64(numChunks)
+		if offset <= bounds[len(bounds)-1] {
+			continue
+		}
+		if _, err := f.Seek(offset-1, io.SeekStart); err != nil {
+			return []vertChunk{}, err
+		}
+		// we start one byte before the offset so in case the offset
+		// is already a line beginning, we stay there
+		rest, err := bufio.NewReader(f).ReadString('\n')
+		if err == io.EOF {
+			break
+
+		} else if err != nil {
+			return []vertChunk{}, err
+		}
+		lineStart := offset - 1 + int64(len(rest))
+		if lineStart > bounds[len(bounds)-1] && lineStart < size {
+			bounds = append(bounds, lineStart)
+		}
+	}
+	bounds = append(bounds, size)
+
+	ans := make([]vertChunk, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		ans[i] = vertChunk{start: bounds[i], end: bounds[i+1]}
+	}
+	if err := countChunkLines(f, ans); err != nil {
+		return []vertChunk{}, err
+	}
+	return ans, nil
+}
+
+// countChunkLines sets global line offsets of chunks
+// so we are able to report proper line numbers
+func countChunkLines(f *os.File, chunks []vertChunk) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkReaderBufferSize)
+	var lines int
+	for i := range chunks {
+		chunks[i].firstLine = lines
+		rd := io.LimitReader(f, chunks[i].end-chunks[i].start)
+		for {
+			n, err := rd.Read(buf)
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			if err == io.EOF {
+				break
+
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseStructAttrs(src string) map[string]string {
+	ans := make(map[string]string)
+	for _, m := range structAttrRegexp.FindAllStringSubmatch(src, -1) {
+		ans[m[1]] = m[2]
+	}
+	return ans
+}
+
+// parseVertLine parses a vertical line the same way
+// vertigo does except for structural attributes which
+// are not attached to tokens.
+func parseVertLine(line string) (any, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "<") && strings.HasSuffix(line, ">") {
+		if strings.HasPrefix(line, "</") {
+			return &vertigo.StructureClose{Name: strings.TrimSpace(line[2 : len(line)-1])}, nil
+		}
+		srch := structTagRegexp.FindStringSubmatch(line)
+		if len(srch) < 3 {
+			return nil, fmt.Errorf("cannot parse element '%s'", line)
+		}
+		return &vertigo.Structure{
+			Name:    srch[1],
+			Attrs:   parseStructAttrs(srch[2]),
+			IsEmpty: strings.HasSuffix(line, "/>"),
+		}, nil
+	}
+	items := strings.Split(line, "\t")
+	return &vertigo.Token{Word: items[0], Attrs: items[1:]}, nil
+}
+
+// parseVerticalChunk reads a chunk of a vertical file and passes
+// parsed lines to a provided processor. Once the chunk is finished,
+// the function continues to read tokens (and structures in case of
+// a structOverrunProcessor) as long as the processor requires them
+// (see chunkProcessor.ProcOverrunToken).
+// If progress is not nil, the function reports processed data
+// as a worker with the provided index.
+func parseVerticalChunk(
+	path string,
+	chunk vertChunk,
+	proc chunkProcessor,
+	progress *importProgress,
+	worker int,
+) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(chunk.start, io.SeekStart); err != nil {
+		return err
+	}
+	if err := startChunk(proc, chunk); err != nil {
+		return err
+	}
+	rd := bufio.NewReaderSize(f, chunkReaderBufferSize)
+	pos := chunk.start
+	lineNum := chunk.firstLine
+	var numBytes, numLines, numTokens int64
+	flushProgress := func() {
+		if progress != nil {
+			progress.update(worker, numBytes, numLines, numTokens, proc)
+		}
+		numBytes, numLines, numTokens = 0, 0, 0
+	}
+	defer flushProgress()
+	strcProc, hasStrcOverrun := proc.(structOverrunProcessor)
+	for {
+		line, err := rd.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		lineStart := pos
+		pos += int64(len(line))
+		value, parseErr := parseVertLine(line)
+		if lineStart >= chunk.end {
+			more := true
+			var err error
+			switch tValue := value.(type) {
+			case *vertigo.Token:
+				more, err = proc.ProcOverrunToken(tValue, lineNum)
+			case *vertigo.Structure:
+				if hasStrcOverrun {
+					more, err = strcProc.ProcOverrunStruct(tValue, lineNum)
+				}
+			case *vertigo.StructureClose:
+				if hasStrcOverrun {
+					more, err = strcProc.ProcOverrunStructClose(tValue, lineNum)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if !more {
+				break
+			}
+
+		} else {
+			numBytes += int64(len(line))
+			numLines++
+			if numLines == progressFlushNumLines {
+				flushProgress()
+			}
+			var procErr error
+			switch tValue := value.(type) {
+			case *vertigo.Token:
+				numTokens++
+				procErr = proc.ProcToken(tValue, lineNum, parseErr)
+			case *vertigo.Structure:
+				procErr = proc.ProcStruct(tValue, lineNum, parseErr)
+			case *vertigo.StructureClose:
+				procErr = proc.ProcStructClose(tValue, lineNum, parseErr)
+			}
+			if procErr != nil {
+				return procErr
+			}
+		}
+		lineNum++
+	}
+	return finishChunk(proc)
+}
+
+// parseVerticalParallel processes a vertical file by up to numWorkers
+// processors created by `factory`, each of them handling its own chunk
+// of the file. In case the file cannot be split (gzipped file, command
+// output), a single processor is used. The progress argument is optional.
+func parseVerticalParallel[T chunkProcessor](
+	vertPath string,
+	numWorkers int,
+	progress *importProgress,
+	factory func() T,
+) ([]T, error) {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if !isSplittableVertical(vertPath) {
+		if numWorkers > 1 {
+			log.Warn().
+				Str("path", vertPath).
+				Msg("vertical file cannot be processed in chunks, using a single worker")
+		}
+		pc := &vertigo.ParserConf{
+			InputFilePath:         vertPath,
+			Encoding:              "utf-8",
+			StructAttrAccumulator: "comb",
+		}
+		proc := factory()
+		if progress == nil {
+			if err := vertigo.ParseVerticalFile(pc, proc); err != nil {
+				return []T{proc}, err
+			}
+			return []T{proc}, finishChunk(proc)
+		}
+		tracked := &trackedProcessor[T]{proc: proc, progress: progress}
+		err := vertigo.ParseVerticalFile(pc, tracked)
+		tracked.flush()
+		if err != nil {
+			return []T{proc}, err
+		}
+		return []T{proc}, finishChunk(proc)
+	}
+	chunks, err := splitVertical(vertPath, numWorkers)
+	if err != nil {
+		return []T{}, fmt.Errorf("failed to split vertical file: %w", err)
+	}
+	log.Info().Int("numChunks", len(chunks)).Msg("processing vertical file in parallel")
+	procs := make([]T, len(chunks))
+	errs := make([]error, len(chunks))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		procs[i] = factory()
+		wg.Add(1)
+		go func(i int, chunk vertChunk) {
+			defer wg.Done()
+			errs[i] = parseVerticalChunk(vertPath, chunk, procs[i], progress, i)
+		}(i, chunk)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return procs, err
+		}
+	}
+	return procs, nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	testUpos    = []string{"NOUN", "VERB", "ADJ", "ADP", "DET", "PROPN"}
+	testDeprels = []string{
+		"nsubj", "obj", "nmod", "amod", "case", "det", "obl", "conj", "cc", "flat"}
+)
+
+// testVerticalSpec describes a generated vertical file
+type testVerticalSpec struct {
+	numTokens int
+	vocabSize int
+
+	// longSentences contains lengths of sentences inserted
+	// into the generated data (e.g. to exceed maxSentenceTokens)
+	longSentences []int
+
+	// outsideEvery specifies how often tokens outside sentences
+	// are generated (zero for never)
+	outsideEvery int
+}
+
+// testSyntaxProps returns a configuration matching
+// files created by writeTestVertical
+func testSyntaxProps(tb testing.TB) *SyntaxProps {
+	ans := &SyntaxProps{
+		ParentIdxAttr:     PosAttrProps{Name: "parent", VerticalCol: 10},
+		LemmaAttr:         PosAttrProps{Name: "lemma", VerticalCol: 3},
+		ParLemmaAttr:      PosAttrProps{Name: "p_lemma", VerticalCol: 11},
+		PosAttr:           PosAttrProps{Name: "upos", VerticalCol: 4},
+		ParPosAttr:        PosAttrProps{Name: "p_upos", VerticalCol: 12},
+		FuncAttr:          PosAttrProps{Name: "deprel", VerticalCol: 9},
+		NounValue:         "NOUN",
+		VerbValue:         "VERB",
+		NounModifiedValue: "nmod",
+		NounSubjectValue:  "nsubj",
+		NounObjectValue:   "obj|iobj",
+		ObliqueValue:      "obl",
+		ConjValue:         "conj",
+		MergeMultiwords:   true,
+	}
+	if err := ans.ValidateAndDefaults("test"); err != nil {
+		tb.Fatal(err)
+	}
+	return ans
+}
+
+// writeTestVertical generates a deterministic vertical file
+// with random sentences and returns its path
+func writeTestVertical(tb testing.TB, spec testVerticalSpec) string {
+	path := filepath.Join(tb.TempDir(), "test.vert")
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	rnd := rand.New(rand.NewSource(7))
+	writeTokens := func(length int, maxDist int) {
+		lemmas := make([]string, length)
+		upos := make([]string, length)
+		for i := range lemmas {
+			lemmas[i] = fmt.Sprintf("w%d", rnd.Intn(spec.vocabSize))
+			upos[i] = testUpos[rnd.Intn(len(testUpos))]
+		}
+		root := rnd.Intn(length)
+		for i := range lemmas {
+			offset, pLemma, pUpos, deprel := 0, "_", "_", "root"
+			if i != root && length > 1 {
+				p := i
+				for p == i || p < 0 || p >= length {
+					p = i + rnd.Intn(2*maxDist+1) - maxDist
+				}
+				offset, pLemma, pUpos = p-i, lemmas[p], upos[p]
+				deprel = testDeprels[rnd.Intn(len(testDeprels))]
+			}
+			fmt.Fprintf(
+				w, "%s\t%s\tx\t%s\t%s\tx\t_\t_\t_\t%s\t%d\t%s\t%s\n",
+				lemmas[i], lemmas[i], lemmas[i], upos[i], deprel, offset, pLemma, pUpos)
+		}
+	}
+	fmt.Fprintln(w, `<doc id="d1">`)
+	numTokens := 0
+	for i := 0; numTokens < spec.numTokens; i++ {
+		length := 1 + rnd.Intn(30)
+		if len(spec.longSentences) > 0 && i > 0 && i%100 == 0 {
+			length = spec.longSentences[0]
+			spec.longSentences = spec.longSentences[1:]
+		}
+		if spec.outsideEvery > 0 && i%spec.outsideEvery == 0 {
+			writeTokens(1+rnd.Intn(5), 2)
+		}
+		fmt.Fprintln(w, "<s>")
+		writeTokens(length, 5)
+		fmt.Fprintln(w, "</s>")
+		numTokens += length
+	}
+	fmt.Fprintln(w, "</doc>")
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// sortedRecords converts records into sorted strings
+// so tables of different processors can be compared
+func sortedRecords(records []spillRecord) []string {
+	ans := make([]string, len(records))
+	for i, rec := range records {
+		ans[i] = fmt.Sprintf("%s:%d", strings.Join(rec.Fields, "|"), rec.Freq)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func compareRecords(t *testing.T, table string, expected, found []spillRecord) {
+	exp := sortedRecords(expected)
+	fnd := sortedRecords(found)
+	if len(exp) != len(fnd) {
+		t.Errorf("table %s: expected %d records, found %d", table, len(exp), len(fnd))
+	}
+	for i := 0; i < len(exp) && i < len(fnd); i++ {
+		if exp[i] != fnd[i] {
+			t.Errorf("table %s: expected record %s, found %s", table, exp[i], fnd[i])
+			return
+		}
+	}
+}
+
+// countResult contains merged processors of a vertical file
+// along with spill stores of their tables
+type countResult struct {
+	proc   *VertProcessor
+	coProc *CoVertProcessor
+	spills *importSpills
+}
+
+// tables provides records of all the counting tables,
+// including the ones spilled to disk
+func (cr *countResult) tables(tb testing.TB) map[string][]spillRecord {
+	ans := make(map[string][]spillRecord)
+	for _, item := range []struct {
+		name    string
+		store   *spillStore
+		records []spillRecord
+	}{
+		{"fcolls", cr.spills.table, cr.proc.Table.records()},
+		{"childSums", cr.spills.children, cr.proc.ChildCounts.records()},
+		{"parentSums", cr.spills.parents, cr.proc.ParentCounts.records()},
+		{"triples", cr.spills.triples, cr.proc.Triples.records()},
+		{"coOccs", cr.spills.coOccs, cr.coProc.CoOccTable.records()},
+	} {
+		iter, err := finishSpilling(item.store, item.records)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ans[item.name] = make([]spillRecord, 0, len(item.records))
+		for {
+			rec, err := iter.Next()
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if rec == nil {
+				break
+			}
+			ans[item.name] = append(ans[item.name], *rec)
+		}
+		if err := iter.Close(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	ans["tokenFreqs"] = cr.coProc.TokenCounts.records()
+	return ans
+}
+
+// countVertical counts syntactic pairs and window co-occurrences
+// of a vertical file using the provided number of workers
+func countVertical(
+	tb testing.TB,
+	vertPath string,
+	conf *SyntaxProps,
+	numWorkers int,
+) *countResult {
+	return countVerticalWithBudget(tb, vertPath, conf, numWorkers, 0)
+}
+
+// countVerticalWithBudget counts a vertical file like countVertical
+// with tables of each worker limited to budgetLimit bytes
+// (zero for no limit)
+func countVerticalWithBudget(
+	tb testing.TB,
+	vertPath string,
+	conf *SyntaxProps,
+	numWorkers int,
+	budgetLimit int64,
+) *countResult {
+	spills := newImportSpills(tb.TempDir(), "test")
+	tb.Cleanup(spills.Close)
+	validator := newVertValidator(vertPath, conf, -1)
+	procs, err := parseVerticalParallel(
+		vertPath,
+		numWorkers,
+		nil,
+		func() *VertProcessor {
+			return newVertProcessor(conf, spills, &memoryBudget{limit: budgetLimit}, validator)
+		},
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	proc, err := mergeVertProcessors(procs)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	coProcs, err := parseVerticalParallel(
+		vertPath,
+		numWorkers,
+		nil,
+		func() *CoVertProcessor {
+			return newCoVertProcessor(
+				conf, 2, anyPair{}, spills, &memoryBudget{limit: budgetLimit}, validator)
+		},
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	coProc, err := mergeCoVertProcessors(coProcs)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &countResult{proc: proc, coProc: coProc, spills: spills}
+}
+
+func compareProcessors(t *testing.T, expected, found *countResult) {
+	expectedTables := expected.tables(t)
+	foundTables := found.tables(t)
+	for _, table := range []string{
+		"fcolls", "childSums", "parentSums", "triples", "coOccs", "tokenFreqs"} {
+		compareRecords(t, table, expectedTables[table], foundTables[table])
+	}
+	if expected.coProc.size() != found.coProc.size() {
+		t.Errorf(
+			"expected corpus size %v, found %v", expected.coProc.size(), found.coProc.size())
+	}
+}
+
+// TestParallelCountingMatchesSequential tests that chunks cutting through
+// sentences (and through tokens outside sentences) are completed using
+// overrun lines and their leading tails are not counted twice
+func TestParallelCountingMatchesSequential(t *testing.T) {
+	conf := testSyntaxProps(t)
+	vertPath := writeTestVertical(
+		t,
+		testVerticalSpec{numTokens: 20000, vocabSize: 300, outsideEvery: 37},
+	)
+	expected := countVertical(t, vertPath, conf, 1)
+	for _, numWorkers := range []int{2, 7, 31} {
+		t.Run(fmt.Sprintf("workers=%d", numWorkers), func(t *testing.T) {
+			compareProcessors(t, expected, countVertical(t, vertPath, conf, numWorkers))
+		})
+	}
+}
+
+// TestParallelCountingLongSentences tests sentences split due to their
+// length (see maxSentenceTokens). With more workers, some of the chunks
+// start and end within a single sentence.
+func TestParallelCountingLongSentences(t *testing.T) {
+	conf := testSyntaxProps(t)
+	vertPath := writeTestVertical(
+		t,
+		testVerticalSpec{
+			numTokens:     30000,
+			vocabSize:     300,
+			longSentences: []int{maxSentenceTokens + 500, 3*maxSentenceTokens + 10},
+			outsideEvery:  37,
+		},
+	)
+	expected := countVertical(t, vertPath, conf, 1)
+	for _, numWorkers := range []int{2, 7, 31} {
+		t.Run(fmt.Sprintf("workers=%d", numWorkers), func(t *testing.T) {
+			compareProcessors(t, expected, countVertical(t, vertPath, conf, numWorkers))
+		})
+	}
+}
+
+// TestSpilledCountingMatchesInMemory tests that tables spilled to disk
+// (many times, so the run files are also compacted) provide the same
+// results as tables counted in memory
+func TestSpilledCountingMatchesInMemory(t *testing.T) {
+	conf := testSyntaxProps(t)
+	vertPath := writeTestVertical(
+		t,
+		testVerticalSpec{numTokens: 20000, vocabSize: 300, outsideEvery: 37},
+	)
+	expected := countVertical(t, vertPath, conf, 1)
+	for _, numWorkers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", numWorkers), func(t *testing.T) {
+			found := countVerticalWithBudget(t, vertPath, conf, numWorkers, 4096)
+			for _, store := range []*spillStore{
+				found.spills.table, found.spills.children, found.spills.parents,
+				found.spills.triples, found.spills.coOccs} {
+				if !store.HasRuns() {
+					t.Fatalf("expected spilled %s", store.prefix)
+				}
+			}
+			compareProcessors(t, expected, found)
+		})
+	}
+}
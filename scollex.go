@@ -19,6 +19,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
@@ -50,9 +51,80 @@ type VersionInfo struct {
 	GitCommit string `json:"gitCommit"`
 }
 
+const (
+	generationsCleanupInterval = time.Hour
+)
+
 func init() {
 }
 
+// runGenerationsCleanup periodically removes previous generations
+// of corpora tables once their rollback window expires
+func runGenerationsCleanup(ctx context.Context, conf *cnf.Conf, sqlDB *sql.DB) {
+	ticker := time.NewTicker(generationsCleanupInterval)
+	defer ticker.Stop()
+	for {
+		for _, corp := range conf.Corpora {
+			cdb := engine.NewCollDatabase(sqlDB, corp.Name)
+			if err := cdb.DropExpiredGeneration(*conf.RollbackWindowHours); err != nil {
+				log.Error().
+					Err(err).
+					Str("corpusId", corp.Name).
+					Msg("failed to drop expired generation of tables")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkCorpusSizes compares configured corpora sizes with the ones
+// recorded during import and logs possible differences
+func checkCorpusSizes(conf *cnf.Conf, sqlDB *sql.DB) {
+	for _, corp := range conf.Corpora {
+		cdb := engine.NewCollDatabase(sqlDB, corp.Name)
+		size, err := cdb.GetCorpusSize()
+		if err == engine.ErrNoImportMetadata {
+			log.Warn().
+				Str("corpusId", corp.Name).
+				Int64("configuredSize", corp.Size).
+				Msg("corpus size not recorded in database, using configured value (re-import recommended)")
+
+		} else if err != nil {
+			log.Error().
+				Err(err).
+				Str("corpusId", corp.Name).
+				Msg("failed to get recorded corpus size")
+
+		} else if corp.Size > 0 && size.NumTokens != corp.Size {
+			log.Warn().
+				Str("corpusId", corp.Name).
+				Int64("configuredSize", corp.Size).
+				Int64("recordedSize", size.NumTokens).
+				Msg("configured corpus size differs from the recorded one, using the recorded value")
+		}
+	}
+}
+
+// checkSchemaVersions tests whether tables of all the configured
+// corpora are compatible with this version of scollex
+func checkSchemaVersions(conf *cnf.Conf, sqlDB *sql.DB) error {
+	for _, corp := range conf.Corpora {
+		cdb := engine.NewCollDatabase(sqlDB, corp.Name)
+		err := cdb.CheckSchemaVersion()
+		if err == engine.ErrCorpusNotImported {
+			log.Warn().Str("corpusId", corp.Name).Msg("corpus data not imported yet")
+
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func runApiServer(
 	conf *cnf.Conf,
 	syscallChan chan os.Signal,
@@ -71,6 +143,7 @@ func runApiServer(
 	engine.NoMethod(uniresp.NoMethodHandler)
 	engine.NoRoute(uniresp.NotFoundHandler)
 
+	checkCorpusSizes(conf, sqlDB)
 	fcollActions := NewActions(&conf.Corpora, sqlDB)
 
 	engine.GET(
@@ -79,12 +152,52 @@ func runApiServer(
 	engine.GET(
 		"/query/:corpusId/modifiers-of", fcollActions.ModifiersOf)
 
+	engine.GET(
+		"/query/:corpusId/noun-modified-by-via-prep", fcollActions.NounsModifiedByViaPrep)
+
+	engine.GET(
+		"/query/:corpusId/modifiers-of-via-prep", fcollActions.ModifiersOfViaPrep)
+
 	engine.GET(
 		"/query/:corpusId/verbs-subject", fcollActions.VerbsSubject)
 
 	engine.GET(
 		"/query/:corpusId/verbs-object", fcollActions.VerbsObject)
 
+	engine.GET(
+		"/query/:corpusId/verbs-deep-subject", fcollActions.VerbsDeepSubject)
+
+	engine.GET(
+		"/query/:corpusId/verbs-deep-object", fcollActions.VerbsDeepObject)
+
+	engine.GET(
+		"/query/:corpusId/verbs-oblique", fcollActions.VerbsOblique)
+
+	engine.GET(
+		"/query/:corpusId/obliques-of", fcollActions.ObliquesOf)
+
+	engine.GET(
+		"/query/:corpusId/verbs-oblique-via-prep", fcollActions.VerbsObliqueViaPrep)
+
+	engine.GET(
+		"/query/:corpusId/obliques-of-via-prep", fcollActions.ObliquesOfViaPrep)
+
+	engine.GET(
+		"/query/:corpusId/triples", fcollActions.Triples)
+
+	engine.GET(
+		"/query/:corpusId/coordinated-with", fcollActions.CoordinatedWith)
+
+	engine.GET(
+		"/query/:corpusId/coordinated-with-via-conj", fcollActions.CoordinatedWithViaConj)
+
+	engine.GET(
+		"/corpora/:corpusId/info", fcollActions.CorpusInfo)
+
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	go runGenerationsCleanup(cleanupCtx, conf, sqlDB)
+
 	log.Info().Msgf("starting to listen at %s:%d", conf.ListenAddress, conf.ListenPort)
 	srv := &http.Server{
 		Handler:      engine,
@@ -121,6 +234,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "SCollEx - a Syntactic Collocations explorer\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\t%s [options] start [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] import [config.json] [corpus ID] [path to vertical file]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] analyze [config.json] [corpus ID] [path to vertical file]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] rollback [config.json] [corpus ID]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] migrate [config.json] [corpus ID]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] test [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] version\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
@@ -132,8 +248,40 @@ func main() {
 		startCmd.PrintDefaults()
 	}
 	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
-	forceOverwriteTbl := importCmd.Bool("f", false, "Drop target tables in case they already exist")
+	forceOverwriteTbl := importCmd.Bool("f", false, "Deprecated - data are always imported into fresh staging tables")
 	coOccSpan := importCmd.Int("colloc-flags-with-span", 2, "Defines window size for calculating coocurrences")
+	memLimit := importCmd.Int("mem-limit", 0, "Approximate memory budget (in MB) for counting tables; when exceeded, partial counts are spilled to disk (0 = no limit)")
+	spillDir := importCmd.String("spill-dir", "", "Directory for temporary files with spilled partial counts (default: system temp dir)")
+	singlePass := importCmd.Bool("single-pass", false, "Count syntactic pairs and co-occurrences in a single pass over the vertical file (faster but needs more memory or disk space)")
+	numWorkers := importCmd.Int("workers", 0, "Number of parallel workers processing the vertical file (default: maxNumConcurrentJobs from config)")
+	validationReport := importCmd.String("validation-report", "", "Path of a JSON file to write a summary of errors found in the vertical file to")
+	statusFile := importCmd.String("status-file", "", "Path of a JSON file to periodically write the import status (progress, ETA) to")
+	progressInterval := importCmd.Int("progress-interval", 10, "How often (in seconds) the import progress is reported")
+	resume := importCmd.Bool("resume", false, "Continue an interrupted import from its last checkpoint")
+	checkpointDir := importCmd.String("checkpoint-dir", "", "Directory for checkpoints of import phases (default: scollex-checkpoint-[corpus ID] in spill dir)")
+	bulkLoad := importCmd.Bool("bulk-load", false, "Load tables from temporary TSV files using LOAD DATA LOCAL INFILE (requires local_infile enabled on the server)")
+	appendData := importCmd.Bool("append", false, "Add counts of the vertical file to the existing data instead of replacing them")
+
+	analyzeCmd := flag.NewFlagSet("analyze", flag.ExitOnError)
+	analyzeCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\t%s analyze [options] [config.json] [corpus ID] [path to vertical file]\n", filepath.Base(os.Args[0]))
+		analyzeCmd.PrintDefaults()
+	}
+	analyzeWorkers := analyzeCmd.Int("workers", 0, "Number of parallel workers processing the vertical file (default: maxNumConcurrentJobs from config)")
+	analyzeTop := analyzeCmd.Int("top", 50, "Maximum number of listed deprel and PoS values (0 = all)")
+	analyzeOutput := analyzeCmd.String("o", "", "Path of a JSON file to write the report to (default: stdout)")
+
+	rollbackCmd := flag.NewFlagSet("rollback", flag.ExitOnError)
+	rollbackCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\t%s rollback [config.json] [corpus ID]\n", filepath.Base(os.Args[0]))
+		rollbackCmd.PrintDefaults()
+	}
+
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	migrateCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\t%s migrate [config.json] [corpus ID]\n", filepath.Base(os.Args[0]))
+		migrateCmd.PrintDefaults()
+	}
 
 	action := os.Args[1]
 	if action == "version" {
@@ -171,11 +319,18 @@ func main() {
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to open database connection")
 		}
+		if err := checkSchemaVersions(conf, sqlDB); err != nil {
+			log.Fatal().Err(err).Msg("incompatible database schema, refusing to start")
+		}
 
 		runApiServer(conf, syscallChan, exitEvent, sqlDB)
 	case "import":
 		importCmd.Parse(os.Args[2:])
 		conf := cnf.LoadConfig(importCmd.Arg(0))
+		cnf.ValidateAndDefaults(conf)
+		if *numWorkers == 0 {
+			*numWorkers = conf.MaxNumConcurrentJobs
+		}
 		sqlDB, err := engine.Open(conf.DB)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to open database connection")
@@ -186,28 +341,112 @@ func main() {
 			log.Fatal().Msgf("corpus `%s` not installed", importCmd.Arg(1))
 			return
 		}
+		if *forceOverwriteTbl {
+			log.Warn().Msg("the -f flag is deprecated and has no effect")
+		}
 		cdb := engine.NewCollDatabase(sqlDB, importCmd.Arg(1))
-		err = cdb.InitializeDB(sqlDB, *forceOverwriteTbl)
+		if err := cdb.DropExpiredGeneration(*conf.RollbackWindowHours); err != nil {
+			log.Fatal().Err(err).Msg("failed to drop expired generation of tables")
+		}
+		err = engine.RunPg(
+			importCmd.Arg(1),
+			importCmd.Arg(2),
+			&corpProps.Syntax,
+			sqlDB,
+			engine.ImportOptions{
+				CoOccSpan:        *coOccSpan,
+				MemoryLimitMB:    *memLimit,
+				SpillDir:         *spillDir,
+				NumWorkers:       *numWorkers,
+				SinglePass:       *singlePass,
+				Append:           *appendData,
+				Location:         conf.TimezoneLocation(),
+				AppVersion:       version.Version,
+				MaxNumErrors:     *conf.VertMaxNumErrors,
+				ValidationReport: *validationReport,
+				StatusFile:       *statusFile,
+				ProgressInterval: time.Duration(*progressInterval) * time.Second,
+				CheckpointDir:    *checkpointDir,
+				Resume:           *resume,
+				BulkLoad:         *bulkLoad,
+			},
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to process")
+			return
+		}
+	case "analyze":
+		analyzeCmd.Parse(os.Args[2:])
+		conf := cnf.LoadConfig(analyzeCmd.Arg(0))
+		cnf.ValidateAndDefaults(conf)
+		if *analyzeWorkers == 0 {
+			*analyzeWorkers = conf.MaxNumConcurrentJobs
+		}
+		corpProps := conf.Corpora.GetCorpusProps(analyzeCmd.Arg(1))
+		if corpProps == nil {
+			log.Fatal().Msgf("corpus `%s` not installed", analyzeCmd.Arg(1))
+			return
+		}
+		report, err := engine.Analyze(
+			analyzeCmd.Arg(2),
+			&corpProps.Syntax,
+			engine.AnalyzeOptions{
+				NumWorkers:      *analyzeWorkers,
+				MaxDistribItems: *analyzeTop,
+			},
+		)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to initialize database tables")
+			log.Fatal().Err(err).Msg("failed to analyze vertical file")
+			return
 		}
-		log.Info().Msgf("Testing whether the table %s is ready", cdb.TableName())
-		err = cdb.TestTableReady()
+		data, err := json.MarshalIndent(report, "", "  ")
 		if err != nil {
-			log.Fatal().
-				Err(err).
-				Str("dbHost", conf.DB.Host).
-				Int("dbPort", conf.DB.Port).
-				Str("dbName", conf.DB.Name).
-				Msg("...target db table NOT READY")
+			log.Fatal().Err(err).Msg("failed to encode analysis report")
 			return
+		}
+		if *analyzeOutput != "" {
+			if err := os.WriteFile(*analyzeOutput, data, 0644); err != nil {
+				log.Fatal().Err(err).Msg("failed to write analysis report")
+				return
+			}
+			log.Info().Str("path", *analyzeOutput).Msg("analysis report written")
 
 		} else {
-			log.Info().Msg("... table READY")
+			fmt.Println(string(data))
 		}
-		err = engine.RunPg(importCmd.Arg(1), importCmd.Arg(2), *coOccSpan, &corpProps.Syntax, sqlDB)
+	case "rollback":
+		rollbackCmd.Parse(os.Args[2:])
+		conf := cnf.LoadConfig(rollbackCmd.Arg(0))
+		cnf.ValidateAndDefaults(conf)
+		if conf.Corpora.GetCorpusProps(rollbackCmd.Arg(1)) == nil {
+			log.Fatal().Msgf("corpus `%s` not installed", rollbackCmd.Arg(1))
+			return
+		}
+		sqlDB, err := engine.Open(conf.DB)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to process")
+			log.Fatal().Err(err).Msg("failed to open database connection")
+		}
+		cdb := engine.NewCollDatabase(sqlDB, rollbackCmd.Arg(1))
+		if err := cdb.Rollback(); err != nil {
+			log.Fatal().Err(err).Msg("failed to rollback")
+			return
+		}
+		log.Info().Msg("previous generation of tables restored")
+	case "migrate":
+		migrateCmd.Parse(os.Args[2:])
+		conf := cnf.LoadConfig(migrateCmd.Arg(0))
+		cnf.ValidateAndDefaults(conf)
+		if conf.Corpora.GetCorpusProps(migrateCmd.Arg(1)) == nil {
+			log.Fatal().Msgf("corpus `%s` not installed", migrateCmd.Arg(1))
+			return
+		}
+		sqlDB, err := engine.Open(conf.DB)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open database connection")
+		}
+		cdb := engine.NewCollDatabase(sqlDB, migrateCmd.Arg(1))
+		if err := cdb.Migrate(); err != nil {
+			log.Fatal().Err(err).Msg("failed to migrate corpus tables")
 			return
 		}
 	default:
@@ -1,26 +1,122 @@
+-- Tables of a corpus `intercorp_v13ud_en` as created by `scollex import`
+-- (schema version 7, see engine.CurrentSchemaVersion). The importer creates
+-- the tables automatically so this file serves mainly as a reference.
+-- Tables created by older versions can be upgraded using
+-- `scollex migrate [config.json] [corpus ID]`.
+
 CREATE TABLE intercorp_v13ud_en_fcolls (
-  id INT PRIMARY KEY AUTO_INCREMENT,
-  lemma varchar NOT NULL,
-  upos varchar NOT NULL,
-  p_lemma varchar NOT NULL,
-  p_upos varchar NOT NULL,
-  deprel varchar NOT NULL,
-  freq int DEFAULT NULL
+  id int(11) NOT NULL AUTO_INCREMENT,
+  lemma varchar(300) NOT NULL,
+  upos varchar(50) NOT NULL,
+  p_lemma varchar(300) NOT NULL,
+  p_upos varchar(50) NOT NULL,
+  deprel varchar(50) NOT NULL,
+  feats varchar(100) NOT NULL DEFAULT '',
+  p_feats varchar(100) NOT NULL DEFAULT '',
+  marker varchar(100) NOT NULL DEFAULT '',
+  polarity varchar(3) NOT NULL DEFAULT '',
+  freq int(11) NOT NULL,
+  co_occurrence_freq int(11) NOT NULL DEFAULT 0,
+  co_occurrence_score FLOAT,
+  PRIMARY KEY (id)
 );
+CREATE INDEX intercorp_v13ud_en_fcolls_lemma_idx ON intercorp_v13ud_en_fcolls(lemma);
+CREATE INDEX intercorp_v13ud_en_fcolls_p_lemma_idx ON intercorp_v13ud_en_fcolls(p_lemma);
 
 CREATE TABLE intercorp_v13ud_en_parent_sums (
-  id INT PRIMARY KEY AUTO_INCREMENT,
-  p_lemma varchar NOT NULL,
-  p_upos varchar NOT NULL,
-  deprel varchar NOT NULL,
-  freq int NOT NULL
+  id int(11) NOT NULL AUTO_INCREMENT,
+  p_lemma varchar(300) NOT NULL,
+  p_upos varchar(50) NOT NULL,
+  deprel varchar(50) NOT NULL,
+  p_feats varchar(100) NOT NULL DEFAULT '',
+  marker varchar(100) NOT NULL DEFAULT '',
+  polarity varchar(3) NOT NULL DEFAULT '',
+  freq int(11) NOT NULL,
+  PRIMARY KEY (id)
 );
+CREATE INDEX intercorp_v13ud_en_parent_sums_p_lemma_idx ON intercorp_v13ud_en_parent_sums(p_lemma);
 
 CREATE TABLE intercorp_v13ud_en_child_sums (
-  id INT PRIMARY KEY AUTO_INCREMENT,
-  lemma varchar NOT NULL,
-  upos varchar NOT NULL,
-  deprel varchar NOT NULL,
-  freq int NOT NULL
+  id int(11) NOT NULL AUTO_INCREMENT,
+  lemma varchar(300) NOT NULL,
+  upos varchar(50) NOT NULL,
+  deprel varchar(50) NOT NULL,
+  feats varchar(100) NOT NULL DEFAULT '',
+  marker varchar(100) NOT NULL DEFAULT '',
+  polarity varchar(3) NOT NULL DEFAULT '',
+  freq int(11) NOT NULL,
+  PRIMARY KEY (id)
+);
+CREATE INDEX intercorp_v13ud_en_child_sums_lemma_idx ON intercorp_v13ud_en_child_sums(lemma);
+
+CREATE TABLE intercorp_v13ud_en_token_freqs (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  lemma varchar(300) NOT NULL,
+  upos varchar(50) NOT NULL,
+  freq int(11) NOT NULL,
+  PRIMARY KEY (id)
+);
+CREATE INDEX intercorp_v13ud_en_token_freqs_lemma_idx ON intercorp_v13ud_en_token_freqs(lemma);
+
+CREATE TABLE intercorp_v13ud_en_triples (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  pattern varchar(50) NOT NULL,
+  lemma varchar(300) NOT NULL,
+  upos varchar(50) NOT NULL,
+  lemma1 varchar(300) NOT NULL,
+  upos1 varchar(50) NOT NULL,
+  lemma2 varchar(300) NOT NULL,
+  upos2 varchar(50) NOT NULL,
+  marker varchar(100) NOT NULL DEFAULT '',
+  freq int(11) NOT NULL,
+  score FLOAT,
+  PRIMARY KEY (id)
+);
+CREATE INDEX intercorp_v13ud_en_triples_lemma_idx ON intercorp_v13ud_en_triples(lemma);
+
+CREATE TABLE intercorp_v13ud_en_sources (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  source_id varchar(64) NOT NULL,
+  path varchar(1000) NOT NULL,
+  num_tokens bigint NOT NULL,
+  num_sentences bigint NOT NULL,
+  num_docs bigint NOT NULL,
+  imported_at DATETIME NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY (source_id)
+);
+
+CREATE TABLE intercorp_v13ud_en_imports (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  import_type varchar(20) NOT NULL,
+  source_path varchar(1000) NOT NULL,
+  source_id varchar(64) NOT NULL,
+  co_occ_span int(11) NOT NULL,
+  scollex_version varchar(50) NOT NULL,
+  fcolls_rows bigint NOT NULL,
+  child_sums_rows bigint NOT NULL,
+  parent_sums_rows bigint NOT NULL,
+  token_freqs_rows bigint NOT NULL,
+  triples_rows bigint NOT NULL DEFAULT 0,
+  started_at varchar(40) NOT NULL,
+  finished_at varchar(40) NOT NULL,
+  PRIMARY KEY (id)
+);
+
+-- shared by all the corpora
+
+CREATE TABLE IF NOT EXISTS scollex_generations (
+  corpus_id varchar(100) NOT NULL,
+  swapped_at DATETIME NOT NULL,
+  PRIMARY KEY (corpus_id)
+);
+
+CREATE TABLE IF NOT EXISTS scollex_schema_versions (
+  corpus_id varchar(100) NOT NULL,
+  version int(11) NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (corpus_id)
 );
 
+INSERT INTO scollex_schema_versions (corpus_id, version, updated_at)
+VALUES ('intercorp_v13ud_en', 7, NOW());